@@ -27,6 +27,7 @@ type connectionPool struct {
 	conns            int
 	keepAlive        bool
 	keepAlivePeriod  time.Duration
+	connectTimeout   time.Duration
 	connections      []*net.TCPConn
 	lock             sync.Mutex
 	connReleasedCond *sync.Cond
@@ -77,14 +78,12 @@ func (cp *connectionPool) Return(conn *net.TCPConn) {
 }
 
 func (cp *connectionPool) connect() (*net.TCPConn, error) {
-	addr, err := net.ResolveTCPAddr("tcp", cp.connectStr)
-	if err != nil {
-		return nil, err
-	}
-	conn, err := net.DialTCP("tcp", nil, addr)
+	// zero connectTimeout means no timeout
+	netConn, err := net.DialTimeout("tcp", cp.connectStr, cp.connectTimeout)
 	if err != nil {
 		return nil, err
 	}
+	conn := netConn.(*net.TCPConn)
 
 	if cp.keepAlive {
 		conn.SetKeepAlive(cp.keepAlive)
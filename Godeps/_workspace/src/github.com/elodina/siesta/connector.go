@@ -413,10 +413,7 @@ func (dc *DefaultConnector) refreshMetadata(topics []string) {
 				panic(fmt.Sprintf("incorrect port in broker connection string: %s", broker))
 			}
 
-			dc.bootstrapLinks = append(dc.bootstrapLinks, NewBrokerLink(&Broker{ID: -1, Host: hostPort[0], Port: int32(port)},
-				dc.config.KeepAlive,
-				dc.config.KeepAliveTimeout,
-				dc.config.MaxConnectionsPerBroker))
+			dc.bootstrapLinks = append(dc.bootstrapLinks, dc.newBrokerLink(&Broker{ID: -1, Host: hostPort[0], Port: int32(port)}))
 		}
 	}
 
@@ -434,7 +431,7 @@ func (dc *DefaultConnector) refreshMetadata(topics []string) {
 func (dc *DefaultConnector) refreshLeaders(response *MetadataResponse) {
 	brokers := make(map[int32]*brokerLink)
 	for _, broker := range response.Brokers {
-		brokers[broker.ID] = NewBrokerLink(broker, dc.config.KeepAlive, dc.config.KeepAliveTimeout, dc.config.MaxConnectionsPerBroker)
+		brokers[broker.ID] = dc.newBrokerLink(broker)
 	}
 
 	if len(brokers) != 0 && len(response.TopicsMetadata) != 0 {
@@ -778,6 +775,12 @@ func (dc *DefaultConnector) offsetValidator(bytes []byte) Response {
 	return response
 }
 
+func (dc *DefaultConnector) newBrokerLink(broker *Broker) *brokerLink {
+	link := NewBrokerLink(broker, dc.config.KeepAlive, dc.config.KeepAliveTimeout, dc.config.MaxConnectionsPerBroker)
+	link.connectionPool.connectTimeout = dc.config.ConnectTimeout
+	return link
+}
+
 type BrokerLink interface {
 	Failed()
 	Succeeded()
@@ -181,11 +181,21 @@ func (md *Message) Write(encoder Encoder) {
 	encoder.Reserve(&CrcSlice{})
 	encoder.WriteInt8(md.MagicByte)
 	encoder.WriteInt8(md.Attributes)
-	encoder.WriteBytes(md.Key)
-	encoder.WriteBytes(md.Value)
+	writeNullableBytes(encoder, md.Key)
+	writeNullableBytes(encoder, md.Value)
 	encoder.UpdateReserved()
 }
 
+// writeNullableBytes writes a nil slice as null (length -1) so that e.g. a message with a nil value is a tombstone
+// rather than a message with an empty value.
+func writeNullableBytes(encoder Encoder, value []byte) {
+	if value == nil {
+		encoder.WriteInt32(-1)
+		return
+	}
+	encoder.WriteBytes(value)
+}
+
 // MessageAndMetadata is a single message and its metadata.
 type MessageAndMetadata struct {
 	Topic     string
@@ -20,9 +20,12 @@ graceful shutdown support notifying client when it is over, batch processing, st
 package go_kafka_client
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -57,6 +60,12 @@ type Consumer struct {
 	stopCleanup                    chan struct{}
 	wg                             sync.WaitGroup
 	topicCount                     TopicsToNumStreams
+	assignment                     []TopicAndPartition
+	subscription                   []string
+	assignmentLock                 sync.Mutex
+	merger                         *timestampMerger
+	rebalanceThrottle              *rebalanceThrottle
+	claimConflicts                 int32
 
 	metrics *ConsumerMetrics
 
@@ -83,13 +92,17 @@ func NewConsumer(config *ConsumerConfig) *Consumer {
 		close:                          make(chan bool),
 	}
 
-	if err := c.config.Coordinator.Connect(); err != nil {
+	c.startMerger()
+
+	if err := c.retryOnStartup("connect to coordinator", c.config.Coordinator.Connect); err != nil {
 		panic(err)
 	}
 	if err := c.config.LowLevelClient.Initialize(); err != nil {
 		panic(err)
 	}
-	c.metrics = newConsumerMetrics(c.String(), config.MetricsPrefix)
+	c.metrics = newConsumerMetrics(c.String(), config.MetricsPrefix, config.MetricsRegistry)
+	c.rebalanceThrottle = newRebalanceThrottle(c.rebalance, config, c.metrics.rebalancesThrottled())
+	c.registerCoordinatorMetrics()
 	c.fetcher = newConsumerFetcherManager(c.config, c.disconnectChannelsForPartition, c.metrics)
 
 	go func() {
@@ -102,12 +115,41 @@ func NewConsumer(config *ConsumerConfig) *Consumer {
 	return c
 }
 
+func (c *Consumer) startMerger() {
+	if c.config.MergeWindow > 0 {
+		c.merger = newTimestampMerger(c.config.Strategy, c.config.MergeWindow, c.config.MessageTimestamp)
+	}
+}
+
+// Returns the strategy worker managers process messages with, i.e. ConsumerConfig.Strategy behind the timestamp merger if MergeWindow is set.
+func (c *Consumer) workerStrategy() WorkerStrategy {
+	if c.merger != nil {
+		return c.merger.merge
+	}
+	return c.config.Strategy
+}
+
+func (c *Consumer) registerCoordinatorMetrics() {
+	if zookeeper, ok := c.config.Coordinator.(*ZookeeperCoordinator); ok {
+		c.metrics.registerZookeeperState(zookeeper.stateGauge)
+	}
+}
+
 func (c *Consumer) String() string {
 	return c.config.Consumerid
 }
 
-/* Starts consuming specified topics using a configured amount of goroutines for each topic. */
+/* Starts consuming specified topics using a configured amount of goroutines for each topic.
+Panics if any of the topics does not exist and ConsumerConfig.FailOnMissingTopics is set. */
 func (c *Consumer) StartStatic(topicCountMap map[string]int) {
+	topics := make([]string, 0, len(topicCountMap))
+	for topic := range topicCountMap {
+		topics = append(topics, topic)
+	}
+	if err := c.validateTopics(topics); err != nil {
+		panic(err)
+	}
+
 	go c.createMessageStreams(topicCountMap)
 
 	c.startStreams()
@@ -119,8 +161,55 @@ func (c *Consumer) StartWildcard(topicFilter TopicFilter, numStreams int) {
 	c.startStreams()
 }
 
-/* Starts consuming given topic-partitions using ConsumerConfig.NumConsumerFetchers goroutines for each topic. */
+/* Starts consuming given topic-partitions using ConsumerConfig.NumConsumerFetchers goroutines for each topic.
+Panics if any of the topics does not exist and ConsumerConfig.FailOnMissingTopics is set. */
 func (c *Consumer) StartStaticPartitions(topicPartitionMap map[string][]int32) {
+	topics := make([]string, 0, len(topicPartitionMap))
+	for topic := range topicPartitionMap {
+		topics = append(topics, topic)
+	}
+	if err := c.validateTopics(topics); err != nil {
+		panic(err)
+	}
+
+	c.startStaticPartitions(topicPartitionMap, nil)
+}
+
+/* Starts consuming given topic-partitions from given offsets regardless of offsets stored in ConsumerConfig.OffsetStorage.
+Processed offsets are still committed. Returns an error without consuming anything if an offset is outside the available
+offset range of its partition. Otherwise blocks like StartStaticPartitions and returns nil once the consumer is closed. */
+func (c *Consumer) AssignAndSeek(offsets map[TopicAndPartition]int64) error {
+	if err := validateSeekOffsets(c.config.LowLevelClient, offsets); err != nil {
+		return err
+	}
+
+	topicPartitionMap := make(map[string][]int32)
+	for topicPartition := range offsets {
+		topicPartitionMap[topicPartition.Topic] = append(topicPartitionMap[topicPartition.Topic], topicPartition.Partition)
+	}
+	c.startStaticPartitions(topicPartitionMap, offsets)
+	return nil
+}
+
+func validateSeekOffsets(client LowLevelClient, offsets map[TopicAndPartition]int64) error {
+	for topicPartition, offset := range offsets {
+		smallest, err := client.GetAvailableOffset(topicPartition.Topic, topicPartition.Partition, SmallestOffset)
+		if err != nil {
+			return err
+		}
+		largest, err := client.GetAvailableOffset(topicPartition.Topic, topicPartition.Partition, LargestOffset)
+		if err != nil {
+			return err
+		}
+		if offset < smallest || offset > largest {
+			return fmt.Errorf("Offset %d for %s is out of available range [%d, %d]", offset, &topicPartition, smallest, largest)
+		}
+	}
+
+	return nil
+}
+
+func (c *Consumer) startStaticPartitions(topicPartitionMap map[string][]int32, seekOffsets map[TopicAndPartition]int64) {
 	topicsToNumStreamsMap := make(map[string]int)
 	for topic := range topicPartitionMap {
 		topicsToNumStreamsMap[topic] = c.config.NumConsumerFetchers
@@ -131,7 +220,7 @@ func (c *Consumer) StartStaticPartitions(topicPartitionMap map[string][]int32) {
 		TopicsToNumStreamsMap: topicsToNumStreamsMap,
 	}
 
-	c.config.Coordinator.RegisterConsumer(c.config.Consumerid, c.config.Groupid, c.topicCount)
+	c.registerConsumer()
 	allTopics, err := c.config.Coordinator.GetAllTopics()
 	if err != nil {
 		panic(err)
@@ -144,29 +233,41 @@ func (c *Consumer) StartStaticPartitions(topicPartitionMap map[string][]int32) {
 	time.Sleep(c.config.DeploymentTimeout)
 
 	assignmentContext := newStaticAssignmentContext(c.config.Groupid, c.config.Consumerid, []string{c.config.Consumerid}, allTopics, brokers, c.topicCount, topicPartitionMap)
-	partitionOwnershipDecision := newPartitionAssignor(c.config.PartitionAssignmentStrategy)(assignmentContext)
+	partitionOwnershipDecision := c.config.PartitionAssignor.Assign(assignmentContext)
 
 	topicPartitions := make([]*TopicAndPartition, 0)
 	for topicPartition, _ := range partitionOwnershipDecision {
 		topicPartitions = append(topicPartitions, &TopicAndPartition{topicPartition.Topic, topicPartition.Partition})
 	}
 
-	offsets, err := c.fetchOffsets(topicPartitions)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to fetch offsets during rebalance: %s", err))
+	offsets := seekOffsets
+	if offsets == nil {
+		offsets, err = c.fetchOffsets(topicPartitions)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to fetch offsets during rebalance: %s", err))
+		}
 	}
 	for _, topicPartition := range topicPartitions {
 		offset := offsets[*topicPartition]
 		threadId := partitionOwnershipDecision[*topicPartition]
 		c.addPartitionTopicInfo(c.topicRegistry, topicPartition, offset, threadId)
+		if seekOffsets != nil {
+			c.topicRegistry[topicPartition.Topic][topicPartition.Partition].Seek = true
+		}
 	}
 
-	if c.reflectPartitionOwnershipDecision(partitionOwnershipDecision) {
-		c.updateFetcher(c.config.NumConsumerFetchers)
-		c.initializeWorkerManagers()
-	} else {
-		panic("Could not reflect partition ownership")
+	err = c.retryOnStartup("claim partition ownership", func() error {
+		if !c.reflectPartitionOwnershipDecision(partitionOwnershipDecision) {
+			return errors.New("Could not reflect partition ownership")
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
 	}
+	c.updateAssignment(assignmentContext)
+	c.updateFetcher(c.config.NumConsumerFetchers)
+	c.initializeWorkerManagers()
 
 	go func() {
 		Infof(c, "Restarted streams")
@@ -176,6 +277,80 @@ func (c *Consumer) StartStaticPartitions(topicPartitionMap map[string][]int32) {
 	c.startStreams()
 }
 
+/* Consumes messages with offsets in range [start, end) from a given topic-partition using configured Strategy without joining the consumer group.
+Offsets are not committed in this mode. If end is beyond the log end, waits for new messages if ConsumerConfig.RangeAwaitEnd is set and stops at the log end otherwise.
+This method is NOT blocking but returns a channel which will get nil once the range is consumed or an error if it could not be consumed. */
+func (c *Consumer) ConsumeRange(topicPartition TopicAndPartition, start int64, end int64) <-chan error {
+	finished := make(chan error, 1)
+	go func() {
+		finished <- c.consumeRange(topicPartition, start, end)
+	}()
+	return finished
+}
+
+func (c *Consumer) consumeRange(topicPartition TopicAndPartition, start int64, end int64) error {
+	if start < 0 || end < start {
+		return fmt.Errorf("Invalid offset range [%d, %d) for %s", start, end, &topicPartition)
+	}
+
+	rangeConfig := *c.config
+	rangeConfig.OffsetStorage = &noopOffsetStorage{}
+	stopRange := make(chan bool, 1)
+	workerManager := NewWorkerManager(fmt.Sprintf("WM-range-%s-%d", topicPartition.Topic, topicPartition.Partition), &rangeConfig, topicPartition, c.metrics, stopRange)
+	workerManager.strategy = c.workerStrategy()
+	go workerManager.Start()
+	defer func() {
+		<-workerManager.Stop()
+	}()
+
+	offset := start
+	for offset < end {
+		select {
+		case <-stopRange:
+			return fmt.Errorf("Consuming range [%d, %d) for %s stopped at offset %d", start, end, &topicPartition, offset)
+		default:
+		}
+
+		messages, err := c.config.LowLevelClient.Fetch(topicPartition.Topic, topicPartition.Partition, offset)
+		if err != nil {
+			return err
+		}
+
+		batch := make([]*Message, 0, len(messages))
+		pastEnd := false
+		for _, message := range messages {
+			if message.Offset >= end {
+				pastEnd = true
+			} else if message.Offset >= offset {
+				batch = append(batch, message)
+			}
+		}
+
+		if len(batch) == 0 {
+			if pastEnd {
+				// the rest of the range is a gap, e.g. of a compacted topic
+				Infof(c, "No messages left in range [%d, %d) for %s after offset %d", start, end, &topicPartition, offset)
+				return nil
+			}
+			logEnd, err := c.config.LowLevelClient.GetAvailableOffset(topicPartition.Topic, topicPartition.Partition, LargestOffset)
+			if err != nil {
+				return err
+			}
+			if logEnd <= offset && !c.config.RangeAwaitEnd {
+				Infof(c, "Reached log end %d for %s before range end %d", logEnd, &topicPartition, end)
+				return nil
+			}
+			time.Sleep(c.config.RequeueAskNextBackoff)
+			continue
+		}
+
+		workerManager.inputChannel <- batch
+		offset = batch[len(batch)-1].Offset + 1
+	}
+
+	return nil
+}
+
 func (c *Consumer) startStreams() {
 	c.maintainCleanCoordinator()
 	stopRedirects := make(map[TopicAndPartition]chan bool)
@@ -263,13 +438,88 @@ func (c *Consumer) disconnectChannels(stopRedirects map[TopicAndPartition]chan b
 	}
 }
 
+func (c *Consumer) registerConsumer() {
+	err := c.retryOnStartup("register consumer", func() error {
+		return c.config.Coordinator.RegisterConsumer(c.config.Consumerid, c.config.Groupid, c.topicCount)
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Retries a given coordinator operation until it succeeds or ConsumerConfig.CoordinatorStartupTimeout is over.
+// Returns the last error if the operation did not succeed in time.
+func (c *Consumer) retryOnStartup(operation string, action func() error) error {
+	deadline := time.Now().Add(c.config.CoordinatorStartupTimeout)
+	for {
+		err := action()
+		if err == nil || time.Now().Add(c.config.CoordinatorStartupBackoff).After(deadline) {
+			return err
+		}
+		Warnf(c, "Failed to %s: %s. Retrying in %s", operation, err, c.config.CoordinatorStartupBackoff)
+		time.Sleep(c.config.CoordinatorStartupBackoff)
+	}
+}
+
+// Checks that all given topics exist and logs their partition counts. Returns an error if any of them is missing or they
+// can't be checked and ConsumerConfig.FailOnMissingTopics is set, otherwise only warns.
+func (c *Consumer) validateTopics(topics []string) error {
+	err := c.checkTopics(topics)
+	if err != nil && !c.config.FailOnMissingTopics {
+		Warnf(c, "Failed to validate subscribed topics %v: %s", topics, err)
+		return nil
+	}
+	return err
+}
+
+func (c *Consumer) checkTopics(topics []string) error {
+	var allTopics []string
+	err := c.retryOnStartup("get all topics", func() error {
+		var err error
+		allTopics, err = c.config.Coordinator.GetAllTopics()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	existing := make([]string, 0)
+	missing := make([]string, 0)
+	for _, topic := range topics {
+		if position(&allTopics, topic) >= 0 {
+			existing = append(existing, topic)
+		} else {
+			missing = append(missing, topic)
+		}
+	}
+
+	if len(existing) > 0 {
+		partitions, err := c.config.Coordinator.GetPartitionsForTopics(existing)
+		if err != nil {
+			return err
+		}
+		for _, topic := range existing {
+			Infof(c, "Subscribed topic %s has %d partitions", topic, len(partitions[topic]))
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		if c.config.FailOnMissingTopics {
+			return fmt.Errorf("Subscribed topics %v do not exist", missing)
+		}
+		Warnf(c, "Subscribed topics %v do not exist, nothing will be consumed from them until they are created", missing)
+	}
+	return nil
+}
+
 func (c *Consumer) createMessageStreams(topicCountMap map[string]int) {
 	c.topicCount = &StaticTopicsToNumStreams{
 		ConsumerId:            c.config.Consumerid,
 		TopicsToNumStreamsMap: topicCountMap,
 	}
 
-	c.config.Coordinator.RegisterConsumer(c.config.Consumerid, c.config.Groupid, c.topicCount)
+	c.registerConsumer()
 
 	time.Sleep(c.config.DeploymentTimeout)
 
@@ -285,7 +535,7 @@ func (c *Consumer) createMessageStreamsByFilterN(topicFilter TopicFilter, numStr
 		ExcludeInternalTopics: c.config.ExcludeInternalTopics,
 	}
 
-	c.config.Coordinator.RegisterConsumer(c.config.Consumerid, c.config.Groupid, c.topicCount)
+	c.registerConsumer()
 
 	time.Sleep(c.config.DeploymentTimeout)
 
@@ -312,6 +562,7 @@ func (c *Consumer) initializeWorkerManagers() {
 				workerManager, exists := c.workerManagers[topicPartition]
 				if !exists {
 					workerManager = NewWorkerManager(fmt.Sprintf("WM-%s-%d", topic, partition), c.config, topicPartition, c.metrics, c.close)
+					workerManager.strategy = c.workerStrategy()
 					c.workerManagers[topicPartition] = workerManager
 					go workerManager.Start()
 				}
@@ -345,6 +596,9 @@ func (c *Consumer) Close() <-chan bool {
 		}
 
 		c.stopStreams <- true
+		if c.merger != nil {
+			c.merger.stop()
+		}
 
 		Info(c, "Deregistering consumer")
 		c.config.Coordinator.DeregisterConsumer(c.config.Consumerid, c.config.Groupid)
@@ -372,7 +626,7 @@ func (c *Consumer) Close() <-chan bool {
 }
 
 func (c *Consumer) handleBlueGreenRequest(requestId string, blueGreenRequest *BlueGreenDeployment) {
-	var context *assignmentContext
+	var context *AssignmentContext
 	//Waiting for everybody in group to acknowledge the request, then closing
 	inLock(&c.rebalanceLock, func() {
 		Infof(c, "Starting blue-green procedure for: %s", blueGreenRequest)
@@ -477,13 +731,20 @@ func (c *Consumer) handleBlueGreenRequest(requestId string, blueGreenRequest *Bl
 	})
 }
 
-func (c *Consumer) resumeAfterClose(context *assignmentContext) {
+func (c *Consumer) resumeAfterClose(context *AssignmentContext) {
 	c.isShuttingdown = false
 	c.workerManagers = make(map[TopicAndPartition]*WorkerManager)
 	c.topicPartitionsAndBuffers = make(map[TopicAndPartition]*messageBuffer)
 	c.config.LowLevelClient.Initialize()
+	paused := c.fetcher.isPaused()
 	c.fetcher = newConsumerFetcherManager(c.config, c.disconnectChannelsForPartition, c.metrics)
-	c.metrics = newConsumerMetrics(c.String(), c.config.MetricsPrefix)
+	if paused {
+		c.fetcher.pause()
+	}
+	c.metrics = newConsumerMetrics(c.String(), c.config.MetricsPrefix, c.config.MetricsRegistry)
+	c.rebalanceThrottle.countThrottledIn(c.metrics.rebalancesThrottled())
+	c.registerCoordinatorMetrics()
+	c.startMerger()
 
 	go func() {
 		<-c.close
@@ -494,8 +755,8 @@ func (c *Consumer) resumeAfterClose(context *assignmentContext) {
 
 	go c.startStreams()
 
-	partitionAssignor := newPartitionAssignor(c.config.PartitionAssignmentStrategy)
-	partitionOwnershipDecision := partitionAssignor(context)
+	partitionAssignor := c.config.PartitionAssignor
+	partitionOwnershipDecision := partitionAssignor.Assign(context)
 	topicPartitions := make([]*TopicAndPartition, 0)
 	for topicPartition, _ := range partitionOwnershipDecision {
 		topicPartitions = append(topicPartitions, &TopicAndPartition{topicPartition.Topic, topicPartition.Partition})
@@ -530,7 +791,21 @@ func (c *Consumer) stopWorkerManagers() bool {
 			wmsAreStopped := make(chan bool)
 			wmStopChannels := make([]chan bool, 0)
 			for _, wm := range c.workerManagers {
-				wmStopChannels = append(wmStopChannels, wm.Stop())
+				if wm.Quarantined() {
+					// a stalled partition should not hold up the rest, it finishes stopping once its current task is done
+					Warnf(c, "Not waiting for quarantined worker manager %s to stop", wm)
+					stopped := wm.stop(c.config.CommitOnShutdown)
+					go func() {
+						<-stopped
+					}()
+					continue
+				}
+				wmStopChannels = append(wmStopChannels, wm.stop(c.config.CommitOnShutdown))
+			}
+			if len(wmStopChannels) == 0 {
+				c.workerManagers = make(map[TopicAndPartition]*WorkerManager)
+				success = true
+				return
 			}
 			Debugf(c, "Worker channels length: %d", len(wmStopChannels))
 			notifyWhenThresholdIsReached(wmStopChannels, wmsAreStopped, len(wmStopChannels))
@@ -613,7 +888,7 @@ func (c *Consumer) subscribeForChanges(group string) {
 						// If it's not a blue-green request do a rebalance.
 						// Even it it's a Reinitialize event, make sure we drop partition ownership and re-discover what
 						// topic partitions we should be working on.
-						go c.rebalance()
+						c.rebalanceThrottle.trigger()
 					}
 				}
 			case <-c.unsubscribe:
@@ -639,9 +914,11 @@ func (c *Consumer) rebalance() {
 			if Logger.IsAllowed(InfoLevel) {
 				Infof(c, "rebalance triggered for %s\n", c.config.Consumerid)
 			}
+			rebalanceStart := time.Now()
+			atomic.StoreInt32(&c.claimConflicts, 0)
 			for i := 0; i <= int(c.config.RebalanceMaxRetries) && !success; i++ {
-				partitionAssignor := newPartitionAssignor(c.config.PartitionAssignmentStrategy)
-				var context *assignmentContext
+				partitionAssignor := c.config.PartitionAssignor
+				var context *AssignmentContext
 				var err error
 				barrierPassed := false
 				timeLimit := time.Now().Add(3 * time.Minute)
@@ -663,6 +940,11 @@ func (c *Consumer) rebalance() {
 						}
 						return
 					}
+					if !c.CommitOffsets() {
+						if Logger.IsAllowed(WarnLevel) {
+							Warn(c, "Failed to commit processed offsets before releasing partition ownership")
+						}
+					}
 					c.releasePartitionOwnership(c.topicRegistry)
 					err = c.config.Coordinator.RemoveStateBarrier(c.config.Groupid, fmt.Sprintf("%s-ack", stateHash), string(Rebalance))
 					if err != nil {
@@ -701,10 +983,20 @@ func (c *Consumer) rebalance() {
 				}
 				barrierTimeout += c.config.BarrierTimeout
 			}
-			if !success && !c.isShuttingdown {
+			if !success && !c.isShuttingdown && atomic.LoadInt32(&c.claimConflicts) > 0 {
+				// Partitions are still owned by other consumers that haven't released them yet, so try again later instead of giving up.
+				if Logger.IsAllowed(WarnLevel) {
+					Warnf(c, "Failed to rebalance after %d retries due to %d partition claim conflicts, triggering rebalance again", c.config.RebalanceMaxRetries, atomic.LoadInt32(&c.claimConflicts))
+				}
+				c.lastSuccessfulRebalanceHash = ""
+				c.rebalanceThrottle.trigger()
+			} else if !success && !c.isShuttingdown {
 				panic(fmt.Sprintf("Failed to rebalance after %d retries", c.config.RebalanceMaxRetries))
 			} else {
 				c.lastSuccessfulRebalanceHash = stateHash
+				if success {
+					c.metrics.rebalanceCompleted(rebalanceStart)
+				}
 				if Logger.IsAllowed(InfoLevel) {
 					Info(c, "Rebalance has been successfully completed")
 				}
@@ -717,8 +1009,8 @@ func (c *Consumer) rebalance() {
 	}
 }
 
-func tryRebalance(c *Consumer, context *assignmentContext, partitionAssignor assignStrategy) bool {
-	partitionOwnershipDecision := partitionAssignor(context)
+func tryRebalance(c *Consumer, context *AssignmentContext, partitionAssignor PartitionAssignor) bool {
+	partitionOwnershipDecision := partitionAssignor.Assign(context)
 	topicPartitions := make([]*TopicAndPartition, 0)
 	for topicPartition, _ := range partitionOwnershipDecision {
 		topicPartitions = append(topicPartitions, &TopicAndPartition{topicPartition.Topic, topicPartition.Partition})
@@ -784,7 +1076,8 @@ func tryRebalance(c *Consumer, context *assignmentContext, partitionAssignor ass
 	return true
 }
 
-func (c *Consumer) initFetchersAndWorkers(assignmentContext *assignmentContext) {
+func (c *Consumer) initFetchersAndWorkers(assignmentContext *AssignmentContext) {
+	c.updateAssignment(assignmentContext)
 	switch topicCount := assignmentContext.MyTopicToNumStreams.(type) {
 	case *StaticTopicsToNumStreams:
 		{
@@ -913,6 +1206,8 @@ func (c *Consumer) claimPartitionOwnershipFunc(topicPartition TopicAndPartition,
 			}
 			successChan <- topicPartition
 		} else {
+			c.metrics.claimConflicts().Inc(1)
+			atomic.AddInt32(&c.claimConflicts, 1)
 			if Logger.IsAllowed(WarnLevel) {
 				Warnf(c, "Consumer failed to claim partition %d for topic %s", topicPartition.Partition, topicPartition.Topic)
 			}
@@ -932,11 +1227,148 @@ func (c *Consumer) releasePartitionOwnership(localtopicRegistry map[string]map[i
 		}
 		delete(localtopicRegistry, topic)
 	}
+	c.updateAssignment(nil)
 	if Logger.IsAllowed(InfoLevel) {
 		Info(c, "Successfully released partition ownership")
 	}
 }
 
+// Returns the partitions currently owned by this consumer, sorted by topic and partition.
+// The result is a snapshot taken after the last completed rebalance and is empty while partitions are being reassigned.
+func (c *Consumer) Assignment() []TopicAndPartition {
+	var assignment []TopicAndPartition
+	inLock(&c.assignmentLock, func() {
+		assignment = append([]TopicAndPartition{}, c.assignment...)
+	})
+	return assignment
+}
+
+// Returns the topics this consumer is subscribed to, sorted. For wildcard subscriptions these are the topics
+// that matched the filter during the last rebalance.
+func (c *Consumer) Subscription() []string {
+	var subscription []string
+	inLock(&c.assignmentLock, func() {
+		subscription = append([]string{}, c.subscription...)
+	})
+	return subscription
+}
+
+// Returns the offset of the next message to be fetched for a given partition owned by this consumer. It advances as messages
+// are fetched regardless of the committed offset; messages before it may still be buffered or processed. Returns an error if
+// the partition is not owned by this consumer.
+func (c *Consumer) Position(topicPartition TopicAndPartition) (int64, error) {
+	offset, owned := c.fetcher.position(topicPartition)
+	if !owned {
+		return InvalidOffset, fmt.Errorf("Partition %s is not owned by consumer %s", &topicPartition, c.config.Consumerid)
+	}
+	return offset, nil
+}
+
+// Takes a snapshot of owned partitions from the topic registry. The subscription is taken from a given assignment context
+// and is left unchanged if the context is nil.
+func (c *Consumer) updateAssignment(assignmentContext *AssignmentContext) {
+	assignment := make([]TopicAndPartition, 0)
+	for topic, partitions := range c.topicRegistry {
+		for partition := range partitions {
+			assignment = append(assignment, TopicAndPartition{topic, partition})
+		}
+	}
+	sort.Sort(byTopicAndPartition(assignment))
+
+	inLock(&c.assignmentLock, func() {
+		c.assignment = assignment
+		if assignmentContext != nil {
+			c.subscription = make([]string, 0, len(assignmentContext.MyTopicThreadIds))
+			for topic := range assignmentContext.MyTopicThreadIds {
+				c.subscription = append(c.subscription, topic)
+			}
+			sort.Strings(c.subscription)
+		}
+	})
+}
+
+// Commits the highest processed offsets for all partitions owned by this consumer.
+// This is also done before partition ownership is released during rebalance so that the new owners don't reprocess messages.
+// Returns true if all offsets were committed successfully, false otherwise.
+func (c *Consumer) CommitOffsets() bool {
+	success := true
+	inLock(&c.workerManagersLock, func() {
+		for _, wm := range c.workerManagers {
+			if !wm.commitOffset() {
+				success = false
+			}
+		}
+	})
+
+	return success
+}
+
+// Stops fetching messages for all partitions owned by this consumer without leaving the consumer group, e.g. for maintenance windows.
+// Messages that have already been fetched are still processed. The consumer stays registered in the coordinator, so its
+// session is kept alive and no rebalance is triggered. Partitions assigned while paused are not fetched until ResumeAll is called.
+func (c *Consumer) PauseAll() {
+	c.fetcher.pause()
+}
+
+// Resumes fetching for all partitions owned by this consumer from the offsets they were paused at.
+func (c *Consumer) ResumeAll() {
+	c.fetcher.resume()
+}
+
+// Checkpoint pauses processing of all partitions owned by this consumer once their current batches are done, commits their
+// processed offsets and calls ConsumerConfig.OnCheckpoint with a given id and the committed offsets before processing resumes.
+// Offsets are committed partition by partition, so a failed checkpoint may have committed some of them; OnCheckpoint is
+// only called if all commits succeeded. Processing is resumed in any case. Note that Checkpoint waits for partitions that
+// are stuck on a message and must not be called from a Strategy.
+func (c *Consumer) Checkpoint(id string) error {
+	workerManagers := make(map[TopicAndPartition]*WorkerManager)
+	inLock(&c.workerManagersLock, func() {
+		for topicPartition, wm := range c.workerManagers {
+			workerManagers[topicPartition] = wm
+		}
+	})
+
+	for _, wm := range workerManagers {
+		wm.stopLock.Lock()
+		defer wm.stopLock.Unlock()
+	}
+	Infof(c, "Paused processing for checkpoint %s", id)
+
+	offsets := make(map[TopicAndPartition]int64)
+	for topicPartition, wm := range workerManagers {
+		offset, err := wm.checkpoint()
+		if err != nil {
+			return fmt.Errorf("Failed to commit offset for %s at checkpoint %s: %s", &topicPartition, id, err)
+		}
+		if !isOffsetInvalid(offset) {
+			offsets[topicPartition] = offset
+		}
+	}
+
+	if c.config.OnCheckpoint != nil {
+		return c.config.OnCheckpoint(id, offsets)
+	}
+	return nil
+}
+
+// Commits the offset of a given message for its topic-partition.
+// Like all offsets committed by this consumer the stored value is the offset of the last handled message, so consumption
+// resumes right after it. Commits never move backwards: if a higher offset was already committed this is a no-op.
+// Returns an error if the message's partition is not owned by this consumer or if the commit failed after all retries.
+func (c *Consumer) CommitMessage(message *Message) error {
+	topicPartition := TopicAndPartition{message.Topic, message.Partition}
+	var workerManager *WorkerManager
+	var exists bool
+	inLock(&c.workerManagersLock, func() {
+		workerManager, exists = c.workerManagers[topicPartition]
+	})
+	if !exists {
+		return fmt.Errorf("Partition %s is not owned by consumer %s", &topicPartition, c.config.Consumerid)
+	}
+
+	return workerManager.commitGivenOffset(message.Offset)
+}
+
 // Returns a state snapshot for this consumer. State snapshot contains a set of metrics splitted by topics and partitions.
 func (c *Consumer) StateSnapshot() *StateSnapshot {
 	metricsMap := c.metrics.Stats()
@@ -19,6 +19,9 @@ import (
 	"errors"
 	"fmt"
 	"time"
+
+	"github.com/elodina/siesta-producer"
+	metrics "github.com/rcrowley/go-metrics"
 )
 
 //ConsumerConfig defines configuration options for Consumer
@@ -33,6 +36,23 @@ type ConsumerConfig struct {
 	/* The socket timeout for network requests. Its value should be at least FetchWaitMaxMs. */
 	SocketTimeout time.Duration
 
+	/* Timeout to establish a TCP connection to a broker. SocketTimeout is used if not set. */
+	DialTimeout time.Duration
+
+	/* Timeout to read a response from a broker connection. SocketTimeout is used if not set. */
+	ReadTimeout time.Duration
+
+	/* Timeout to write a request to a broker connection. SocketTimeout is used if not set. */
+	WriteTimeout time.Duration
+
+	/* Period between TCP keepalive probes on broker connections, so that silently dropped connections (e.g. by a NAT) are detected.
+	0 uses the siesta default period, a negative value disables keepalive. */
+	KeepAlive time.Duration
+
+	/* Maximum number of TCP connections kept open to a single broker. Concurrent requests to a broker, e.g. fetches for several of
+	its partitions, use separate connections up to this limit instead of queueing on a single one. Zero uses the siesta default. */
+	ConnectionsPerBroker int
+
 	/* The maximum number of bytes to attempt to fetch */
 	FetchMessageMaxBytes int32
 
@@ -45,7 +65,9 @@ type ConsumerConfig struct {
 	/* Max number of retries during rebalance */
 	RebalanceMaxRetries int32
 
-	/* The minimum amount of data the server should return for a fetch request. If insufficient data is available the request will block */
+	/* The minimum amount of data the server should return for a fetch request. If insufficient data is available the request will block.
+	Values larger than FetchMessageMaxBytes make no sense as a single partition response never exceeds FetchMessageMaxBytes,
+	so such fetches would always wait for FetchWaitMaxMs. Such values are capped to FetchMessageMaxBytes. */
 	FetchMinBytes int32
 
 	/* The maximum amount of time the server will block before answering the fetch request if there isn't sufficient data to immediately satisfy FetchMinBytes */
@@ -54,34 +76,94 @@ type ConsumerConfig struct {
 	/* Backoff time between retries during rebalance */
 	RebalanceBackoff time.Duration
 
+	/* Minimum time between the end of a rebalance and the start of the next one. Rebalance triggers during the cooldown are coalesced
+	into a single rebalance once it is over. 0 means rebalances are only coalesced while another rebalance is in progress. */
+	RebalanceCooldown time.Duration
+
+	/* Maximum number of rebalances started within RebalanceThrottleInterval. Further rebalances are delayed until the oldest one
+	falls out of the interval. 0 means no limit. */
+	MaxRebalancesPerInterval int
+
+	/* Interval MaxRebalancesPerInterval applies to. */
+	RebalanceThrottleInterval time.Duration
+
 	/* Backoff time to refresh the leader of a partition after it loses the current leader */
 	RefreshLeaderBackoff time.Duration
 
 	/* Retry the offset commit up to this many times on failure. */
 	OffsetsCommitMaxRetries int
 
+	/* Callback executed after each offset commit attempt with the offsets that were committed and an error if the commit failed after OffsetsCommitMaxRetries. Optional. */
+	OnCommit CommitCallback
+
+	/* Callback executed by Consumer.Checkpoint while processing is paused, with the checkpoint id and the offsets committed for all owned partitions.
+	An error returned from it is returned by Checkpoint. Optional. */
+	OnCheckpoint CheckpointCallback
+
 	/* Try to commit offset every OffsetCommitInterval. If previous offset commit for a partition is still in progress updates the next offset to commit and continues.
 	This way it does not commit all the offset history if the coordinator is slow, but only the highest offsets. */
 	OffsetCommitInterval time.Duration
 
+	/* Whether processed offsets are committed when the consumer is closed. Disable to make the next start reprocess messages
+	processed since the last periodic commit, e.g. in tests or controlled redeploys. Defaults to true. */
+	CommitOnShutdown bool
+
 	/* What to do if an offset is out of range.
 	SmallestOffset : automatically reset the offset to the smallest offset.
 	LargestOffset : automatically reset the offset to the largest offset.
 	Defaults to LargestOffset. */
 	AutoOffsetReset string
 
-	/* Client id is specified by the kafka consumer client, used to distinguish different clients. */
+	/* Whether partitions without a committed offset start at the smallest offset regardless of AutoOffsetReset, which then only applies
+	to out of range offsets. Set this for groups whose offsets may be reset to the start of a partition with SiestaClient.ResetGroupOffsets,
+	as such partitions have no handled offset to commit. Turned off by default. */
+	ResetUncommittedToSmallest bool
+
+	/* Client id is sent with every request to Kafka brokers, used to distinguish different clients in broker metrics and quotas.
+	Defaults to a hostname-based value. */
 	Clientid string
 
 	/* Whether messages from internal topics (such as offsets) should be exposed to the consumer. */
 	ExcludeInternalTopics bool
 
+	/* Whether to fail starting a consumer with StartStatic or StartStaticPartitions if any of the given topics does not exist.
+	Missing topics are only logged otherwise. Defaults to false. */
+	FailOnMissingTopics bool
+
 	/* Select a strategy for assigning partitions to consumer streams. Possible values: RangeStrategy, RoundRobinStrategy */
 	PartitionAssignmentStrategy string
 
+	/* Custom partition assignor. If not set, a built-in assignor for PartitionAssignmentStrategy is used. */
+	PartitionAssignor PartitionAssignor
+
 	/* Amount of workers per partition to process consumed messages. */
 	NumWorkers int
 
+	/* Process messages of a single partition one at a time in offset order. Partitions are still processed concurrently.
+	NumWorkers is ignored if this is enabled. */
+	OrderedWithinPartition bool
+
+	/* Interleave messages of all consumed partitions by MessageTimestamp before passing them to Strategy. Each message is held
+	for up to MergeWindow waiting for earlier messages from other partitions, after which messages are processed one at a time
+	in timestamp order. Messages arriving later than MergeWindow are still processed out of order, so perfect global ordering
+	is not possible. Should be well below WorkerTaskTimeout. Zero disables merging. */
+	MergeWindow time.Duration
+
+	/* Returns the time a message was produced at. Messages are merged by it if MergeWindow is set. If set, the end-to-end latency
+	between producing and consuming messages is also reported per topic. Optional unless MergeWindow or MaxMessageAge is set. */
+	MessageTimestamp func(*Message) time.Time
+
+	/* Skip messages whose MessageTimestamp is older than this when they are handed to workers, e.g. to only process recent
+	messages after catching up with a backlog. Offsets of skipped messages are committed as if they were processed.
+	Requires MessageTimestamp. Zero disables skipping. */
+	MaxMessageAge time.Duration
+
+	/* Hand only the latest message per key of each batch to workers, e.g. for consumers building a cache from rapidly updated keys.
+	A batch holds up to FetchBatchSize messages of a single partition accumulated within FetchBatchTimeout, so raising them
+	widens the compaction window. Offsets of superseded messages are committed as if they were processed. Messages without
+	a key are always processed. Turned off by default. */
+	CompactByKey bool
+
 	/* Times to retry processing a failed message by a worker. */
 	MaxWorkerRetries int
 
@@ -107,11 +189,28 @@ type ConsumerConfig struct {
 	/* Maximum wait time to gracefully stop a worker manager */
 	WorkerManagersStopTimeout time.Duration
 
+	/* Maximum time a partition may go without completing any message of its current batch, e.g. due to a poison message that always
+	times out, before it is quarantined. Quarantined partitions are counted by the QuarantinedPartitions metric and are not waited for
+	when worker managers are stopped, so they do not hold up rebalances. A partition leaves quarantine once it makes progress again. 0 disables stall detection. */
+	PartitionStallTimeout time.Duration
+
 	/* A function which defines a user-specified action on a single message. This function is responsible for actual message processing.
 	Consumer panics if Strategy is not set. */
 	Strategy WorkerStrategy
 
-	/* Number of messages to accumulate before flushing them to workers */
+	/* Interceptors called in order for each consumed batch before it is passed to Strategy and after each offset commit. Optional. */
+	ConsumerInterceptors []ConsumerInterceptor
+
+	/* Observer notified after each Strategy invocation with the time it took and its outcome. Optional. */
+	ProcessingObserver ProcessingObserver
+
+	/* A function which decides whether a message should be passed to Strategy. Messages for which it returns false are skipped
+	but their offsets are still committed. Optional. */
+	MessageFilter func(*Message) bool
+
+	/* Number of messages to accumulate before flushing them to workers. This is also the maximum number of messages handed
+	to workers at once: fetch responses larger than this are split and the remaining messages stay buffered until the
+	previous batch is taken. */
 	FetchBatchSize int
 
 	/* Timeout to accumulate messages. Flushes accumulated batch to workers even if it is not yet full.
@@ -127,6 +226,9 @@ type ConsumerConfig struct {
 	/* Maximum fetch retries if no messages were fetched from a previous fetch */
 	FetchMaxRetries int
 
+	/* Whether Consumer.ConsumeRange should wait for new messages if the range end is beyond the log end. Stops at the log end otherwise. */
+	RangeAwaitEnd bool
+
 	/* Maximum retries to fetch topic metadata from one broker. */
 	FetchTopicMetadataRetries int
 
@@ -139,9 +241,17 @@ type ConsumerConfig struct {
 	/* Coordinator used to coordinate consumer's actions, e.g. trigger rebalance events, store offsets and consumer metadata etc. */
 	Coordinator ConsumerCoordinator
 
-	/* OffsetStorage is used to store and retrieve consumer offsets. */
+	/* OffsetStorage is used to store and retrieve consumer offsets. Defaults to the Coordinator if it is a ZookeeperCoordinator.
+	Any implementation may be plugged in here, e.g. InMemoryOffsetStorage for tests. */
 	OffsetStorage OffsetStorage
 
+	/* Commits processed offsets to an external storage instead of OffsetStorage, e.g. transactionally with the writes of the Strategy,
+	to tie offsets and processing results together. Must be set together with ExternalOffsetFetcher. Optional. */
+	ExternalOffsetCommitter ExternalOffsetCommitter
+
+	/* Fetches offsets committed with ExternalOffsetCommitter when partitions are assigned. Must be set together with ExternalOffsetCommitter. Optional. */
+	ExternalOffsetFetcher ExternalOffsetFetcher
+
 	/* Indicates whether the client supports blue-green deployment.
 	This config entry is needed because blue-green deployment won't work with RoundRobin partition assignment strategy.
 	Defaults to true. */
@@ -153,25 +263,60 @@ type ConsumerConfig struct {
 	/* Service coordinator barrier timeout */
 	BarrierTimeout time.Duration
 
+	/* Maximum time to keep retrying to connect to the coordinator, register the consumer and claim statically assigned partitions
+	when the consumer starts. Allows to tolerate short coordinator outages at startup. Zero means a single attempt. */
+	CoordinatorStartupTimeout time.Duration
+
+	/* Backoff between coordinator operation retries during consumer startup. */
+	CoordinatorStartupBackoff time.Duration
+
 	/* Low Level Kafka Client implementation. */
 	LowLevelClient LowLevelClient
 
 	/* Message keys decoder */
 	KeyDecoder Decoder
 
+	/* Message keys decoders for specific topics. Topics not listed here are decoded with KeyDecoder */
+	TopicKeyDecoders map[string]Decoder
+
 	/* Message values decoder */
 	ValueDecoder Decoder
 
+	/* Message values decoders for specific topics. Topics not listed here are decoded with ValueDecoder */
+	TopicValueDecoders map[string]Decoder
+
+	/* Topic to send messages that failed to decode to. Each such message is sent as a JSON encoded DeadLetter and is skipped afterwards.
+	If not set, a decode failure fails the whole fetch and the fetch is retried. */
+	DeadLetterTopic string
+
+	/* Producer used to send messages to DeadLetterTopic. Should be configured with byte array key and value serializers. */
+	DeadLetterProducer producer.Producer
+
 	/* Flag for debug mode */
 	Debug bool
 
+	/* Callback executed after each fetch with the requested offset, the returned high watermark, the number of fetched messages
+	and the next offset to fetch. Called only if Debug is set, so it adds no overhead otherwise. Optional. */
+	OnFetch FetchCallback
+
 	/* Metrics Prefix if the client wants to organize the way metric names are emitted. (optional) */
 	MetricsPrefix string
 
+	/* Registry the consumer reports its metrics to, e.g. metrics.DefaultRegistry to report metrics of all consumers in a process together.
+	If not set every consumer instance reports to its own registry available via Consumer.Metrics().Registry(). (optional) */
+	MetricsRegistry metrics.Registry
+
 	/* Config to skip corrupted messages. If set to true the consumer will increment the topic-partition offset by 1
-	   on each corrupted response until the corrupted part of data is over. Turned off by default. */
+	   on each corrupted response (e.g. a message CRC mismatch) until the corrupted part of data is over.
+	   Turned on by default, as corrupted responses were always skipped before this setting was honoured. If set to false
+	   the fetch is retried and the partition does not progress past the corrupted message, which is logged as an error on every attempt. */
 	SkipCorruptedMessages bool
 
+	/* Callback executed when fetching a topic-partition fails. Returns whether the fetch should be retried, the offset reset according to AutoOffsetReset
+	   or the message at the failed offset skipped. Optional. If not set out of range offsets are reset, corrupted responses are handled
+	   according to SkipCorruptedMessages and all other errors are retried. */
+	OnFetchError FetchErrorCallback
+
 	/* RoutinePoolSize defines the size of routine pools created within this consumer. */
 	RoutinePoolSize int
 }
@@ -181,6 +326,8 @@ func DefaultConsumerConfig() *ConsumerConfig {
 	config := &ConsumerConfig{}
 	config.Groupid = "go-consumer-group"
 	config.SocketTimeout = 30 * time.Second
+	config.KeepAlive = 1 * time.Minute
+	config.ConnectionsPerBroker = 5
 	config.FetchMessageMaxBytes = 1024 * 1024
 	config.NumConsumerFetchers = 1
 	config.QueuedMaxMessages = 3
@@ -191,9 +338,10 @@ func DefaultConsumerConfig() *ConsumerConfig {
 	config.RefreshLeaderBackoff = 200 * time.Millisecond
 	config.OffsetsCommitMaxRetries = 5
 	config.OffsetCommitInterval = 3 * time.Second
+	config.CommitOnShutdown = true
 
 	config.AutoOffsetReset = LargestOffset
-	config.Clientid = "go-client"
+	config.Clientid = defaultClientId()
 	config.ExcludeInternalTopics = true
 	config.PartitionAssignmentStrategy = RangeStrategy /* select between "RangeStrategy", and "RoundRobinStrategy" */
 
@@ -209,6 +357,7 @@ func DefaultConsumerConfig() *ConsumerConfig {
 	config.FetchBatchTimeout = 5 * time.Second
 
 	config.FetchMaxRetries = 5
+	config.SkipCorruptedMessages = true
 	config.RequeueAskNextBackoff = 5 * time.Second
 	config.AskNextChannelSize = 1000
 	config.FetchTopicMetadataRetries = 3
@@ -219,6 +368,8 @@ func DefaultConsumerConfig() *ConsumerConfig {
 	config.BlueGreenDeploymentEnabled = true
 	config.DeploymentTimeout = 0 * time.Second
 	config.BarrierTimeout = 30 * time.Second
+	config.CoordinatorStartupTimeout = 30 * time.Second
+	config.CoordinatorStartupBackoff = 1 * time.Second
 	config.LowLevelClient = NewSiestaClient(config)
 
 	config.KeyDecoder = &ByteDecoder{}
@@ -297,6 +448,10 @@ func (c *ConsumerConfig) Validate() error {
 		return errors.New("OffsetsCommitMaxRetries cannot be less than 0")
 	}
 
+	if c.MaxRebalancesPerInterval > 0 && c.RebalanceThrottleInterval <= 0 {
+		return errors.New("RebalanceThrottleInterval must be positive when MaxRebalancesPerInterval is set")
+	}
+
 	if c.AutoOffsetReset != SmallestOffset && c.AutoOffsetReset != LargestOffset {
 		return fmt.Errorf("AutoOffsetReset must be either \"%s\" or \"%s\"", SmallestOffset, LargestOffset)
 	}
@@ -309,6 +464,10 @@ func (c *ConsumerConfig) Validate() error {
 		return fmt.Errorf("PartitionAssignmentStrategy must be either \"%s\" or \"%s\"", RangeStrategy, RoundRobinStrategy)
 	}
 
+	if c.PartitionAssignor == nil {
+		c.PartitionAssignor = newPartitionAssignor(c.PartitionAssignmentStrategy)
+	}
+
 	if c.NumWorkers <= 0 {
 		return errors.New("NumWorkers should be at least 1")
 	}
@@ -333,6 +492,14 @@ func (c *ConsumerConfig) Validate() error {
 		return errors.New("Please provide a Strategy")
 	}
 
+	if c.MergeWindow > 0 && c.MessageTimestamp == nil {
+		return errors.New("Please provide a MessageTimestamp to merge messages by")
+	}
+
+	if c.MaxMessageAge > 0 && c.MessageTimestamp == nil {
+		return errors.New("Please provide a MessageTimestamp to check message age by")
+	}
+
 	if c.FetchBatchSize <= 0 {
 		return errors.New("FetchBatchSize should be at least 1")
 	}
@@ -349,6 +516,14 @@ func (c *ConsumerConfig) Validate() error {
 		return errors.New("Please provide a Coordinator")
 	}
 
+	if (c.ExternalOffsetCommitter == nil) != (c.ExternalOffsetFetcher == nil) {
+		return errors.New("ExternalOffsetCommitter and ExternalOffsetFetcher must be set together")
+	}
+
+	if c.ExternalOffsetCommitter != nil {
+		c.OffsetStorage = &externalOffsetStorage{commit: c.ExternalOffsetCommitter, fetch: c.ExternalOffsetFetcher}
+	}
+
 	if c.OffsetStorage == nil {
 		// This is for folks who already use this client
 		if zookeeper, ok := c.Coordinator.(*ZookeeperCoordinator); ok {
@@ -358,10 +533,22 @@ func (c *ConsumerConfig) Validate() error {
 		}
 	}
 
-	if c.BlueGreenDeploymentEnabled && c.PartitionAssignmentStrategy != RangeStrategy {
+	if _, isRange := c.PartitionAssignor.(*RangeAssignor); c.BlueGreenDeploymentEnabled && !isRange {
 		return errors.New("In order to use Blue-Green deployment Range partition assignment strategy should be used")
 	}
 
+	if c.DeadLetterTopic != "" && c.DeadLetterProducer == nil {
+		return errors.New("Dead letter producer is not set")
+	}
+
+	if c.CoordinatorStartupTimeout > 0 && c.CoordinatorStartupBackoff <= 0 {
+		return errors.New("CoordinatorStartupBackoff should be positive if CoordinatorStartupTimeout is set")
+	}
+
+	if c.ConnectionsPerBroker < 0 {
+		return errors.New("ConnectionsPerBroker cannot be negative")
+	}
+
 	if c.LowLevelClient == nil {
 		return errors.New("Low level client is not set")
 	}
@@ -377,39 +564,73 @@ func (c *ConsumerConfig) Validate() error {
 	return nil
 }
 
+func (c *ConsumerConfig) keyDecoder(topic string) Decoder {
+	if decoder, exists := c.TopicKeyDecoders[topic]; exists {
+		return decoder
+	}
+	return c.KeyDecoder
+}
+
+func (c *ConsumerConfig) valueDecoder(topic string) Decoder {
+	if decoder, exists := c.TopicValueDecoders[topic]; exists {
+		return decoder
+	}
+	return c.ValueDecoder
+}
+
 // ConsumerConfigFromFile is a helper function that loads a consumer's configuration from file.
 // The file accepts the following fields:
 //  group.id
 //  consumer.id
+//  client.id
+//  socket.timeout
+//  socket.dial.timeout
+//  socket.read.timeout
+//  socket.write.timeout
+//  socket.keepalive
 //  fetch.message.max.bytes
+//  connections.per.broker
 //  num.consumer.fetchers
 //  rebalance.max.retries
 //  queued.max.message.chunks
 //  fetch.min.bytes
 //  fetch.wait.max.ms
 //  rebalance.backoff
+//  rebalance.cooldown
+//  rebalance.max.per.interval
+//  rebalance.throttle.interval
 //  refresh.leader.backoff
 //  offset.commit.max.retries
 //  offset.commit.interval
+//  commit.on.shutdown
 //  offsets.storage
 //  auto.offset.reset
+//  reset.uncommitted.to.smallest
 //  exclude.internal.topics
+//  fail.on.missing.topics
 //  partition.assignment.strategy
 //  num.workers
+//  ordered.within.partition
 //  max.worker.retries
 //  worker.retry.threshold
 //  worker.threshold.time.window
 //  worker.task.timeout
 //  worker.backoff
 //  worker.managers.stop.timeout
+//  partition.stall.timeout
 //  fetch.batch.size
 //  fetch.batch.timeout
+//  compact.by.key
 //  requeue.ask.next.backoff
 //  fetch.max.retries
 //  fetch.topic.metadata.retries
 //  fetch.topic.metadata.backoff
 //  fetch.request.backoff
 //  blue.green.deployment.enabled
+//  range.await.end
+//  skip.corrupted.messages
+//  coordinator.startup.timeout
+//  coordinator.startup.backoff
 // The configuration file entries should be constructed in key=value syntax. A # symbol at the beginning
 // of a line indicates a comment. Blank lines are ignored. The file should end with a newline character.
 func ConsumerConfigFromFile(filename string) (*ConsumerConfig, error) {
@@ -421,9 +642,25 @@ func ConsumerConfigFromFile(filename string) (*ConsumerConfig, error) {
 	config := DefaultConsumerConfig()
 	setStringConfig(&config.Groupid, c["group.id"])
 	setStringConfig(&config.Consumerid, c["consumer.id"])
+	setStringConfig(&config.Clientid, c["client.id"])
 	if err := setDurationConfig(&config.SocketTimeout, c["socket.timeout"]); err != nil {
 		return nil, err
 	}
+	if err := setDurationConfig(&config.DialTimeout, c["socket.dial.timeout"]); err != nil {
+		return nil, err
+	}
+	if err := setDurationConfig(&config.ReadTimeout, c["socket.read.timeout"]); err != nil {
+		return nil, err
+	}
+	if err := setDurationConfig(&config.WriteTimeout, c["socket.write.timeout"]); err != nil {
+		return nil, err
+	}
+	if err := setDurationConfig(&config.KeepAlive, c["socket.keepalive"]); err != nil {
+		return nil, err
+	}
+	if err := setIntConfig(&config.ConnectionsPerBroker, c["connections.per.broker"]); err != nil {
+		return nil, err
+	}
 	if err := setInt32Config(&config.FetchMessageMaxBytes, c["fetch.message.max.bytes"]); err != nil {
 		return nil, err
 	}
@@ -445,6 +682,15 @@ func ConsumerConfigFromFile(filename string) (*ConsumerConfig, error) {
 	if err := setDurationConfig(&config.RebalanceBackoff, c["rebalance.backoff"]); err != nil {
 		return nil, err
 	}
+	if err := setDurationConfig(&config.RebalanceCooldown, c["rebalance.cooldown"]); err != nil {
+		return nil, err
+	}
+	if err := setIntConfig(&config.MaxRebalancesPerInterval, c["rebalance.max.per.interval"]); err != nil {
+		return nil, err
+	}
+	if err := setDurationConfig(&config.RebalanceThrottleInterval, c["rebalance.throttle.interval"]); err != nil {
+		return nil, err
+	}
 	if err := setDurationConfig(&config.RefreshLeaderBackoff, c["refresh.leader.backoff"]); err != nil {
 		return nil, err
 	}
@@ -454,12 +700,16 @@ func ConsumerConfigFromFile(filename string) (*ConsumerConfig, error) {
 	if err := setDurationConfig(&config.OffsetCommitInterval, c["offset.commit.interval"]); err != nil {
 		return nil, err
 	}
+	setBoolConfig(&config.CommitOnShutdown, c["commit.on.shutdown"])
 	setStringConfig(&config.AutoOffsetReset, c["auto.offset.reset"])
+	setBoolConfig(&config.ResetUncommittedToSmallest, c["reset.uncommitted.to.smallest"])
 	setBoolConfig(&config.ExcludeInternalTopics, c["exclude.internal.topics"])
+	setBoolConfig(&config.FailOnMissingTopics, c["fail.on.missing.topics"])
 	setStringConfig(&config.PartitionAssignmentStrategy, c["partition.assignment.strategy"])
 	if err := setIntConfig(&config.NumWorkers, c["num.workers"]); err != nil {
 		return nil, err
 	}
+	setBoolConfig(&config.OrderedWithinPartition, c["ordered.within.partition"])
 	if err := setIntConfig(&config.MaxWorkerRetries, c["max.worker.retries"]); err != nil {
 		return nil, err
 	}
@@ -478,12 +728,16 @@ func ConsumerConfigFromFile(filename string) (*ConsumerConfig, error) {
 	if err := setDurationConfig(&config.WorkerManagersStopTimeout, c["worker.managers.stop.timeout"]); err != nil {
 		return nil, err
 	}
+	if err := setDurationConfig(&config.PartitionStallTimeout, c["partition.stall.timeout"]); err != nil {
+		return nil, err
+	}
 	if err := setIntConfig(&config.FetchBatchSize, c["fetch.batch.size"]); err != nil {
 		return nil, err
 	}
 	if err := setDurationConfig(&config.FetchBatchTimeout, c["fetch.batch.timeout"]); err != nil {
 		return nil, err
 	}
+	setBoolConfig(&config.CompactByKey, c["compact.by.key"])
 	if err := setDurationConfig(&config.RequeueAskNextBackoff, c["requeue.ask.next.backoff"]); err != nil {
 		return nil, err
 	}
@@ -505,10 +759,18 @@ func ConsumerConfigFromFile(filename string) (*ConsumerConfig, error) {
 	if err := setDurationConfig(&config.BarrierTimeout, c["barrier.timeout"]); err != nil {
 		return nil, err
 	}
+	if err := setDurationConfig(&config.CoordinatorStartupTimeout, c["coordinator.startup.timeout"]); err != nil {
+		return nil, err
+	}
+	if err := setDurationConfig(&config.CoordinatorStartupBackoff, c["coordinator.startup.backoff"]); err != nil {
+		return nil, err
+	}
 	if err := setIntConfig(&config.RoutinePoolSize, c["routine.pool.size"]); err != nil {
 		return nil, err
 	}
 	setBoolConfig(&config.BlueGreenDeploymentEnabled, c["blue.green.deployment.enabled"])
+	setBoolConfig(&config.RangeAwaitEnd, c["range.await.end"])
+	setBoolConfig(&config.SkipCorruptedMessages, c["skip.corrupted.messages"])
 
 	return config, nil
 }
@@ -16,10 +16,12 @@ limitations under the License. */
 package go_kafka_client
 
 import (
+	"errors"
 	"fmt"
 	"github.com/Shopify/sarama"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -617,6 +619,750 @@ func TestConsumeOnePartitionWithData(t *testing.T) {
 	closeWithin(t, delayTimeout, consumer)
 }
 
+func TestConsumeRange(t *testing.T) {
+	topic := fmt.Sprintf("test-consume-range-%d", time.Now().Unix())
+	CreateMultiplePartitionsTopic(localZk, topic, 1)
+	EnsureHasLeader(localZk, topic)
+	produceN(t, 100, topic, localBroker)
+
+	consumed := make(map[int64]bool)
+	var consumedLock sync.Mutex
+	config := testConsumerConfig()
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		inLock(&consumedLock, func() {
+			consumed[msg.Offset] = true
+		})
+		return NewSuccessfulResult(id)
+	}
+	consumer := NewConsumer(config)
+
+	select {
+	case err := <-consumer.ConsumeRange(TopicAndPartition{topic, 0}, 10, 20):
+		assert(t, err, nil)
+	case <-time.After(consumeTimeout):
+		t.Fatalf("Failed to consume range within %s", consumeTimeout)
+	}
+
+	assert(t, len(consumed), 10)
+	for offset := int64(10); offset < 20; offset++ {
+		if !consumed[offset] {
+			t.Errorf("Offset %d should be consumed", offset)
+		}
+	}
+
+	//range end beyond the log end should stop at the log end
+	select {
+	case err := <-consumer.ConsumeRange(TopicAndPartition{topic, 0}, 90, 200):
+		assert(t, err, nil)
+	case <-time.After(consumeTimeout):
+		t.Fatalf("Failed to consume range within %s", consumeTimeout)
+	}
+	assert(t, len(consumed), 20)
+
+	offset, err := config.OffsetStorage.GetOffset(config.Groupid, topic, 0)
+	assert(t, err, nil)
+	assert(t, offset, InvalidOffset)
+
+	config.LowLevelClient.Close()
+	config.Coordinator.Disconnect()
+}
+
+func TestAssignAndSeek(t *testing.T) {
+	topic := fmt.Sprintf("test-assign-and-seek-%d", time.Now().Unix())
+	CreateMultiplePartitionsTopic(localZk, topic, 2)
+	EnsureHasLeader(localZk, topic)
+	produceN(t, 100, topic, localBroker)
+
+	first := make(map[int32]int64)
+	var firstLock sync.Mutex
+	seeked := make(chan bool, 2)
+	config := testConsumerConfig()
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		inLock(&firstLock, func() {
+			if offset, exists := first[msg.Partition]; !exists || msg.Offset < offset {
+				if !exists {
+					seeked <- true
+				}
+				first[msg.Partition] = msg.Offset
+			}
+		})
+		return NewSuccessfulResult(id)
+	}
+	consumer := NewConsumer(config)
+
+	assertNot(t, consumer.AssignAndSeek(map[TopicAndPartition]int64{TopicAndPartition{topic, 0}: 1000}), nil)
+
+	go consumer.AssignAndSeek(map[TopicAndPartition]int64{TopicAndPartition{topic, 0}: 0, TopicAndPartition{topic, 1}: 5})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-seeked:
+		case <-time.After(consumeTimeout):
+			t.Fatalf("Failed to consume both partitions within %s", consumeTimeout)
+		}
+	}
+	time.Sleep(1 * time.Second)
+	closeWithin(t, 10*time.Second, consumer)
+
+	assert(t, first[0], int64(0))
+	assert(t, first[1], int64(5))
+}
+
+func TestValidateSeekOffsets(t *testing.T) {
+	client := &offsetRangeClient{SiestaClient: NewSiestaClient(DefaultConsumerConfig()), smallest: 10, largest: 20}
+	topicPartition := TopicAndPartition{"topic", 0}
+
+	assert(t, validateSeekOffsets(client, map[TopicAndPartition]int64{topicPartition: 10}), nil)
+	assert(t, validateSeekOffsets(client, map[TopicAndPartition]int64{topicPartition: 20}), nil)
+	assertNot(t, validateSeekOffsets(client, map[TopicAndPartition]int64{topicPartition: 9}), nil)
+	assertNot(t, validateSeekOffsets(client, map[TopicAndPartition]int64{topicPartition: 21}), nil)
+}
+
+func TestFetcherSeek(t *testing.T) {
+	config := DefaultConsumerConfig()
+	fetcher := &consumerFetcherRoutine{
+		manager:      &consumerFetcherManager{config: config},
+		partitionMap: make(map[TopicAndPartition]*partitionTopicInfo),
+		askNext:      make(chan TopicAndPartition, 10),
+	}
+	seeked := TopicAndPartition{"topic", 0}
+	committed := TopicAndPartition{"topic", 1}
+	fetcher.addPartitions(map[TopicAndPartition]*partitionTopicInfo{
+		seeked:    &partitionTopicInfo{Topic: "topic", Partition: 0, FetchedOffset: 0, Seek: true, Buffer: newMessageBuffer(seeked, make(chan []*Message), config)},
+		committed: &partitionTopicInfo{Topic: "topic", Partition: 1, FetchedOffset: 4, Buffer: newMessageBuffer(committed, make(chan []*Message), config)},
+	})
+
+	//a seeked partition starts at the given offset, otherwise right after the last processed offset
+	assert(t, fetcher.partitionMap[seeked].FetchedOffset, int64(0))
+	assert(t, fetcher.partitionMap[committed].FetchedOffset, int64(5))
+	fetcher.partitionMap[seeked].Buffer.stop()
+	fetcher.partitionMap[committed].Buffer.stop()
+}
+
+func TestPauseAll(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.FetchBatchSize = 2
+	client := &sequentialClient{}
+	config.LowLevelClient = client
+	manager := newConsumerFetcherManager(config, make(chan TopicAndPartition), newConsumerMetrics("test-pause-all", "", nil))
+	fetcher := newConsumerFetcher(manager, "test-pause-all-fetcher")
+	go fetcher.start()
+	consumer := &Consumer{config: config, fetcher: manager}
+
+	topicPartition := TopicAndPartition{"topic", 0}
+	output := make(chan []*Message)
+	buffer := newMessageBuffer(topicPartition, output, config)
+	fetcher.addPartitions(map[TopicAndPartition]*partitionTopicInfo{
+		topicPartition: &partitionTopicInfo{Topic: "topic", Partition: 0, FetchedOffset: 0, Seek: true, Buffer: buffer},
+	})
+	assert(t, (<-output)[0].Offset, int64(0))
+	time.Sleep(100 * time.Millisecond)
+
+	//the batch fetched before pausing is still delivered, nothing is fetched afterwards
+	consumer.PauseAll()
+	assert(t, (<-output)[0].Offset, int64(2))
+	select {
+	case batch := <-output:
+		t.Errorf("Received a batch at offset %d while paused", batch[0].Offset)
+	case <-time.After(500 * time.Millisecond):
+	}
+	assert(t, atomic.LoadInt32(&client.fetches), int32(2))
+
+	consumer.ResumeAll()
+	assert(t, (<-output)[0].Offset, int64(4))
+
+	buffer.stop()
+	<-fetcher.close()
+}
+
+func TestRequeueAskNextToClosedFetcher(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.AskNextChannelSize = 0
+	config.LowLevelClient = &sequentialClient{}
+	manager := newConsumerFetcherManager(config, make(chan TopicAndPartition), newConsumerMetrics("test-requeue-closed", "", nil))
+	fetcher := newConsumerFetcher(manager, "test-requeue-closed-fetcher")
+	go fetcher.start()
+	manager.pause()
+	manager.park(fetcher, TopicAndPartition{"topic", 0})
+
+	//a rebalance closes the fetcher while paused, nothing reads its asknexts anymore
+	<-fetcher.close()
+	requeued := make(chan bool)
+	go func() {
+		fetcher.requeueAskNext([]TopicAndPartition{TopicAndPartition{"topic", 0}})
+		requeued <- true
+	}()
+	select {
+	case <-requeued:
+	case <-time.After(3 * time.Second):
+		t.Error("Requeueing asknexts of a closed fetcher did not return")
+	}
+	manager.resume()
+}
+
+func TestOnFetch(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.FetchBatchSize = 2
+	config.LowLevelClient = &sequentialClient{highwaterMark: 100}
+	config.Debug = true
+	fetches := make(chan FetchInfo, 10)
+	config.OnFetch = func(info FetchInfo) {
+		fetches <- info
+	}
+	manager := newConsumerFetcherManager(config, make(chan TopicAndPartition), newConsumerMetrics("test-on-fetch", "", nil))
+	fetcher := newConsumerFetcher(manager, "test-on-fetch-fetcher")
+	go fetcher.start()
+
+	topicPartition := TopicAndPartition{"topic", 0}
+	output := make(chan []*Message)
+	buffer := newMessageBuffer(topicPartition, output, config)
+	fetcher.addPartitions(map[TopicAndPartition]*partitionTopicInfo{
+		topicPartition: &partitionTopicInfo{Topic: "topic", Partition: 0, FetchedOffset: 10, Seek: true, Buffer: buffer},
+	})
+	<-output
+	select {
+	case info := <-fetches:
+		assert(t, info, FetchInfo{TopicAndPartition: topicPartition, RequestedOffset: 10, HighwaterMarkOffset: 100, NumMessages: 2, NextOffset: 12})
+	case <-time.After(time.Second):
+		t.Fatal("Fetch was not reported in debug mode")
+	}
+
+	buffer.stop()
+	<-fetcher.close()
+}
+
+func TestPosition(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.FetchBatchSize = 2
+	config.NumConsumerFetchers = 1
+	config.LowLevelClient = &sequentialClient{}
+	mockZk := newMockZookeeperCoordinator()
+	config.OffsetStorage = mockZk
+	manager := newConsumerFetcherManager(config, make(chan TopicAndPartition), newConsumerMetrics("test-position", "", nil))
+	consumer := &Consumer{config: config, fetcher: manager}
+
+	topicPartition := TopicAndPartition{"topic", 0}
+	_, err := consumer.Position(topicPartition)
+	assertNot(t, err, nil)
+
+	output := make(chan []*Message)
+	manager.startConnections([]*partitionTopicInfo{
+		&partitionTopicInfo{Topic: "topic", Partition: 0, FetchedOffset: 4, Buffer: newMessageBuffer(topicPartition, output, config)},
+	}, 1)
+
+	//the first batch starts right after the last processed offset, the next one is fetched as soon as it is consumed
+	assert(t, (<-output)[0].Offset, int64(5))
+	time.Sleep(100 * time.Millisecond)
+	position, err := consumer.Position(topicPartition)
+	assert(t, err, nil)
+	assert(t, position, int64(9))
+
+	assert(t, (<-output)[0].Offset, int64(7))
+	time.Sleep(100 * time.Millisecond)
+	position, _ = consumer.Position(topicPartition)
+	assert(t, position, int64(11))
+	assert(t, len(mockZk.commits()), 0)
+
+	_, err = consumer.Position(TopicAndPartition{"topic", 1})
+	assertNot(t, err, nil)
+	<-manager.close()
+}
+
+type sequentialClient struct {
+	fetches       int32
+	highwaterMark int64
+}
+
+func (this *sequentialClient) Initialize() error {
+	return nil
+}
+
+func (this *sequentialClient) Fetch(topic string, partition int32, offset int64) ([]*Message, error) {
+	atomic.AddInt32(&this.fetches, 1)
+	return []*Message{
+		&Message{Topic: topic, Partition: partition, Offset: offset, HighwaterMarkOffset: this.highwaterMark},
+		&Message{Topic: topic, Partition: partition, Offset: offset + 1, HighwaterMarkOffset: this.highwaterMark},
+	}, nil
+}
+
+func (this *sequentialClient) GetErrorType(err error) ErrorType {
+	return ErrorTypeOther
+}
+
+func (this *sequentialClient) GetAvailableOffset(topic string, partition int32, offsetTime string) (int64, error) {
+	return 0, nil
+}
+
+func (this *sequentialClient) Close() {}
+
+func TestConsumeRangeWithGaps(t *testing.T) {
+	config := DefaultConsumerConfig()
+	var processed int32
+	config.Strategy = func(_ *Worker, _ *Message, id TaskId) WorkerResult {
+		atomic.AddInt32(&processed, 1)
+		return NewSuccessfulResult(id)
+	}
+	client := &gappedClient{offsetRangeClient: &offsetRangeClient{largest: 100}, offsets: []int64{0, 1, 2, 5, 30, 31}}
+	config.LowLevelClient = client
+	c := &Consumer{config: config, metrics: newConsumerMetrics("test-consume-range-gaps", "", nil)}
+	defer c.metrics.close()
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- c.consumeRange(TopicAndPartition{"gapped", 0}, 0, 10)
+	}()
+	select {
+	case err := <-finished:
+		assert(t, err, nil)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Range ending in a gap was not finished")
+	}
+	assert(t, atomic.LoadInt32(&processed), int32(4))
+	assert(t, atomic.LoadInt32(&client.fetches), int32(2))
+}
+
+// gappedClient returns messages with given offsets starting at the fetched offset, as a compacted topic would.
+type gappedClient struct {
+	*offsetRangeClient
+	offsets []int64
+	fetches int32
+}
+
+func (this *gappedClient) Fetch(topic string, partition int32, offset int64) ([]*Message, error) {
+	atomic.AddInt32(&this.fetches, 1)
+	messages := make([]*Message, 0)
+	for _, messageOffset := range this.offsets {
+		if messageOffset >= offset {
+			messages = append(messages, &Message{Topic: topic, Partition: partition, Offset: messageOffset})
+		}
+	}
+	return messages, nil
+}
+
+type offsetRangeClient struct {
+	*SiestaClient
+	smallest int64
+	largest  int64
+}
+
+func (this *offsetRangeClient) GetAvailableOffset(topic string, partition int32, offsetTime string) (int64, error) {
+	if offsetTime == SmallestOffset {
+		return this.smallest, nil
+	}
+	return this.largest, nil
+}
+
+func TestCommitOffsetsBeforeRevoke(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.Strategy = goodStrategy
+	config.OffsetCommitInterval = 1 * time.Minute
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
+
+	metrics := newConsumerMetrics("test-revoke-commit", "", nil)
+	manager := NewWorkerManager("test-WM-revoke", config, topicPartition, metrics, make(chan bool))
+	go manager.Start()
+	manager.inputChannel <- []*Message{&Message{Offset: 0}, &Message{Offset: 1}, &Message{Offset: 2}}
+	time.Sleep(1 * time.Second)
+
+	consumer := &Consumer{
+		config:         config,
+		workerManagers: map[TopicAndPartition]*WorkerManager{topicPartition: manager},
+	}
+	//processed offsets are not committed until the commit interval elapses
+	assert(t, len(mockZk.commits()), 0)
+	assert(t, consumer.CommitOffsets(), true)
+	assert(t, mockZk.commits()[topicPartition], int64(2))
+
+	config.OffsetStorage = &failingOffsetStorage{}
+	config.OffsetsCommitMaxRetries = 0
+	manager.inputChannel <- []*Message{&Message{Offset: 3}}
+	time.Sleep(1 * time.Second)
+	assert(t, consumer.CommitOffsets(), false)
+
+	config.OffsetStorage = mockZk
+	<-manager.Stop()
+	assert(t, mockZk.commits()[topicPartition], int64(3))
+}
+
+func TestCommitOnShutdown(t *testing.T) {
+	for _, commitOnShutdown := range []bool{true, false} {
+		config := DefaultConsumerConfig()
+		config.Strategy = goodStrategy
+		config.OffsetCommitInterval = 1 * time.Minute
+		config.CommitOnShutdown = commitOnShutdown
+		mockZk := newMockZookeeperCoordinator()
+		config.Coordinator = mockZk
+		config.OffsetStorage = mockZk
+		topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
+
+		wmid := fmt.Sprintf("test-WM-commit-on-shutdown-%t", commitOnShutdown)
+		manager := NewWorkerManager(wmid, config, topicPartition, newConsumerMetrics(wmid, "", nil), make(chan bool))
+		go manager.Start()
+		manager.inputChannel <- []*Message{&Message{Offset: 0}, &Message{Offset: 1}}
+		time.Sleep(500 * time.Millisecond)
+
+		consumer := &Consumer{
+			config:         config,
+			workerManagers: map[TopicAndPartition]*WorkerManager{topicPartition: manager},
+		}
+		assert(t, consumer.stopWorkerManagers(), true)
+		committed, exists := mockZk.commits()[topicPartition]
+		assert(t, exists, commitOnShutdown)
+		if commitOnShutdown {
+			assert(t, committed, int64(1))
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.OffsetCommitInterval = 1 * time.Minute
+	var processed int32
+	config.Strategy = func(_ *Worker, _ *Message, id TaskId) WorkerResult {
+		atomic.AddInt32(&processed, 1)
+		return NewSuccessfulResult(id)
+	}
+	mockZk := newMockZookeeperCoordinator()
+	config.OffsetStorage = mockZk
+
+	metrics := newConsumerMetrics("test-checkpoint", "", nil)
+	managers := make(map[TopicAndPartition]*WorkerManager)
+	for partition := int32(0); partition < 2; partition++ {
+		topicPartition := TopicAndPartition{"fakeTopic", partition}
+		managers[topicPartition] = NewWorkerManager(fmt.Sprintf("test-WM-checkpoint-%d", partition), config, topicPartition, metrics, make(chan bool))
+		go managers[topicPartition].Start()
+	}
+	first := TopicAndPartition{"fakeTopic", 0}
+	second := TopicAndPartition{"fakeTopic", 1}
+	managers[first].inputChannel <- []*Message{&Message{Topic: "fakeTopic", Partition: 0, Offset: 0}, &Message{Topic: "fakeTopic", Partition: 0, Offset: 1}}
+	managers[second].inputChannel <- []*Message{&Message{Topic: "fakeTopic", Partition: 1, Offset: 5}}
+	time.Sleep(500 * time.Millisecond)
+
+	checkpointed := make(map[TopicAndPartition]int64)
+	config.OnCheckpoint = func(id string, offsets map[TopicAndPartition]int64) error {
+		assert(t, id, "snapshot-1")
+		checkpointed = offsets
+		//processing is paused until the callback returns
+		go func() {
+			managers[first].inputChannel <- []*Message{&Message{Topic: "fakeTopic", Partition: 0, Offset: 2}}
+		}()
+		time.Sleep(500 * time.Millisecond)
+		assert(t, atomic.LoadInt32(&processed), int32(3))
+		return nil
+	}
+
+	consumer := &Consumer{
+		config:         config,
+		workerManagers: managers,
+	}
+	assert(t, consumer.Checkpoint("snapshot-1"), nil)
+	assert(t, checkpointed, map[TopicAndPartition]int64{first: 1, second: 5})
+	assert(t, mockZk.commits()[first], int64(1))
+	assert(t, mockZk.commits()[second], int64(5))
+
+	//processing resumes after the checkpoint
+	time.Sleep(500 * time.Millisecond)
+	assert(t, atomic.LoadInt32(&processed), int32(4))
+
+	config.OnCheckpoint = func(id string, offsets map[TopicAndPartition]int64) error {
+		return errors.New("external store unavailable")
+	}
+	assertNot(t, consumer.Checkpoint("snapshot-2"), nil)
+	assert(t, mockZk.commits()[first], int64(2))
+
+	for _, manager := range managers {
+		<-manager.Stop()
+	}
+}
+
+func TestAssignmentSnapshot(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.Coordinator = newMockZookeeperCoordinator()
+	consumer := &Consumer{
+		config:                    config,
+		topicRegistry:             make(map[string]map[int32]*partitionTopicInfo),
+		topicPartitionsAndBuffers: make(map[TopicAndPartition]*messageBuffer),
+	}
+	assert(t, consumer.Assignment(), []TopicAndPartition{})
+	assert(t, consumer.Subscription(), []string{})
+
+	for _, topicPartition := range []TopicAndPartition{{"topic2", 0}, {"topic1", 1}, {"topic1", 0}} {
+		consumer.addPartitionTopicInfo(consumer.topicRegistry, &topicPartition, 0, ConsumerThreadId{"consumer", 0})
+	}
+	context := &AssignmentContext{MyTopicThreadIds: map[string][]ConsumerThreadId{"topic2": nil, "topic1": nil}}
+	consumer.updateAssignment(context)
+	assignment := consumer.Assignment()
+	assert(t, assignment, []TopicAndPartition{{"topic1", 0}, {"topic1", 1}, {"topic2", 0}})
+	assert(t, consumer.Subscription(), []string{"topic1", "topic2"})
+
+	//partitions are released at the beginning of a rebalance
+	consumer.releasePartitionOwnership(consumer.topicRegistry)
+	assert(t, consumer.Assignment(), []TopicAndPartition{})
+	assert(t, consumer.Subscription(), []string{"topic1", "topic2"})
+	//snapshots are not affected by later changes
+	assert(t, assignment, []TopicAndPartition{{"topic1", 0}, {"topic1", 1}, {"topic2", 0}})
+
+	consumer.addPartitionTopicInfo(consumer.topicRegistry, &TopicAndPartition{"topic2", 1}, 0, ConsumerThreadId{"consumer", 0})
+	consumer.updateAssignment(&AssignmentContext{MyTopicThreadIds: map[string][]ConsumerThreadId{"topic2": nil}})
+	assert(t, consumer.Assignment(), []TopicAndPartition{{"topic2", 1}})
+	assert(t, consumer.Subscription(), []string{"topic2"})
+}
+
+func TestRetryCoordinatorOnStartup(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.CoordinatorStartupTimeout = 1 * time.Second
+	config.CoordinatorStartupBackoff = 50 * time.Millisecond
+	coordinator := &unavailableCoordinator{mockZookeeperCoordinator: newMockZookeeperCoordinator(), failures: 3}
+	config.Coordinator = coordinator
+	consumer := &Consumer{
+		config:     config,
+		topicCount: &StaticTopicsToNumStreams{ConsumerId: "consumer", TopicsToNumStreamsMap: map[string]int{"topic": 1}},
+	}
+
+	assert(t, consumer.retryOnStartup("connect to coordinator", coordinator.Connect), nil)
+	assert(t, coordinator.attempts, 4)
+
+	coordinator.failures, coordinator.attempts = 2, 0
+	consumer.registerConsumer()
+	assert(t, coordinator.attempts, 3)
+
+	//gives up once the startup timeout is over
+	coordinator.failures, coordinator.attempts = 100, 0
+	start := time.Now()
+	assertNot(t, consumer.retryOnStartup("connect to coordinator", coordinator.Connect), nil)
+	if time.Since(start) > 2*config.CoordinatorStartupTimeout {
+		t.Errorf("Startup retries took %s, expected at most %s", time.Since(start), config.CoordinatorStartupTimeout)
+	}
+	if coordinator.attempts < 2 || coordinator.attempts > 21 {
+		t.Errorf("Unexpected number of connection attempts: %d", coordinator.attempts)
+	}
+
+	config.CoordinatorStartupTimeout = 0
+	coordinator.failures, coordinator.attempts = 1, 0
+	assertNot(t, consumer.retryOnStartup("connect to coordinator", coordinator.Connect), nil)
+	assert(t, coordinator.attempts, 1)
+}
+
+func TestValidateTopics(t *testing.T) {
+	config := DefaultConsumerConfig()
+	mockZk := newMockZookeeperCoordinator()
+	mockZk.partitions["existing"] = []int32{0, 1, 2}
+	config.Coordinator = mockZk
+	consumer := &Consumer{config: config}
+
+	assert(t, consumer.validateTopics([]string{"existing"}), nil)
+	//missing topics are only logged by default
+	assert(t, consumer.validateTopics([]string{"existing", "missing"}), nil)
+
+	config.FailOnMissingTopics = true
+	assert(t, consumer.validateTopics([]string{"existing"}), nil)
+	err := consumer.validateTopics([]string{"missing", "existing", "typo"})
+	assertNot(t, err, nil)
+	assert(t, err.Error(), "Subscribed topics [missing typo] do not exist")
+
+	//coordinator errors are only logged unless missing topics should fail
+	config.CoordinatorStartupTimeout = 0
+	unavailable := &unavailableCoordinator{mockZookeeperCoordinator: mockZk, failures: 100}
+	config.Coordinator = unavailable
+	assertNot(t, consumer.validateTopics([]string{"existing"}), nil)
+	config.FailOnMissingTopics = false
+	assert(t, consumer.validateTopics([]string{"existing"}), nil)
+
+	config.FailOnMissingTopics = true
+	config.Coordinator = mockZk
+	defer func() {
+		assertNot(t, recover(), nil)
+	}()
+	consumer.StartStatic(map[string]int{"missing": 1})
+}
+
+type unavailableCoordinator struct {
+	*mockZookeeperCoordinator
+	failures int
+	attempts int
+}
+
+func (this *unavailableCoordinator) available() error {
+	this.attempts++
+	if this.attempts <= this.failures {
+		return errors.New("zk: could not connect to a server")
+	}
+	return nil
+}
+
+func (this *unavailableCoordinator) Connect() error {
+	return this.available()
+}
+
+func (this *unavailableCoordinator) RegisterConsumer(consumerid string, group string, topicCount TopicsToNumStreams) error {
+	return this.available()
+}
+
+func (this *unavailableCoordinator) GetAllTopics() ([]string, error) {
+	if err := this.available(); err != nil {
+		return nil, err
+	}
+	return this.mockZookeeperCoordinator.GetAllTopics()
+}
+
+func TestClaimConflicts(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.RoutinePoolSize = 2
+	first := TopicAndPartition{"fakeTopic", 0}
+	second := TopicAndPartition{"fakeTopic", 1}
+	coordinator := &conflictingCoordinator{
+		mockZookeeperCoordinator: newMockZookeeperCoordinator(),
+		owners:                   map[TopicAndPartition]string{second: "other-consumer"},
+	}
+	config.Coordinator = coordinator
+	consumer := &Consumer{
+		config:  config,
+		metrics: newConsumerMetrics("test-claim-conflicts", "", nil),
+	}
+	decision := map[TopicAndPartition]ConsumerThreadId{
+		first:  ConsumerThreadId{"consumer", 0},
+		second: ConsumerThreadId{"consumer", 1},
+	}
+
+	//the partition still owned by another consumer fails the whole decision and releases the claimed ones
+	assert(t, consumer.reflectPartitionOwnershipDecision(decision), false)
+	assert(t, consumer.metrics.claimConflicts().Count(), int64(1))
+	assert(t, atomic.LoadInt32(&consumer.claimConflicts), int32(1))
+	assert(t, coordinator.owners, map[TopicAndPartition]string{second: "other-consumer"})
+
+	//succeeds once the other consumer releases its partition
+	delete(coordinator.owners, second)
+	assert(t, consumer.reflectPartitionOwnershipDecision(decision), true)
+	assert(t, consumer.metrics.claimConflicts().Count(), int64(1))
+	assert(t, len(coordinator.owners), 2)
+}
+
+type conflictingCoordinator struct {
+	*mockZookeeperCoordinator
+	owners map[TopicAndPartition]string
+	lock   sync.Mutex
+}
+
+func (this *conflictingCoordinator) ClaimPartitionOwnership(group string, topic string, partition int32, consumerThreadId ConsumerThreadId) (bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	topicPartition := TopicAndPartition{topic, partition}
+	if owner, exists := this.owners[topicPartition]; exists && owner != consumerThreadId.String() {
+		return false, nil
+	}
+	this.owners[topicPartition] = consumerThreadId.String()
+	return true, nil
+}
+
+func (this *conflictingCoordinator) ReleasePartitionOwnership(group string, topic string, partition int32) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.owners, TopicAndPartition{topic, partition})
+	return nil
+}
+
+func TestCommitMessage(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.Consumerid = "consumer"
+	storage := NewInMemoryOffsetStorage()
+	config.OffsetStorage = storage
+	topicPartition := TopicAndPartition{"topic", 1}
+	metrics := newConsumerMetrics("test-commit-message", "", nil)
+	consumer := &Consumer{
+		config:         config,
+		metrics:        metrics,
+		workerManagers: make(map[TopicAndPartition]*WorkerManager),
+	}
+	consumer.workerManagers[topicPartition] = NewWorkerManager("test-WM-commit-message", config, topicPartition, metrics, make(chan bool))
+
+	assert(t, consumer.CommitMessage(&Message{Topic: "topic", Partition: 1, Offset: 5}), nil)
+	offset, _ := storage.GetOffset(config.Groupid, "topic", 1)
+	assert(t, offset, int64(5))
+
+	//commits never move backwards
+	assert(t, consumer.CommitMessage(&Message{Topic: "topic", Partition: 1, Offset: 3}), nil)
+	offset, _ = storage.GetOffset(config.Groupid, "topic", 1)
+	assert(t, offset, int64(5))
+
+	assertNot(t, consumer.CommitMessage(&Message{Topic: "topic", Partition: 2, Offset: 7}), nil)
+	offset, _ = storage.GetOffset(config.Groupid, "topic", 2)
+	assert(t, offset, InvalidOffset)
+}
+
+func TestRebalanceMetrics(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.Consumerid = "consumer"
+	config.PartitionAssignor = newPartitionAssignor(config.PartitionAssignmentStrategy)
+	coordinator := &rebalancingCoordinator{mockZookeeperCoordinator: newMockZookeeperCoordinator(), consumers: []string{"consumer"}}
+	coordinator.partitions["topic"] = []int32{}
+	config.Coordinator = coordinator
+	metrics := newConsumerMetrics("test-rebalance-metrics", "", nil)
+	consumer := &Consumer{
+		config:                    config,
+		metrics:                   metrics,
+		topicRegistry:             make(map[string]map[int32]*partitionTopicInfo),
+		topicPartitionsAndBuffers: make(map[TopicAndPartition]*messageBuffer),
+		workerManagers:            make(map[TopicAndPartition]*WorkerManager),
+		connectChannels:           make(chan bool, 10),
+	}
+	consumer.fetcher = newConsumerFetcherManager(config, consumer.disconnectChannelsForPartition, metrics)
+	assert(t, metrics.rebalances().Count(), int64(0))
+	assert(t, metrics.timeSinceLastRebalance().Value(), int64(0))
+
+	consumer.rebalance()
+	assert(t, metrics.rebalances().Count(), int64(1))
+	assert(t, metrics.rebalanceDuration().Count(), int64(1))
+
+	//nothing changed so this rebalance is skipped and not recorded
+	consumer.rebalance()
+	assert(t, metrics.rebalances().Count(), int64(1))
+
+	coordinator.consumers = append(coordinator.consumers, "another-consumer")
+	consumer.rebalance()
+	assert(t, metrics.rebalances().Count(), int64(2))
+	assert(t, metrics.rebalanceDuration().Count(), int64(2))
+
+	time.Sleep(50 * time.Millisecond)
+	if elapsed := metrics.timeSinceLastRebalance().Value(); elapsed < 50 || elapsed > 1000 {
+		t.Errorf("Unexpected time since last rebalance: %d ms", elapsed)
+	}
+}
+
+type rebalancingCoordinator struct {
+	*mockZookeeperCoordinator
+	consumers []string
+}
+
+func (this *rebalancingCoordinator) GetAllBrokers() ([]*BrokerInfo, error) {
+	return []*BrokerInfo{}, nil
+}
+
+func (this *rebalancingCoordinator) GetConsumerInfo(consumerid string, group string) (*ConsumerInfo, error) {
+	return &ConsumerInfo{Subscription: map[string]int{"topic": 1}, Pattern: staticPattern}, nil
+}
+
+func (this *rebalancingCoordinator) GetConsumersPerTopic(group string, excludeInternalTopics bool) (map[string][]ConsumerThreadId, error) {
+	threadIds := make([]ConsumerThreadId, 0)
+	for _, consumer := range this.consumers {
+		threadIds = append(threadIds, ConsumerThreadId{consumer, 0})
+	}
+	return map[string][]ConsumerThreadId{"topic": threadIds}, nil
+}
+
+func (this *rebalancingCoordinator) GetConsumersInGroup(group string) ([]string, error) {
+	return this.consumers, nil
+}
+
+func (this *rebalancingCoordinator) AwaitOnStateBarrier(consumerId string, group string, stateHash string, barrierSize int, api string, timeout time.Duration) bool {
+	return true
+}
+
+func (this *rebalancingCoordinator) RemoveStateBarrier(group string, stateHash string, api string) error {
+	return nil
+}
+
 func testConsumerConfig() *ConsumerConfig {
 	config := DefaultConsumerConfig()
 	config.AutoOffsetReset = SmallestOffset
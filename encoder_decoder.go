@@ -15,6 +15,17 @@ limitations under the License. */
 
 package go_kafka_client
 
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/elodina/go-avro"
+	kafkaavro "github.com/elodina/go-kafka-avro"
+)
+
 type Encoder interface {
 	Encode(interface{}) ([]byte, error)
 }
@@ -52,3 +63,212 @@ type ByteDecoder struct{}
 func (this *ByteDecoder) Decode(bytes []byte) (interface{}, error) {
 	return bytes, nil
 }
+
+// AvroEncoder encodes messages as Avro binary. By default messages are written in the Confluent schema registry wire format:
+// a zero magic byte, a 4-byte big-endian schema id and the Avro binary payload, registering schemas under the "<name>-value" subject.
+// With ConfluentFraming turned off only the raw Avro binary is written, for consumers that don't use a schema registry.
+type AvroEncoder struct {
+	// Whether to prefix messages with the magic byte and schema id. Defaults to true.
+	ConfluentFraming bool
+
+	// Computes the subject schemas are registered under. Defaults to the "<record name>-value" subject for backwards compatibility.
+	SubjectNameStrategy SubjectNameStrategy
+
+	// Whether this encoder encodes message keys rather than values. Passed to SubjectNameStrategy.
+	Key bool
+
+	schemaRegistry kafkaavro.SchemaRegistryClient
+}
+
+// SubjectNameStrategy computes the schema registry subject for a schema of a given full record name written to a given topic
+// as a message key or value. May return an error if the subject can't be computed, e.g. the topic is unknown.
+type SubjectNameStrategy func(topic string, recordName string, isKey bool) (string, error)
+
+// TopicNameStrategy registers all schemas of a topic under the "<topic>-key" or "<topic>-value" subject.
+func TopicNameStrategy(topic string, recordName string, isKey bool) (string, error) {
+	if topic == "" {
+		return "", errors.New("TopicNameStrategy requires a topic")
+	}
+	if isKey {
+		return topic + "-key", nil
+	}
+	return topic + "-value", nil
+}
+
+// RecordNameStrategy registers schemas under their full record name regardless of the topic.
+func RecordNameStrategy(topic string, recordName string, isKey bool) (string, error) {
+	return recordName, nil
+}
+
+// TopicRecordNameStrategy registers schemas under the "<topic>-<record name>" subject.
+func TopicRecordNameStrategy(topic string, recordName string, isKey bool) (string, error) {
+	if topic == "" {
+		return "", errors.New("TopicRecordNameStrategy requires a topic")
+	}
+	return topic + "-" + recordName, nil
+}
+
+// Creates a new AvroEncoder that registers schemas in a schema registry at a given url.
+func NewAvroEncoder(schemaRegistryUrl string) *AvroEncoder {
+	return NewAvroEncoderWithRegistry(kafkaavro.NewCachedSchemaRegistryClient(schemaRegistryUrl))
+}
+
+// Creates a new AvroEncoder that registers schemas with a given schema registry client.
+func NewAvroEncoderWithRegistry(schemaRegistry kafkaavro.SchemaRegistryClient) *AvroEncoder {
+	return &AvroEncoder{
+		ConfluentFraming: true,
+		schemaRegistry:   schemaRegistry,
+	}
+}
+
+// Encodes a given nil, bool, int32, int64, float32, float64, string, []byte or avro.AvroRecord value.
+// The topic is unknown, so a SubjectNameStrategy that requires it fails; use EncodeForTopic instead.
+func (this *AvroEncoder) Encode(obj interface{}) ([]byte, error) {
+	return this.EncodeForTopic("", obj)
+}
+
+// Encodes a given value written to a given topic. The topic is only used to compute the schema subject.
+func (this *AvroEncoder) EncodeForTopic(topic string, obj interface{}) ([]byte, error) {
+	if obj == nil {
+		return nil, nil
+	}
+
+	schema, err := avroSchema(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := &bytes.Buffer{}
+	if this.ConfluentFraming {
+		subject := schema.GetName() + "-value"
+		if this.SubjectNameStrategy != nil {
+			if subject, err = this.SubjectNameStrategy(topic, avro.GetFullName(schema), this.Key); err != nil {
+				return nil, err
+			}
+		}
+		id, err := this.schemaRegistry.Register(subject, schema)
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteByte(0)
+		binary.Write(buffer, binary.BigEndian, id)
+	}
+
+	var writer avro.DatumWriter
+	if _, ok := obj.(*avro.GenericRecord); ok {
+		writer = avro.NewGenericDatumWriter()
+	} else {
+		writer = avro.NewSpecificDatumWriter()
+	}
+	writer.SetSchema(schema)
+	if err := writer.Write(obj, avro.NewBinaryEncoder(buffer)); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+var primitiveAvroSchemas = map[string]avro.Schema{
+	"boolean": avro.MustParseSchema(`{"type": "boolean"}`),
+	"int":     avro.MustParseSchema(`{"type": "int"}`),
+	"long":    avro.MustParseSchema(`{"type": "long"}`),
+	"float":   avro.MustParseSchema(`{"type": "float"}`),
+	"double":  avro.MustParseSchema(`{"type": "double"}`),
+	"string":  avro.MustParseSchema(`{"type": "string"}`),
+	"bytes":   avro.MustParseSchema(`{"type": "bytes"}`),
+}
+
+func avroSchema(obj interface{}) (avro.Schema, error) {
+	switch value := obj.(type) {
+	case bool:
+		return primitiveAvroSchemas["boolean"], nil
+	case int32:
+		return primitiveAvroSchemas["int"], nil
+	case int64:
+		return primitiveAvroSchemas["long"], nil
+	case float32:
+		return primitiveAvroSchemas["float"], nil
+	case float64:
+		return primitiveAvroSchemas["double"], nil
+	case string:
+		return primitiveAvroSchemas["string"], nil
+	case []byte:
+		return primitiveAvroSchemas["bytes"], nil
+	case avro.AvroRecord:
+		return value.Schema(), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported Avro type %T", obj)
+}
+
+// AvroDecoder decodes messages written in the Confluent schema registry wire format:
+// a zero magic byte, a 4-byte big-endian schema id and the Avro binary payload.
+// Record schemas are decoded into *avro.GenericRecord, bytes schemas are returned as []byte.
+// Schemas are fetched from the schema registry once per id and cached afterwards.
+type AvroDecoder struct {
+	schemaRegistry kafkaavro.SchemaRegistryClient
+	schemas        map[int32]avro.Schema
+	schemasLock    sync.RWMutex
+}
+
+// Creates a new AvroDecoder that fetches schemas from a schema registry at a given url.
+func NewAvroDecoder(schemaRegistryUrl string) *AvroDecoder {
+	return NewAvroDecoderWithRegistry(kafkaavro.NewCachedSchemaRegistryClient(schemaRegistryUrl))
+}
+
+// Creates a new AvroDecoder that fetches schemas from a given schema registry client.
+func NewAvroDecoderWithRegistry(schemaRegistry kafkaavro.SchemaRegistryClient) *AvroDecoder {
+	return &AvroDecoder{
+		schemaRegistry: schemaRegistry,
+		schemas:        make(map[int32]avro.Schema),
+	}
+}
+
+func (this *AvroDecoder) Decode(bytes []byte) (interface{}, error) {
+	if bytes == nil {
+		return nil, nil
+	}
+	if len(bytes) < 5 {
+		return nil, errors.New("Avro message is too short to contain a schema id")
+	}
+	if bytes[0] != 0 {
+		return nil, errors.New("Unknown magic byte")
+	}
+
+	schema, err := this.schema(int32(binary.BigEndian.Uint32(bytes[1:5])))
+	if err != nil {
+		return nil, err
+	}
+
+	if schema.Type() == avro.Bytes {
+		return bytes[5:], nil
+	}
+
+	reader := avro.NewGenericDatumReader()
+	reader.SetSchema(schema)
+	record := avro.NewGenericRecord(schema)
+	if err := reader.Read(record, avro.NewBinaryDecoder(bytes[5:])); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (this *AvroDecoder) schema(id int32) (avro.Schema, error) {
+	this.schemasLock.RLock()
+	schema, exists := this.schemas[id]
+	this.schemasLock.RUnlock()
+	if exists {
+		return schema, nil
+	}
+
+	schema, err := this.schemaRegistry.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	this.schemasLock.Lock()
+	this.schemas[id] = schema
+	this.schemasLock.Unlock()
+	return schema, nil
+}
@@ -0,0 +1,193 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/elodina/go-avro"
+	kafkaavro "github.com/elodina/go-kafka-avro"
+)
+
+func TestAvroDecoder(t *testing.T) {
+	schema, err := avro.ParseSchema(`{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}, {"name": "age", "type": "int"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := &mockSchemaRegistry{schemas: map[int32]avro.Schema{3: schema}}
+	decoder := NewAvroDecoderWithRegistry(registry)
+
+	record := avro.NewGenericRecord(schema)
+	record.Set("name", "alice")
+	record.Set("age", int32(42))
+	encoded := encodeAvro(t, 3, schema, record)
+
+	for i := 0; i < 3; i++ {
+		decoded, err := decoder.Decode(encoded)
+		assert(t, err, nil)
+		assert(t, decoded.(*avro.GenericRecord).Get("name"), "alice")
+		assert(t, decoded.(*avro.GenericRecord).Get("age"), int32(42))
+	}
+	assert(t, registry.lookups, 1)
+
+	_, err = decoder.Decode(append([]byte{1}, encoded[1:]...))
+	assertNot(t, err, nil)
+	_, err = decoder.Decode(encoded[:3])
+	assertNot(t, err, nil)
+}
+
+func TestAvroEncoderFraming(t *testing.T) {
+	schema, err := avro.ParseSchema(`{"type": "record", "name": "User", "fields": [{"name": "name", "type": "string"}, {"name": "age", "type": "int"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := avro.NewGenericRecord(schema)
+	record.Set("name", "alice")
+	record.Set("age", int32(42))
+
+	registry := &mockSchemaRegistry{}
+	encoder := NewAvroEncoderWithRegistry(registry)
+	assert(t, encoder.ConfluentFraming, true)
+
+	framed, err := encoder.Encode(record)
+	assert(t, err, nil)
+	assert(t, framed, encodeAvro(t, 1, schema, record))
+	assert(t, registry.registered, []string{"User-value"})
+
+	encoder.ConfluentFraming = false
+	raw, err := encoder.Encode(record)
+	assert(t, err, nil)
+	assert(t, raw, framed[5:])
+	assert(t, len(registry.registered), 1)
+
+	raw, err = encoder.Encode("hello")
+	assert(t, err, nil)
+	assert(t, raw, append([]byte{10}, "hello"...))
+
+	_, err = encoder.Encode(struct{}{})
+	assertNot(t, err, nil)
+
+	registry.schemas = map[int32]avro.Schema{1: schema}
+	decoded, err := NewAvroDecoderWithRegistry(registry).Decode(framed)
+	assert(t, err, nil)
+	assert(t, decoded.(*avro.GenericRecord).Get("name"), "alice")
+}
+
+func TestSubjectNameStrategies(t *testing.T) {
+	subject, err := TopicNameStrategy("users", "com.example.User", false)
+	assert(t, err, nil)
+	assert(t, subject, "users-value")
+	subject, _ = TopicNameStrategy("users", "com.example.User", true)
+	assert(t, subject, "users-key")
+	_, err = TopicNameStrategy("", "com.example.User", false)
+	assertNot(t, err, nil)
+
+	subject, err = RecordNameStrategy("users", "com.example.User", false)
+	assert(t, err, nil)
+	assert(t, subject, "com.example.User")
+
+	subject, err = TopicRecordNameStrategy("users", "com.example.User", true)
+	assert(t, err, nil)
+	assert(t, subject, "users-com.example.User")
+	_, err = TopicRecordNameStrategy("", "com.example.User", true)
+	assertNot(t, err, nil)
+
+	schema, err := avro.ParseSchema(`{"type": "record", "name": "User", "namespace": "com.example", "fields": [{"name": "name", "type": "string"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := avro.NewGenericRecord(schema)
+	record.Set("name", "alice")
+
+	registry := &mockSchemaRegistry{}
+	encoder := NewAvroEncoderWithRegistry(registry)
+	encoder.SubjectNameStrategy = TopicRecordNameStrategy
+	_, err = encoder.EncodeForTopic("users", record)
+	assert(t, err, nil)
+	encoder.SubjectNameStrategy = TopicNameStrategy
+	encoder.Key = true
+	_, err = encoder.EncodeForTopic("users", record)
+	assert(t, err, nil)
+	_, err = encoder.Encode(record)
+	assertNot(t, err, nil)
+	encoder.SubjectNameStrategy = nil
+	_, err = encoder.Encode(record)
+	assert(t, err, nil)
+	assert(t, registry.registered, []string{"users-com.example.User", "users-key", "User-value"})
+}
+
+func TestTopicValueDecoders(t *testing.T) {
+	config := DefaultConsumerConfig()
+	avroDecoder := NewAvroDecoderWithRegistry(&mockSchemaRegistry{})
+	config.TopicValueDecoders = map[string]Decoder{"avro": avroDecoder}
+
+	assert(t, config.valueDecoder("avro"), avroDecoder)
+	assert(t, config.valueDecoder("other"), config.ValueDecoder)
+}
+
+func TestTopicKeyDecoders(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.TopicKeyDecoders = map[string]Decoder{"keyed": &StringDecoder{}}
+	client := NewSiestaClient(config)
+
+	message := &Message{Key: []byte("user-1"), Value: []byte("value"), Topic: "keyed"}
+	assert(t, client.decode(message), nil)
+	assert(t, message.DecodedKey, "user-1")
+	assert(t, message.DecodedValue, []byte("value"))
+
+	message = &Message{Key: []byte("user-1"), Value: []byte("value"), Topic: "other"}
+	assert(t, client.decode(message), nil)
+	assert(t, message.DecodedKey, []byte("user-1"))
+}
+
+func encodeAvro(t *testing.T, schemaId int32, schema avro.Schema, record *avro.GenericRecord) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte(0)
+	binary.Write(buffer, binary.BigEndian, schemaId)
+
+	writer := avro.NewGenericDatumWriter()
+	writer.SetSchema(schema)
+	if err := writer.Write(record, avro.NewBinaryEncoder(buffer)); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+type mockSchemaRegistry struct {
+	schemas    map[int32]avro.Schema
+	lookups    int
+	registered []string
+}
+
+func (this *mockSchemaRegistry) Register(subject string, schema avro.Schema) (int32, error) {
+	this.registered = append(this.registered, subject)
+	return int32(len(this.registered)), nil
+}
+
+func (this *mockSchemaRegistry) GetByID(id int32) (avro.Schema, error) {
+	this.lookups++
+	return this.schemas[id], nil
+}
+
+func (this *mockSchemaRegistry) GetLatestSchemaMetadata(subject string) (*kafkaavro.SchemaMetadata, error) {
+	panic("Not implemented")
+}
+
+func (this *mockSchemaRegistry) GetVersion(subject string, schema avro.Schema) (int32, error) {
+	panic("Not implemented")
+}
@@ -33,6 +33,9 @@ type consumerFetcherManager struct {
 	updateInProgress               bool
 	updatedCond                    *sync.Cond
 	disconnectChannelsForPartition chan TopicAndPartition
+	pauseLock                      sync.Mutex
+	paused                         bool
+	parkedAskNext                  map[*consumerFetcherRoutine][]TopicAndPartition
 
 	metrics *ConsumerMetrics
 	client  LowLevelClient
@@ -220,6 +223,67 @@ func (m *consumerFetcherManager) close() <-chan bool {
 	return m.closeFinished
 }
 
+// Returns the offset of the next message to fetch for a given partition if it is fetched by this manager.
+func (m *consumerFetcherManager) position(topicPartition TopicAndPartition) (int64, bool) {
+	var info *partitionTopicInfo
+	var exists bool
+	inReadLock(&m.updateLock, func() {
+		info, exists = m.partitionMap[topicPartition]
+	})
+	if !exists {
+		return InvalidOffset, false
+	}
+	return info.fetchedOffset(), true
+}
+
+// Stops issuing fetch requests. Asknexts received while paused are parked until resume is called.
+func (m *consumerFetcherManager) pause() {
+	inLock(&m.pauseLock, func() {
+		if !m.paused {
+			Info(m, "Pausing all fetchers")
+			m.paused = true
+			m.parkedAskNext = make(map[*consumerFetcherRoutine][]TopicAndPartition)
+		}
+	})
+}
+
+// Requeues all asknexts parked while paused so fetching continues from the offsets it stopped at.
+func (m *consumerFetcherManager) resume() {
+	var parked map[*consumerFetcherRoutine][]TopicAndPartition
+	inLock(&m.pauseLock, func() {
+		if m.paused {
+			Info(m, "Resuming all fetchers")
+			m.paused = false
+			parked = m.parkedAskNext
+			m.parkedAskNext = nil
+		}
+	})
+
+	for fetcher, topicPartitions := range parked {
+		go fetcher.requeueAskNext(topicPartitions)
+	}
+}
+
+func (m *consumerFetcherManager) isPaused() bool {
+	paused := false
+	inLock(&m.pauseLock, func() {
+		paused = m.paused
+	})
+	return paused
+}
+
+// Returns true and parks a given asknext if fetching is paused.
+func (m *consumerFetcherManager) park(fetcher *consumerFetcherRoutine, topicPartition TopicAndPartition) bool {
+	parked := false
+	inLock(&m.pauseLock, func() {
+		if m.paused {
+			m.parkedAskNext[fetcher] = append(m.parkedAskNext[fetcher], topicPartition)
+			parked = true
+		}
+	})
+	return parked
+}
+
 type consumerFetcherRoutine struct {
 	manager       *consumerFetcherManager
 	name          string
@@ -227,6 +291,7 @@ type consumerFetcherRoutine struct {
 	lock          sync.RWMutex
 	closeFinished chan bool
 	fetchStopper  chan bool
+	stopped       chan struct{}
 	askNext       chan TopicAndPartition
 }
 
@@ -241,6 +306,7 @@ func newConsumerFetcher(m *consumerFetcherManager, name string) *consumerFetcher
 		partitionMap:  make(map[TopicAndPartition]*partitionTopicInfo),
 		closeFinished: make(chan bool),
 		fetchStopper:  make(chan bool),
+		stopped:       make(chan struct{}),
 		askNext:       make(chan TopicAndPartition, m.config.AskNextChannelSize),
 	}
 }
@@ -262,6 +328,12 @@ func (f *consumerFetcherRoutine) start() {
 				if Logger.IsAllowed(DebugLevel) {
 					Debugf(f, "Received asknext for %s", &nextTopicPartition)
 				}
+				if f.manager.park(f, nextTopicPartition) {
+					if Logger.IsAllowed(DebugLevel) {
+						Debugf(f, "Fetching is paused, parked asknext for %s", &nextTopicPartition)
+					}
+					continue
+				}
 				inReadLock(&f.lock, func() {
 					if !f.manager.shuttingDown {
 						if Logger.IsAllowed(DebugLevel) {
@@ -273,7 +345,7 @@ func (f *consumerFetcherRoutine) start() {
 							}
 							return
 						}
-						offset := f.partitionMap[nextTopicPartition].FetchedOffset
+						offset := f.partitionMap[nextTopicPartition].fetchedOffset()
 
 						var messages []*Message
 						var err error
@@ -283,33 +355,21 @@ func (f *consumerFetcherRoutine) start() {
 						f.manager.metrics.numFetchedMessages().Inc(int64(len(messages)))
 
 						if err != nil {
-							switch f.manager.client.GetErrorType(err) {
-							case ErrorTypeOffsetOutOfRange:
-								{
-									Warnf(f, "Current offset %d for topic %s and partition %s is out of range.", offset, nextTopicPartition.Topic, nextTopicPartition.Partition)
-									f.handleOffsetOutOfRange(&nextTopicPartition)
-								}
-							case ErrorTypeCorruptedResponse:
-								{
-									Warnf(f, "Got a corrupted fetch response: %s", err)
-									f.partitionMap[nextTopicPartition].FetchedOffset = f.partitionMap[nextTopicPartition].FetchedOffset + 1
-								}
-							case ErrorTypeOther:
-								{
-									Warnf(f, "Got a fetch error for topic %s, partition %d: %s", nextTopicPartition.Topic, nextTopicPartition.Partition, err)
-									//TODO new backoff type?
-									time.Sleep(1 * time.Second)
-								}
-							}
+							f.handleFetchError(nextTopicPartition, offset, err)
 						}
 
 						if f.manager.config.Debug {
 							for _, message := range messages {
-								message.DecodedKey = append([]int64{timestamp}, message.DecodedKey.([]int64)...)
+								if timestamps, ok := message.DecodedKey.([]int64); ok {
+									message.DecodedKey = append([]int64{timestamp}, timestamps...)
+								}
 							}
 						}
 
 						f.processPartitionData(nextTopicPartition, messages)
+						if f.manager.config.Debug && f.manager.config.OnFetch != nil {
+							f.manager.config.OnFetch(f.fetchInfo(nextTopicPartition, offset, messages, err))
+						}
 					}
 				})
 			}
@@ -334,10 +394,16 @@ func (f *consumerFetcherRoutine) addPartitions(partitionTopicInfos map[TopicAndP
 			if _, contains := f.partitionMap[topicAndPartition]; !contains {
 				f.partitionMap[topicAndPartition] = info
 				validOffset := info.FetchedOffset + 1
-				if isOffsetInvalid(info.FetchedOffset) {
-					f.handleOffsetOutOfRange(&topicAndPartition)
+				if info.Seek {
+					Infof(f, "Seeking %s to offset %d", topicAndPartition, info.FetchedOffset)
+				} else if isOffsetInvalid(info.FetchedOffset) {
+					offsetTime := f.manager.config.AutoOffsetReset
+					if f.manager.config.ResetUncommittedToSmallest {
+						offsetTime = SmallestOffset
+					}
+					f.resetOffset(&topicAndPartition, offsetTime)
 				} else {
-					f.partitionMap[topicAndPartition].FetchedOffset = validOffset
+					f.partitionMap[topicAndPartition].setFetchedOffset(validOffset)
 				}
 				f.partitionMap[topicAndPartition].Buffer.start(f.askNext)
 				newPartitions[topicAndPartition] = f.askNext
@@ -373,13 +439,37 @@ func (f *consumerFetcherRoutine) addPartitions(partitionTopicInfos map[TopicAndP
 	}
 }
 
+// Requeues given asknexts unless this fetcher routine gets closed first, e.g. by a rebalance while fetching was paused.
+func (f *consumerFetcherRoutine) requeueAskNext(topicPartitions []TopicAndPartition) {
+	for _, topicPartition := range topicPartitions {
+	Loop:
+		for {
+			timeout := time.NewTimer(1 * time.Second)
+			select {
+			case f.askNext <- topicPartition:
+				timeout.Stop()
+				break Loop
+			case <-f.stopped:
+				timeout.Stop()
+				return
+			case <-timeout.C:
+				{
+					if f.manager.shuttingDown {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
 func (f *consumerFetcherRoutine) processPartitionData(topicAndPartition TopicAndPartition, messages []*Message) {
 	if Logger.IsAllowed(TraceLevel) {
 		Trace(f, "Trying to acquire lock for partition processing")
 		Tracef(f, "Processing partition data for %s", topicAndPartition)
 	}
 	if len(messages) > 0 {
-		f.partitionMap[topicAndPartition].FetchedOffset = messages[len(messages)-1].Offset + 1
+		f.partitionMap[topicAndPartition].setFetchedOffset(messages[len(messages)-1].Offset + 1)
 	}
 	go f.partitionMap[topicAndPartition].Buffer.addBatch(messages)
 	if Logger.IsAllowed(TraceLevel) {
@@ -387,19 +477,100 @@ func (f *consumerFetcherRoutine) processPartitionData(topicAndPartition TopicAnd
 	}
 }
 
-func (f *consumerFetcherRoutine) handleOffsetOutOfRange(topicAndPartition *TopicAndPartition) {
-	newOffset, err := f.manager.client.GetAvailableOffset(topicAndPartition.Topic, topicAndPartition.Partition, f.manager.config.AutoOffsetReset)
+// Describes a fetch of a given topic-partition at a given offset that returned given messages or error. Must be called
+// after the fetch was processed so that the next offset is known.
+func (f *consumerFetcherRoutine) fetchInfo(topicAndPartition TopicAndPartition, offset int64, messages []*Message, err error) FetchInfo {
+	info := FetchInfo{
+		TopicAndPartition:   topicAndPartition,
+		RequestedOffset:     offset,
+		HighwaterMarkOffset: InvalidOffset,
+		NumMessages:         len(messages),
+		NextOffset:          f.partitionMap[topicAndPartition].fetchedOffset(),
+		Error:               err,
+	}
+	if len(messages) > 0 {
+		info.HighwaterMarkOffset = messages[len(messages)-1].HighwaterMarkOffset
+	}
+	return info
+}
+
+func (f *consumerFetcherRoutine) handleFetchError(topicAndPartition TopicAndPartition, offset int64, err error) {
+	errorType := f.manager.client.GetErrorType(err)
+	switch errorType {
+	case ErrorTypeOffsetOutOfRange:
+		Warnf(f, "Current offset %d for topic %s and partition %d is out of range.", offset, topicAndPartition.Topic, topicAndPartition.Partition)
+	case ErrorTypeCorruptedResponse:
+		Warnf(f, "Got a corrupted fetch response for topic %s, partition %d at offset %d: %s", topicAndPartition.Topic, topicAndPartition.Partition, offset, err)
+	default:
+		Warnf(f, "Got a fetch error for topic %s, partition %d: %s", topicAndPartition.Topic, topicAndPartition.Partition, err)
+	}
+
+	var action FetchErrorAction
+	if f.manager.config.OnFetchError != nil {
+		action = f.manager.config.OnFetchError(topicAndPartition, err)
+	} else {
+		action = f.defaultFetchErrorAction(errorType)
+	}
+
+	switch action {
+	case ResetOffset:
+		{
+			if f.handleOffsetOutOfRange(&topicAndPartition) && errorType == ErrorTypeOffsetOutOfRange {
+				f.manager.metrics.offsetResets().Inc(1)
+			}
+		}
+	case SkipOffset:
+		{
+			Warnf(f, "Skipping offset %d for topic %s, partition %d", offset, topicAndPartition.Topic, topicAndPartition.Partition)
+			if errorType == ErrorTypeCorruptedResponse {
+				f.manager.metrics.corruptedMessages().Inc(1)
+			}
+			f.partitionMap[topicAndPartition].setFetchedOffset(offset + 1)
+		}
+	default:
+		{
+			if errorType == ErrorTypeCorruptedResponse {
+				Errorf(f, "Topic %s, partition %d does not progress past corrupted offset %d as SkipCorruptedMessages is off", topicAndPartition.Topic, topicAndPartition.Partition, offset)
+			}
+			//TODO new backoff type?
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
+
+func (f *consumerFetcherRoutine) defaultFetchErrorAction(errorType ErrorType) FetchErrorAction {
+	switch errorType {
+	case ErrorTypeOffsetOutOfRange:
+		return ResetOffset
+	case ErrorTypeCorruptedResponse:
+		if f.manager.config.SkipCorruptedMessages {
+			return SkipOffset
+		}
+	}
+	return RetryFetch
+}
+
+// Resets the fetched offset of a given topic-partition according to AutoOffsetReset. Returns whether the offset was reset.
+func (f *consumerFetcherRoutine) handleOffsetOutOfRange(topicAndPartition *TopicAndPartition) bool {
+	return f.resetOffset(topicAndPartition, f.manager.config.AutoOffsetReset)
+}
+
+// Resets the fetched offset of a given topic-partition to the smallest or largest available offset. Returns whether the offset was reset.
+func (f *consumerFetcherRoutine) resetOffset(topicAndPartition *TopicAndPartition, offsetTime string) bool {
+	newOffset, err := f.manager.client.GetAvailableOffset(topicAndPartition.Topic, topicAndPartition.Partition, offsetTime)
 	if err != nil {
 		Errorf(f, "Cannot get available offset for %s. Reason: %s", topicAndPartition, err)
-		return
+		return false
 	}
 
 	// Do not use a lock here just because it's faster and it will be checked afterwards if we should still fetch that TopicPartition
 	// This just guarantees we dont get a nil pointer dereference here
 	if topicInfo, exists := f.partitionMap[*topicAndPartition]; exists {
-		topicInfo.FetchedOffset = newOffset
-		f.partitionMap[*topicAndPartition].FetchedOffset = newOffset
+		Infof(f, "Resetting offset for %s from %d to %s offset %d", topicAndPartition, topicInfo.fetchedOffset(), offsetTime, newOffset)
+		topicInfo.setFetchedOffset(newOffset)
+		return true
 	}
+	return false
 }
 
 func (f *consumerFetcherRoutine) removeAllPartitions() {
@@ -424,6 +595,7 @@ func (f *consumerFetcherRoutine) removePartitions(partitions []TopicAndPartition
 func (f *consumerFetcherRoutine) close() <-chan bool {
 	Info(f, "Closing fetcher")
 	go func() {
+		close(f.stopped)
 		f.fetchStopper <- true
 		f.removeAllPartitions()
 		Debug(f, "Sending close finished")
@@ -432,3 +604,43 @@ func (f *consumerFetcherRoutine) close() <-chan bool {
 	}()
 	return f.closeFinished
 }
+
+// A callback that is triggered when fetching a topic-partition fails.
+type FetchErrorCallback func(TopicAndPartition, error) FetchErrorAction
+
+// A callback that is triggered after each fetch in debug mode.
+type FetchCallback func(FetchInfo)
+
+// FetchInfo describes a single fetch of a topic-partition, e.g. to find out why a consumer does not progress.
+type FetchInfo struct {
+	TopicAndPartition
+
+	// Offset the fetch was requested at.
+	RequestedOffset int64
+
+	// High watermark of the partition returned with the fetched messages. InvalidOffset if no messages were fetched.
+	HighwaterMarkOffset int64
+
+	// Number of fetched messages.
+	NumMessages int
+
+	// Offset the next fetch of the partition is requested at.
+	NextOffset int64
+
+	// Fetch error, nil if the fetch succeeded.
+	Error error
+}
+
+// Defines what to do when fetching a topic-partition fails.
+type FetchErrorAction int32
+
+const (
+	// Tells the fetcher to fetch from the same offset again after a backoff.
+	RetryFetch FetchErrorAction = iota
+
+	// Tells the fetcher to reset the offset according to ConsumerConfig.AutoOffsetReset.
+	ResetOffset
+
+	// Tells the fetcher to skip the message at the failed offset and continue from the next one.
+	SkipOffset
+)
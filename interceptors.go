@@ -0,0 +1,431 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/elodina/siesta-producer"
+	metrics "github.com/rcrowley/go-metrics"
+	"sync"
+	"time"
+)
+
+// ConsumerInterceptor allows to inspect, mutate and drop consumed messages before they reach the Strategy and to observe offset commits.
+type ConsumerInterceptor interface {
+	// Called for each batch of messages of a single partition before it is processed. Returns the messages that should be processed.
+	// Offsets of dropped messages are committed as if they were processed.
+	OnConsume(messages []*Message) []*Message
+
+	// Called after offsets have been successfully committed.
+	OnCommit(offsets map[TopicAndPartition]int64)
+}
+
+// ProcessingObserver is notified after each Strategy invocation, e.g. to track per-message processing latency.
+type ProcessingObserver interface {
+	// Called with a processed message, the time Strategy took to process it and an error if processing failed or panicked.
+	// Called from worker goroutines, so implementations must be safe for concurrent use.
+	OnProcessed(msg *Message, duration time.Duration, err error)
+}
+
+// Decorates a given WorkerStrategy so that the latency of each call is recorded in a "StrategyLatency" timer and its outcome
+// in "StrategySuccesses", "StrategySkips" and "StrategyFailures" counters of a given registry. Panics are counted as failures and passed on.
+// Metrics are shared by all strategies instrumented with the same registry.
+func InstrumentedStrategy(inner WorkerStrategy, registry metrics.Registry) WorkerStrategy {
+	latency := metrics.GetOrRegisterTimer("StrategyLatency", registry)
+	successes := metrics.GetOrRegisterCounter("StrategySuccesses", registry)
+	skips := metrics.GetOrRegisterCounter("StrategySkips", registry)
+	failures := metrics.GetOrRegisterCounter("StrategyFailures", registry)
+
+	return func(worker *Worker, msg *Message, id TaskId) (result WorkerResult) {
+		start := time.Now()
+		defer func() {
+			latency.UpdateSince(start)
+			if r := recover(); r != nil {
+				failures.Inc(1)
+				panic(r)
+			}
+			if _, ok := result.(*SkippedResult); ok {
+				skips.Inc(1)
+			} else if result != nil && result.Success() {
+				successes.Inc(1)
+			} else {
+				failures.Inc(1)
+			}
+		}()
+
+		return inner(worker, msg, id)
+	}
+}
+
+// ProducerInterceptor allows to inspect and mutate records before they are sent and to observe their acknowledgements.
+type ProducerInterceptor interface {
+	// Called before a record is sent. Returns the record that should be sent instead, which may be the same record modified in place.
+	OnSend(record *producer.ProducerRecord) *producer.ProducerRecord
+
+	// Called once a record has been acknowledged or failed to send. Error is nil if the record was sent successfully.
+	OnAck(metadata *producer.RecordMetadata, err error)
+}
+
+// InterceptingProducer decorates a producer.Producer with a chain of ProducerInterceptors.
+// Interceptors are called in the order they were given both on send and on acknowledgement.
+type InterceptingProducer struct {
+	producer.Producer
+	interceptors []ProducerInterceptor
+}
+
+// Creates a new InterceptingProducer that sends records with a given producer passing them through given interceptors first.
+func NewInterceptingProducer(p producer.Producer, interceptors ...ProducerInterceptor) *InterceptingProducer {
+	return &InterceptingProducer{
+		Producer:     p,
+		interceptors: interceptors,
+	}
+}
+
+// Passes a given record through all interceptors and sends the result. Acknowledgements are passed to the interceptors
+// before they are delivered to the returned channel.
+func (this *InterceptingProducer) Send(record *producer.ProducerRecord) <-chan *producer.RecordMetadata {
+	for _, interceptor := range this.interceptors {
+		record = interceptor.OnSend(record)
+	}
+
+	metadata := this.Producer.Send(record)
+	intercepted := make(chan *producer.RecordMetadata, 1)
+	go func() {
+		result, ok := <-metadata
+		if !ok {
+			close(intercepted)
+			return
+		}
+		for _, interceptor := range this.interceptors {
+			interceptor.OnAck(result, result.Error)
+		}
+		intercepted <- result
+	}()
+	return intercepted
+}
+
+// InFlightLimitingProducer decorates a producer.Producer so that no more than a given number of records are sent but not yet acknowledged.
+// Send blocks once the limit is reached until an earlier record is acknowledged. A limit of 1 guarantees records are written in the order they were sent.
+type InFlightLimitingProducer struct {
+	producer.Producer
+	slots    chan struct{}
+	inFlight metrics.Counter
+}
+
+// Creates a new InFlightLimitingProducer that sends records with a given producer allowing at most maxInFlight unacknowledged records.
+func NewInFlightLimitingProducer(p producer.Producer, maxInFlight int) *InFlightLimitingProducer {
+	if maxInFlight <= 0 {
+		panic("Max in-flight records must be positive")
+	}
+
+	return &InFlightLimitingProducer{
+		Producer: p,
+		slots:    make(chan struct{}, maxInFlight),
+		inFlight: metrics.NewCounter(),
+	}
+}
+
+// Sends a given record once there is room for it. The record stops counting towards the limit once it is acknowledged or failed to send.
+func (this *InFlightLimitingProducer) Send(record *producer.ProducerRecord) <-chan *producer.RecordMetadata {
+	this.slots <- struct{}{}
+	this.inFlight.Inc(1)
+
+	metadata := this.Producer.Send(record)
+	limited := make(chan *producer.RecordMetadata, 1)
+	go func() {
+		result, ok := <-metadata
+		this.inFlight.Dec(1)
+		<-this.slots
+		if !ok {
+			close(limited)
+			return
+		}
+		limited <- result
+	}()
+	return limited
+}
+
+// Returns the number of records that are currently sent but not yet acknowledged.
+func (this *InFlightLimitingProducer) InFlight() metrics.Counter {
+	return this.inFlight
+}
+
+// DeliveryReport describes the outcome of a single send.
+type DeliveryReport struct {
+	Topic     string
+	Partition int32
+
+	// Offset the record was written at. InvalidOffset if the record was not written.
+	Offset int64
+
+	// Nil if the record was sent successfully.
+	Error error
+}
+
+// ReportingProducer decorates a producer.Producer so that a DeliveryReport is emitted for every completed send, for applications
+// that want to track deliveries asynchronously without waiting on each returned channel. Reports are buffered up to a given size;
+// once the buffer is full further reports are dropped and counted rather than blocking sends.
+type ReportingProducer struct {
+	producer.Producer
+	reports        chan DeliveryReport
+	droppedReports metrics.Counter
+}
+
+// Creates a new ReportingProducer that sends records with a given producer and buffers up to bufferSize delivery reports.
+func NewReportingProducer(p producer.Producer, bufferSize int) *ReportingProducer {
+	if bufferSize <= 0 {
+		panic("Delivery report buffer size must be positive")
+	}
+
+	return &ReportingProducer{
+		Producer:       p,
+		reports:        make(chan DeliveryReport, bufferSize),
+		droppedReports: metrics.NewCounter(),
+	}
+}
+
+func (this *ReportingProducer) String() string {
+	return "reporting-producer"
+}
+
+// Sends a given record and emits a DeliveryReport once it is acknowledged or failed to send.
+// The acknowledgement is still delivered to the returned channel as well.
+func (this *ReportingProducer) Send(record *producer.ProducerRecord) <-chan *producer.RecordMetadata {
+	metadata := this.Producer.Send(record)
+	reported := make(chan *producer.RecordMetadata, 1)
+	go func() {
+		result, ok := <-metadata
+		if !ok {
+			this.report(DeliveryReport{Topic: record.Topic, Partition: record.Partition, Offset: InvalidOffset,
+				Error: fmt.Errorf("Record for topic %s was not acknowledged", record.Topic)})
+			close(reported)
+			return
+		}
+
+		report := DeliveryReport{Topic: result.Topic, Partition: result.Partition, Offset: result.Offset, Error: result.Error}
+		if result.Error != nil {
+			report.Offset = InvalidOffset
+		}
+		this.report(report)
+		reported <- result
+	}()
+	return reported
+}
+
+func (this *ReportingProducer) report(report DeliveryReport) {
+	select {
+	case this.reports <- report:
+	default:
+		Warnf(this, "Delivery report buffer is full, dropping report for topic %s, partition %d", report.Topic, report.Partition)
+		this.droppedReports.Inc(1)
+	}
+}
+
+// Returns a channel that receives a DeliveryReport for every completed send.
+func (this *ReportingProducer) DeliveryReports() <-chan DeliveryReport {
+	return this.reports
+}
+
+// Returns the number of delivery reports dropped because the buffer was full.
+func (this *ReportingProducer) DroppedReports() metrics.Counter {
+	return this.droppedReports
+}
+
+// SlowProduceCallback is called with a record and the time it took to acknowledge it when it was slower than a configured threshold.
+type SlowProduceCallback func(record *producer.ProducerRecord, latency time.Duration)
+
+// SlowProduceProducer decorates a producer.Producer so that OnSlowProduce is called for sends that take longer than
+// SlowProduceThreshold to be acknowledged, to log or alert on degraded broker performance. To avoid flooding, OnSlowProduce is
+// called at most once per debounce interval; slow sends in between are only counted.
+type SlowProduceProducer struct {
+	producer.Producer
+	SlowProduceThreshold time.Duration
+	OnSlowProduce        SlowProduceCallback
+	debounce             time.Duration
+	lastCallback         time.Time
+	lock                 sync.Mutex
+	slowProduces         metrics.Counter
+}
+
+// Creates a new SlowProduceProducer that sends records with a given producer and calls onSlowProduce for sends slower than
+// slowProduceThreshold, at most once per debounce interval. A zero debounce interval calls onSlowProduce for every slow send.
+func NewSlowProduceProducer(p producer.Producer, slowProduceThreshold time.Duration, debounce time.Duration, onSlowProduce SlowProduceCallback) *SlowProduceProducer {
+	if slowProduceThreshold <= 0 {
+		panic("Slow produce threshold must be positive")
+	}
+
+	return &SlowProduceProducer{
+		Producer:             p,
+		SlowProduceThreshold: slowProduceThreshold,
+		OnSlowProduce:        onSlowProduce,
+		debounce:             debounce,
+		slowProduces:         metrics.NewCounter(),
+	}
+}
+
+// Sends a given record and measures the time until it is acknowledged or failed to send.
+// The acknowledgement is still delivered to the returned channel.
+func (this *SlowProduceProducer) Send(record *producer.ProducerRecord) <-chan *producer.RecordMetadata {
+	start := time.Now()
+	metadata := this.Producer.Send(record)
+	measured := make(chan *producer.RecordMetadata, 1)
+	go func() {
+		result, ok := <-metadata
+		if latency := time.Since(start); latency > this.SlowProduceThreshold {
+			this.slowProduce(record, latency)
+		}
+		if ok {
+			measured <- result
+		} else {
+			close(measured)
+		}
+	}()
+	return measured
+}
+
+func (this *SlowProduceProducer) slowProduce(record *producer.ProducerRecord, latency time.Duration) {
+	this.slowProduces.Inc(1)
+	notify := false
+	inLock(&this.lock, func() {
+		if now := time.Now(); now.Sub(this.lastCallback) >= this.debounce {
+			this.lastCallback = now
+			notify = true
+		}
+	})
+
+	if notify && this.OnSlowProduce != nil {
+		this.OnSlowProduce(record, latency)
+	}
+}
+
+// Returns the number of sends that took longer than SlowProduceThreshold, including those OnSlowProduce was not called for.
+func (this *SlowProduceProducer) SlowProduces() metrics.Counter {
+	return this.slowProduces
+}
+
+// Sends given records with a given producer and waits until all of them are acknowledged. All records are sent before waiting
+// for the first acknowledgement so they can be batched by the producer. Returns an error for each record in the same order, nil if it was sent successfully.
+func SendBatch(p producer.Producer, records []*producer.ProducerRecord) []error {
+	acks := make([]<-chan *producer.RecordMetadata, len(records))
+	for i, record := range records {
+		acks[i] = p.Send(record)
+	}
+
+	errs := make([]error, len(records))
+	for i, ack := range acks {
+		metadata, ok := <-ack
+		if !ok {
+			errs[i] = fmt.Errorf("Record for topic %s was not acknowledged", records[i].Topic)
+		} else {
+			errs[i] = metadata.Error
+		}
+	}
+	return errs
+}
+
+// DeadLetteringProducer decorates a producer.Producer so that records that fail to serialize are sent to a dead letter topic
+// as JSON encoded DeadLetters instead of failing one by one. Such records are still reported as failed on the returned channel.
+// Records are serialized only once, by the DeadLetteringProducer, so the decorated producer must be configured with byte array
+// key and value serializers and reports acknowledgements for the serialized records.
+type DeadLetteringProducer struct {
+	producer.Producer
+	keySerializer      producer.Serializer
+	valueSerializer    producer.Serializer
+	deadLetterTopic    string
+	deadLetterProducer producer.Producer
+}
+
+// Creates a new DeadLetteringProducer that sends records serialized with given key and value serializers with a given producer.
+// Records that can't be serialized are sent to deadLetterTopic with deadLetterProducer. Both producers should be configured
+// with byte array key and value serializers.
+func NewDeadLetteringProducer(p producer.Producer, keySerializer producer.Serializer, valueSerializer producer.Serializer,
+	deadLetterTopic string, deadLetterProducer producer.Producer) *DeadLetteringProducer {
+	return &DeadLetteringProducer{
+		Producer:           p,
+		keySerializer:      keySerializer,
+		valueSerializer:    valueSerializer,
+		deadLetterTopic:    deadLetterTopic,
+		deadLetterProducer: deadLetterProducer,
+	}
+}
+
+func (this *DeadLetteringProducer) String() string {
+	return "dead-lettering-producer"
+}
+
+// Sends a given record if it can be serialized and sends a DeadLetter for it otherwise.
+func (this *DeadLetteringProducer) Send(record *producer.ProducerRecord) <-chan *producer.RecordMetadata {
+	key, err := this.keySerializer(record.Key)
+	var value []byte
+	if err == nil {
+		value, err = this.valueSerializer(record.Value)
+	}
+	if err == nil {
+		return this.Producer.Send(&producer.ProducerRecord{
+			Topic:     record.Topic,
+			Partition: record.Partition,
+			Key:       key,
+			Value:     value,
+		})
+	}
+
+	Errorf(this, "Failed to serialize record for topic %s: %s", record.Topic, err)
+	failed := make(chan *producer.RecordMetadata, 1)
+	deadLetter, marshalErr := json.Marshal(&DeadLetter{
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    InvalidOffset,
+		Key:       rawBytes(record.Key),
+		Value:     rawBytes(record.Value),
+		Error:     err.Error(),
+	})
+	if marshalErr != nil {
+		Errorf(this, "Failed to encode dead letter for topic %s: %s", record.Topic, marshalErr)
+		failed <- &producer.RecordMetadata{Record: record, Topic: record.Topic, Partition: record.Partition, Error: err}
+		return failed
+	}
+
+	metadata := this.deadLetterProducer.Send(&producer.ProducerRecord{
+		Topic: this.deadLetterTopic,
+		Key:   rawBytes(record.Key),
+		Value: deadLetter,
+	})
+	go func() {
+		if result, ok := <-metadata; ok && result.Error != nil {
+			Errorf(this, "Failed to send dead letter for topic %s: %s", record.Topic, result.Error)
+		}
+		failed <- &producer.RecordMetadata{Record: record, Topic: record.Topic, Partition: record.Partition, Error: err}
+	}()
+	return failed
+}
+
+// Returns the raw bytes of a record key or value that could not be serialized.
+func rawBytes(value interface{}) []byte {
+	switch raw := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return raw
+	case string:
+		return []byte(raw)
+	}
+
+	if encoded, err := json.Marshal(value); err == nil {
+		return encoded
+	}
+	return []byte(fmt.Sprintf("%v", value))
+}
@@ -0,0 +1,351 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elodina/siesta-producer"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+func TestInterceptingProducer(t *testing.T) {
+	calls := make([]string, 0)
+	first := &recordingInterceptor{name: "first", calls: &calls, topicPrefix: "dc1_"}
+	second := &recordingInterceptor{name: "second", calls: &calls, topicPrefix: "mirror_"}
+
+	mock := newMockProducer(true)
+	intercepting := NewInterceptingProducer(mock, first, second)
+	metadata := <-intercepting.Send(&producer.ProducerRecord{Topic: "test", Value: "value"})
+
+	assert(t, len(mock.records), 1)
+	assert(t, mock.records[0].Topic, "mirror_dc1_test")
+	assert(t, metadata.Topic, "mirror_dc1_test")
+	assert(t, calls, []string{"first send test", "second send dc1_test", "first ack mirror_dc1_test <nil>", "second ack mirror_dc1_test <nil>"})
+}
+
+type recordingInterceptor struct {
+	name        string
+	calls       *[]string
+	topicPrefix string
+}
+
+func (this *recordingInterceptor) OnSend(record *producer.ProducerRecord) *producer.ProducerRecord {
+	*this.calls = append(*this.calls, fmt.Sprintf("%s send %s", this.name, record.Topic))
+	record.Topic = this.topicPrefix + record.Topic
+	return record
+}
+
+func (this *recordingInterceptor) OnAck(metadata *producer.RecordMetadata, err error) {
+	*this.calls = append(*this.calls, fmt.Sprintf("%s ack %s %v", this.name, metadata.Topic, err))
+}
+
+func TestConsumerInterceptors(t *testing.T) {
+	var lock sync.Mutex
+	calls := make([]string, 0)
+	record := func(call string) {
+		inLock(&lock, func() {
+			calls = append(calls, call)
+		})
+	}
+
+	dropOdd := &funcConsumerInterceptor{
+		onConsume: func(messages []*Message) []*Message {
+			record(fmt.Sprintf("drop %d", len(messages)))
+			even := make([]*Message, 0)
+			for _, message := range messages {
+				if message.Offset%2 == 0 {
+					even = append(even, message)
+				}
+			}
+			return even
+		},
+		onCommit: func(offsets map[TopicAndPartition]int64) {
+			record(fmt.Sprintf("drop commit %d", offsets[TopicAndPartition{"fakeTopic", 0}]))
+		},
+	}
+	scrub := &funcConsumerInterceptor{
+		onConsume: func(messages []*Message) []*Message {
+			record(fmt.Sprintf("scrub %d", len(messages)))
+			for _, message := range messages {
+				message.Value = []byte(strings.Repeat("*", len(message.Value)))
+			}
+			return messages
+		},
+		onCommit: func(offsets map[TopicAndPartition]int64) {
+			record(fmt.Sprintf("scrub commit %d", offsets[TopicAndPartition{"fakeTopic", 0}]))
+		},
+	}
+
+	wmid := "test-WM-interceptors"
+	config := DefaultConsumerConfig()
+	config.ConsumerInterceptors = []ConsumerInterceptor{dropOdd, scrub}
+	processed := make(chan *Message, 10)
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		processed <- msg
+		return NewSuccessfulResult(id)
+	}
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+	topicPartition := TopicAndPartition{"fakeTopic", 0}
+
+	manager := NewWorkerManager(wmid, config, topicPartition, newConsumerMetrics(wmid, "", nil), make(chan bool))
+	go manager.Start()
+
+	batch := make([]*Message, 0)
+	for offset := int64(0); offset < 6; offset++ {
+		batch = append(batch, &Message{Topic: "fakeTopic", Partition: 0, Offset: offset, Value: []byte("secret")})
+	}
+	manager.inputChannel <- batch
+	time.Sleep(1 * time.Second)
+	<-manager.Stop()
+	close(processed)
+
+	assert(t, len(processed), 3)
+	for msg := range processed {
+		if msg.Offset%2 != 0 {
+			t.Errorf("Dropped message with offset %d should not be processed", msg.Offset)
+		}
+		assert(t, msg.Value, []byte("******"))
+	}
+	//the last message was dropped but its offset is still committed
+	assert(t, mockZk.commits()[topicPartition], int64(5))
+	assert(t, calls, []string{"drop 6", "scrub 3", "drop commit 5", "scrub commit 5"})
+}
+
+type funcConsumerInterceptor struct {
+	onConsume func([]*Message) []*Message
+	onCommit  func(map[TopicAndPartition]int64)
+}
+
+func (this *funcConsumerInterceptor) OnConsume(messages []*Message) []*Message {
+	return this.onConsume(messages)
+}
+
+func (this *funcConsumerInterceptor) OnCommit(offsets map[TopicAndPartition]int64) {
+	this.onCommit(offsets)
+}
+
+func TestInstrumentedStrategy(t *testing.T) {
+	registry := metrics.NewRegistry()
+	strategy := InstrumentedStrategy(func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		time.Sleep(10 * time.Millisecond)
+		if msg.Offset == 3 {
+			return NewSkippedResult(id)
+		}
+		if msg.Offset%2 == 0 {
+			return NewSuccessfulResult(id)
+		}
+		return NewProcessingFailedResult(id)
+	}, registry)
+
+	for offset := int64(0); offset < 4; offset++ {
+		strategy(nil, &Message{Offset: offset}, TaskId{TopicAndPartition{"test", 0}, offset})
+	}
+	assert(t, registry.Get("StrategySuccesses").(metrics.Counter).Count(), int64(2))
+	assert(t, registry.Get("StrategySkips").(metrics.Counter).Count(), int64(1))
+	assert(t, registry.Get("StrategyFailures").(metrics.Counter).Count(), int64(1))
+	latency := registry.Get("StrategyLatency").(metrics.Timer)
+	assert(t, latency.Count(), int64(4))
+	if latency.Min() < int64(10*time.Millisecond) {
+		t.Errorf("Strategy latency %d is lower than the strategy takes", latency.Min())
+	}
+
+	panicking := InstrumentedStrategy(func(_ *Worker, _ *Message, _ TaskId) WorkerResult { panic("boom") }, registry)
+	func() {
+		defer func() {
+			assert(t, recover(), "boom")
+		}()
+		panicking(nil, &Message{}, TaskId{})
+	}()
+	assert(t, registry.Get("StrategyFailures").(metrics.Counter).Count(), int64(2))
+	assert(t, latency.Count(), int64(5))
+}
+
+func TestInFlightLimitingProducer(t *testing.T) {
+	mock := &manualAckProducer{mockProducer: newMockProducer(false), acks: make(chan chan *producer.RecordMetadata, 10)}
+	limiting := NewInFlightLimitingProducer(mock, 2)
+
+	first := limiting.Send(&producer.ProducerRecord{Topic: "test", Value: "1"})
+	limiting.Send(&producer.ProducerRecord{Topic: "test", Value: "2"})
+	assert(t, limiting.InFlight().Count(), int64(2))
+
+	sent := make(chan struct{})
+	go func() {
+		limiting.Send(&producer.ProducerRecord{Topic: "test", Value: "3"})
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("Send should block once the in-flight limit is reached")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	(<-mock.acks) <- &producer.RecordMetadata{Topic: "test"}
+	assert(t, (<-first).Topic, "test")
+	select {
+	case <-sent:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Send should be unblocked once a record is acknowledged")
+	}
+	assert(t, limiting.InFlight().Count(), int64(2))
+	assert(t, len(mock.records), 3)
+}
+
+func TestSendBatch(t *testing.T) {
+	mock := newMockProducer(true)
+	failing := NewDeadLetteringProducer(mock, producer.ByteSerializer, producer.StringSerializer, "dlq", newMockProducer(true))
+
+	errs := SendBatch(failing, []*producer.ProducerRecord{
+		&producer.ProducerRecord{Topic: "test", Value: "1"},
+		&producer.ProducerRecord{Topic: "test", Value: 2},
+		&producer.ProducerRecord{Topic: "test", Value: "3"},
+	})
+	assert(t, len(errs), 3)
+	assert(t, errs[0], nil)
+	assertNot(t, errs[1], nil)
+	assert(t, errs[2], nil)
+	assert(t, len(mock.records), 2)
+}
+
+func TestDeadLetteringProducer(t *testing.T) {
+	mock := newMockProducer(true)
+	deadLetters := newMockProducer(true)
+	deadLettering := NewDeadLetteringProducer(mock, producer.ByteSerializer, producer.StringSerializer, "dlq", deadLetters)
+
+	serializations := 0
+	counting := func(value interface{}) ([]byte, error) {
+		serializations++
+		return producer.StringSerializer(value)
+	}
+	deadLettering = NewDeadLetteringProducer(mock, producer.ByteSerializer, counting, "dlq", deadLetters)
+
+	//records are serialized once and sent to the decorated producer as bytes
+	result := <-deadLettering.Send(&producer.ProducerRecord{Topic: "test", Partition: 1, Key: []byte("k1"), Value: "good"})
+	assert(t, result.Error, nil)
+	assert(t, serializations, 1)
+	assert(t, mock.records[0].Topic, "test")
+	assert(t, mock.records[0].Partition, int32(1))
+	assert(t, mock.records[0].Key, []byte("k1"))
+	assert(t, mock.records[0].Value, []byte("good"))
+
+	result = <-deadLettering.Send(&producer.ProducerRecord{Topic: "test", Partition: 2, Key: []byte("k2"), Value: map[string]int{"bad": 1}})
+	assertNot(t, result.Error, nil)
+	assert(t, len(mock.records), 1)
+	assert(t, len(deadLetters.records), 1)
+
+	record := deadLetters.records[0]
+	assert(t, record.Topic, "dlq")
+	assert(t, record.Key, []byte("k2"))
+	deadLetter := &DeadLetter{}
+	assert(t, json.Unmarshal(record.Value.([]byte), deadLetter), nil)
+	assert(t, deadLetter.Topic, "test")
+	assert(t, deadLetter.Partition, int32(2))
+	assert(t, deadLetter.Offset, InvalidOffset)
+	assert(t, string(deadLetter.Value), `{"bad":1}`)
+	assert(t, deadLetter.Error, result.Error.Error())
+}
+
+func TestReportingProducer(t *testing.T) {
+	mock := &manualAckProducer{mockProducer: newMockProducer(false), acks: make(chan chan *producer.RecordMetadata, 10)}
+	reporting := NewReportingProducer(mock, 2)
+
+	first := reporting.Send(&producer.ProducerRecord{Topic: "test", Partition: 1, Value: "1"})
+	reporting.Send(&producer.ProducerRecord{Topic: "test", Partition: 2, Value: "2"})
+	reporting.Send(&producer.ProducerRecord{Topic: "test", Partition: 3, Value: "3"})
+
+	(<-mock.acks) <- &producer.RecordMetadata{Topic: "test", Partition: 1, Offset: 10}
+	assert(t, (<-first).Offset, int64(10))
+	assert(t, <-reporting.DeliveryReports(), DeliveryReport{Topic: "test", Partition: 1, Offset: 10})
+
+	sendErr := errors.New("boom")
+	(<-mock.acks) <- &producer.RecordMetadata{Topic: "test", Partition: 2, Offset: 11, Error: sendErr}
+	assert(t, <-reporting.DeliveryReports(), DeliveryReport{Topic: "test", Partition: 2, Offset: InvalidOffset, Error: sendErr})
+
+	close(<-mock.acks)
+	report := <-reporting.DeliveryReports()
+	assert(t, report.Partition, int32(3))
+	assertNot(t, report.Error, nil)
+
+	// reports that don't fit into the buffer are dropped instead of blocking sends
+	for i := 0; i < 3; i++ {
+		reporting.Send(&producer.ProducerRecord{Topic: "test", Value: "overflow"})
+		(<-mock.acks) <- &producer.RecordMetadata{Topic: "test"}
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert(t, len(reporting.DeliveryReports()), 2)
+	assert(t, reporting.DroppedReports().Count(), int64(1))
+}
+
+func TestSlowProduceProducer(t *testing.T) {
+	mock := &manualAckProducer{mockProducer: newMockProducer(false), acks: make(chan chan *producer.RecordMetadata, 10)}
+	slow := make(chan string, 10)
+	slowProducer := NewSlowProduceProducer(mock, 100*time.Millisecond, 500*time.Millisecond, func(record *producer.ProducerRecord, latency time.Duration) {
+		if latency < 100*time.Millisecond {
+			t.Errorf("Slow produce callback called with latency %s below threshold", latency)
+		}
+		slow <- record.Value.(string)
+	})
+
+	fast := slowProducer.Send(&producer.ProducerRecord{Topic: "test", Value: "fast"})
+	(<-mock.acks) <- &producer.RecordMetadata{Topic: "test", Offset: 1}
+	assert(t, (<-fast).Offset, int64(1))
+
+	delayed := slowProducer.Send(&producer.ProducerRecord{Topic: "test", Value: "slow"})
+	ack := <-mock.acks
+	time.Sleep(150 * time.Millisecond)
+	ack <- &producer.RecordMetadata{Topic: "test", Offset: 2}
+	assert(t, (<-delayed).Offset, int64(2))
+	assert(t, <-slow, "slow")
+
+	// slow sends within the debounce interval are counted but don't call back again
+	delayed = slowProducer.Send(&producer.ProducerRecord{Topic: "test", Value: "debounced"})
+	ack = <-mock.acks
+	time.Sleep(150 * time.Millisecond)
+	ack <- &producer.RecordMetadata{Topic: "test", Offset: 3}
+	assert(t, (<-delayed).Offset, int64(3))
+	time.Sleep(50 * time.Millisecond)
+	assert(t, len(slow), 0)
+	assert(t, slowProducer.SlowProduces().Count(), int64(2))
+
+	time.Sleep(300 * time.Millisecond)
+	delayed = slowProducer.Send(&producer.ProducerRecord{Topic: "test", Value: "slow again"})
+	ack = <-mock.acks
+	time.Sleep(150 * time.Millisecond)
+	close(ack)
+	_, ok := <-delayed
+	assert(t, ok, false)
+	assert(t, <-slow, "slow again")
+}
+
+type manualAckProducer struct {
+	*mockProducer
+	acks chan chan *producer.RecordMetadata
+}
+
+func (this *manualAckProducer) Send(record *producer.ProducerRecord) <-chan *producer.RecordMetadata {
+	this.mockProducer.Send(record)
+	metadata := make(chan *producer.RecordMetadata, 1)
+	this.acks <- metadata
+	return metadata
+}
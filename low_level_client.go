@@ -16,10 +16,14 @@ limitations under the License. */
 package go_kafka_client
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"time"
 
 	"github.com/elodina/siesta"
+	"github.com/elodina/siesta-producer"
 )
 
 type ErrorType int
@@ -81,20 +85,47 @@ func (this *SiestaClient) Initialize() error {
 		return err
 	}
 
+	this.connector, err = siesta.NewDefaultConnector(this.connectorConfig(bootstrapBrokers))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (this *SiestaClient) connectorConfig(bootstrapBrokers []string) *siesta.ConnectorConfig {
 	connectorConfig := siesta.NewConnectorConfig()
 	connectorConfig.BrokerList = bootstrapBrokers
-	connectorConfig.ReadTimeout = this.config.SocketTimeout
-	connectorConfig.WriteTimeout = this.config.SocketTimeout
-	connectorConfig.ConnectTimeout = this.config.SocketTimeout
+	connectorConfig.ReadTimeout = socketTimeout(this.config.ReadTimeout, this.config.SocketTimeout)
+	connectorConfig.WriteTimeout = socketTimeout(this.config.WriteTimeout, this.config.SocketTimeout)
+	connectorConfig.ConnectTimeout = socketTimeout(this.config.DialTimeout, this.config.SocketTimeout)
+	if this.config.KeepAlive < 0 {
+		connectorConfig.KeepAlive = false
+	} else if this.config.KeepAlive > 0 {
+		connectorConfig.KeepAlive = true
+		connectorConfig.KeepAliveTimeout = this.config.KeepAlive
+	}
+	if this.config.ConnectionsPerBroker > 0 {
+		connectorConfig.MaxConnectionsPerBroker = this.config.ConnectionsPerBroker
+	}
 	connectorConfig.FetchSize = this.config.FetchMessageMaxBytes
+	connectorConfig.FetchMinBytes = this.config.FetchMinBytes
+	if this.config.FetchMinBytes > this.config.FetchMessageMaxBytes {
+		Warnf(this, "FetchMinBytes %d is larger than FetchMessageMaxBytes %d, capping it to FetchMessageMaxBytes",
+			this.config.FetchMinBytes, this.config.FetchMessageMaxBytes)
+		connectorConfig.FetchMinBytes = this.config.FetchMessageMaxBytes
+	}
+	connectorConfig.FetchMaxWaitTime = this.config.FetchWaitMaxMs
 	connectorConfig.ClientID = this.config.Clientid
 
-	this.connector, err = siesta.NewDefaultConnector(connectorConfig)
-	if err != nil {
-		return err
-	}
+	return connectorConfig
+}
 
-	return nil
+func socketTimeout(timeout time.Duration, fallback time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return fallback
 }
 
 // This will be called each time the fetch request to Kafka should be issued. Topic, partition and offset are self-explanatory.
@@ -110,37 +141,122 @@ func (this *SiestaClient) Fetch(topic string, partition int32, offset int64) ([]
 
 	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
 	collector := func(topic string, partition int32, offset int64, key []byte, value []byte) error {
-		decodedKey, err := this.config.KeyDecoder.Decode(key)
-		if err != nil {
-			//TODO: what if we fail to decode the key: fail-fast or fail-safe strategy?
-			Error(this, err.Error())
-			return err
+		message := &Message{
+			Key:                 key,
+			Value:               value,
+			Topic:               topic,
+			Partition:           partition,
+			Offset:              offset,
+			HighwaterMarkOffset: response.Data[topic][partition].HighwaterMarkOffset,
 		}
-		decodedValue, err := this.config.ValueDecoder.Decode(value)
-		if err != nil {
-			//TODO: what if we fail to decode the value: fail-fast or fail-safe strategy?
-			Error(this, err.Error())
+		if err := this.decode(message); err != nil {
 			return err
 		}
 
 		if this.config.Debug {
-			decodedKey = []int64{timestamp}
+			message.DecodedKey = []int64{timestamp}
 		}
 
-		messages = append(messages, &Message{
-			Key:                 key,
-			Value:               value,
-			DecodedKey:          decodedKey,
-			DecodedValue:        decodedValue,
-			Topic:               topic,
-			Partition:           partition,
-			Offset:              offset,
-			HighwaterMarkOffset: response.Data[topic][partition].HighwaterMarkOffset,
-		})
+		messages = append(messages, message)
 		return nil
 	}
 
-	return messages, response.CollectMessages(collector)
+	return messages, collectMessages(response, collector)
+}
+
+// Passes all messages of a given fetch response to a given collector, unpacking compressed message sets.
+// Stops at the first message that does not match its CRC and returns siesta.ErrInvalidMessage.
+func collectMessages(response *siesta.FetchResponse, collector func(topic string, partition int32, offset int64, key []byte, value []byte) error) error {
+	for topic, partitionAndData := range response.Data {
+		for partition, data := range partitionAndData {
+			if data.Error != siesta.ErrNoError {
+				return data.Error
+			}
+			for _, messageAndOffset := range data.Messages {
+				messages := []*siesta.MessageAndOffset{messageAndOffset}
+				if messageAndOffset.Message.Nested != nil {
+					messages = messageAndOffset.Message.Nested
+				}
+				for _, message := range messages {
+					if !validCrc(message.Message) {
+						return siesta.ErrInvalidMessage
+					}
+					if err := collector(topic, partition, message.Offset, message.Message.Key, message.Message.Value); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func validCrc(message *siesta.Message) bool {
+	buffer := make([]byte, 0, 10+len(message.Key)+len(message.Value))
+	buffer = append(buffer, byte(message.MagicByte), byte(message.Attributes))
+	for _, field := range [][]byte{message.Key, message.Value} {
+		length := make([]byte, 4)
+		if field == nil {
+			binary.BigEndian.PutUint32(length, uint32(0xFFFFFFFF))
+		} else {
+			binary.BigEndian.PutUint32(length, uint32(len(field)))
+		}
+		buffer = append(append(buffer, length...), field...)
+	}
+
+	return int32(crc32.ChecksumIEEE(buffer)) == message.Crc
+}
+
+// Decodes the key and value of a given message. If decoding fails and a dead letter topic is configured the message
+// is sent there and marked to be skipped, otherwise the decoding error is returned.
+func (this *SiestaClient) decode(message *Message) error {
+	var err error
+	message.DecodedKey, err = this.config.keyDecoder(message.Topic).Decode(message.Key)
+	if err == nil {
+		message.DecodedValue, err = this.config.valueDecoder(message.Topic).Decode(message.Value)
+	}
+	if err == nil {
+		return nil
+	}
+
+	Errorf(this, "Failed to decode %s: %s", message, err)
+	if this.config.DeadLetterTopic == "" {
+		return err
+	}
+	if err := this.sendDeadLetter(message, err); err != nil {
+		return err
+	}
+	message.deadLettered = true
+	return nil
+}
+
+func (this *SiestaClient) sendDeadLetter(message *Message, decodeErr error) error {
+	deadLetter, err := json.Marshal(&DeadLetter{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       message.Key,
+		Value:     message.Value,
+		Error:     decodeErr.Error(),
+	})
+	if err != nil {
+		return err
+	}
+
+	metadata := this.config.DeadLetterProducer.Send(&producer.ProducerRecord{
+		Topic: this.config.DeadLetterTopic,
+		Key:   message.Key,
+		Value: deadLetter,
+	})
+	timeout := time.NewTimer(this.config.SocketTimeout)
+	defer timeout.Stop()
+	select {
+	case result := <-metadata:
+		return result.Error
+	case <-timeout.C:
+		return fmt.Errorf("Dead letter for %s was not acknowledged within %s", message, this.config.SocketTimeout)
+	}
 }
 
 // Tells the caller what kind of error it is.
@@ -148,7 +264,7 @@ func (this *SiestaClient) GetErrorType(err error) ErrorType {
 	switch {
 	case err == siesta.ErrOffsetOutOfRange:
 		return ErrorTypeOffsetOutOfRange
-	case err == siesta.ErrEOF:
+	case err == siesta.ErrEOF, err == siesta.ErrInvalidMessage:
 		return ErrorTypeCorruptedResponse
 	default:
 		return ErrorTypeOther
@@ -180,6 +296,70 @@ func (this *SiestaClient) CommitOffset(group string, topic string, partition int
 	return this.connector.CommitOffset(group, topic, partition, offset)
 }
 
+// Fetches committed offsets of a given consumer group for all topic-partitions known to ConsumerCoordinator using OffsetFetch API.
+// Topic-partitions without committed offsets are omitted. Useful for building lag monitoring tools.
+func (this *SiestaClient) FetchGroupOffsets(group string) (map[TopicAndPartition]int64, error) {
+	return fetchGroupOffsets(this.config, this, group)
+}
+
+// Commits offsets of a given consumer group for all partitions of a given topic so that the group resumes consumption
+// from a position defined by a given OffsetSpec. Offsets are committed to ConsumerConfig.OffsetStorage without running a consumer.
+// Refuses to reset offsets of a group that has registered consumers, as they would overwrite the reset offsets.
+// Partitions reset to offset 0 have no handled offset to commit, so consumers of the group only start them at offset 0
+// if ConsumerConfig.ResetUncommittedToSmallest is set or AutoOffsetReset is SmallestOffset. Returns the offsets each partition resumes at.
+func (this *SiestaClient) ResetGroupOffsets(group string, topic string, to OffsetSpec) (map[TopicAndPartition]int64, error) {
+	storage := this.config.OffsetStorage
+	if storage == nil {
+		storage = this
+	}
+	return resetGroupOffsets(this.config, this, storage, group, topic, to)
+}
+
+// Gets the first offset of the latest log segment of a given topic and partition written before a given time.
+func (this *SiestaClient) GetOffsetBefore(topic string, partition int32, timestamp time.Time) (int64, error) {
+	return this.connector.GetAvailableOffset(topic, partition, timestamp.UnixNano()/int64(time.Millisecond))
+}
+
+func fetchGroupOffsets(config *ConsumerConfig, storage OffsetStorage, group string) (map[TopicAndPartition]int64, error) {
+	topics, err := config.Coordinator.GetAllTopics()
+	if err != nil {
+		return nil, err
+	}
+	topicPartitions, err := config.Coordinator.GetPartitionsForTopics(topics)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[TopicAndPartition]int64)
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			offset, err := fetchGroupOffset(config, storage, group, topic, partition)
+			if err != nil {
+				return nil, err
+			}
+			if !isOffsetInvalid(offset) {
+				offsets[TopicAndPartition{topic, partition}] = offset
+			}
+		}
+	}
+
+	return offsets, nil
+}
+
+func fetchGroupOffset(config *ConsumerConfig, storage OffsetStorage, group string, topic string, partition int32) (offset int64, err error) {
+	backoffMultiplier := 1
+	for i := 0; i <= config.OffsetsCommitMaxRetries; i++ {
+		offset, err = storage.GetOffset(group, topic, partition)
+		if err != siesta.ErrNotCoordinatorForConsumerCode && err != siesta.ErrConsumerCoordinatorNotAvailableCode && err != siesta.ErrOffsetsLoadInProgressCode {
+			return
+		}
+		Debugf(config.Clientid, "Fetching offset for group %s, topic %s, partition %d failed after %d-th retry: %s", group, topic, partition, i, err)
+		time.Sleep(config.RefreshLeaderBackoff * time.Duration(backoffMultiplier))
+		backoffMultiplier++
+	}
+	return
+}
+
 // Gracefully shuts down this client.
 func (this *SiestaClient) Close() {
 	<-this.connector.Close()
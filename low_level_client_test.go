@@ -0,0 +1,664 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/elodina/siesta"
+	"github.com/elodina/siesta-producer"
+)
+
+func TestSiestaClientFetchSettings(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.FetchMinBytes = 64 * 1024
+	config.FetchWaitMaxMs = 500
+	config.FetchMessageMaxBytes = 512 * 1024
+	config.Clientid = "test-client"
+
+	connectorConfig := NewSiestaClient(config).connectorConfig([]string{"localhost:9092"})
+	assert(t, connectorConfig.ClientID, "test-client")
+	assert(t, connectorConfig.FetchMinBytes, int32(64*1024))
+	assert(t, connectorConfig.FetchMaxWaitTime, int32(500))
+	assert(t, connectorConfig.FetchSize, int32(512*1024))
+	assert(t, connectorConfig.Validate(), nil)
+
+	config.FetchMinBytes = config.FetchMessageMaxBytes + 1
+	connectorConfig = NewSiestaClient(config).connectorConfig([]string{"localhost:9092"})
+	assert(t, connectorConfig.FetchMinBytes, config.FetchMessageMaxBytes)
+}
+
+func TestSiestaClientSocketSettings(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.SocketTimeout = 10 * time.Second
+	config.DialTimeout = 1 * time.Second
+	config.KeepAlive = 15 * time.Second
+
+	connectorConfig := NewSiestaClient(config).connectorConfig([]string{"localhost:9092"})
+	assert(t, connectorConfig.ConnectTimeout, 1*time.Second)
+	assert(t, connectorConfig.ReadTimeout, 10*time.Second)
+	assert(t, connectorConfig.WriteTimeout, 10*time.Second)
+	assert(t, connectorConfig.KeepAlive, true)
+	assert(t, connectorConfig.KeepAliveTimeout, 15*time.Second)
+
+	config.KeepAlive = 0
+	connectorConfig = NewSiestaClient(config).connectorConfig([]string{"localhost:9092"})
+	assert(t, connectorConfig.KeepAlive, true)
+	assert(t, connectorConfig.KeepAliveTimeout, siesta.NewConnectorConfig().KeepAliveTimeout)
+
+	config.KeepAlive = -1
+	assert(t, NewSiestaClient(config).connectorConfig([]string{"localhost:9092"}).KeepAlive, false)
+}
+
+func TestSiestaClientConnectionsPerBroker(t *testing.T) {
+	config := DefaultConsumerConfig()
+	connectorConfig := NewSiestaClient(config).connectorConfig([]string{"localhost:9092"})
+	assert(t, connectorConfig.MaxConnectionsPerBroker, siesta.NewConnectorConfig().MaxConnectionsPerBroker)
+
+	config.ConnectionsPerBroker = 10
+	connectorConfig = NewSiestaClient(config).connectorConfig([]string{"localhost:9092"})
+	assert(t, connectorConfig.MaxConnectionsPerBroker, 10)
+	assert(t, connectorConfig.Validate(), nil)
+
+	config.Strategy = goodStrategy
+	config.WorkerFailureCallback = func(_ *WorkerManager) FailedDecision { return CommitOffsetAndContinue }
+	config.WorkerFailedAttemptCallback = func(_ *Task, _ WorkerResult) FailedDecision { return CommitOffsetAndContinue }
+	config.ConnectionsPerBroker = 0
+	assert(t, config.Validate(), nil)
+	assert(t, NewSiestaClient(config).connectorConfig([]string{"localhost:9092"}).MaxConnectionsPerBroker,
+		siesta.NewConnectorConfig().MaxConnectionsPerBroker)
+	config.ConnectionsPerBroker = -1
+	assert(t, config.Validate(), errors.New("ConnectionsPerBroker cannot be negative"))
+}
+
+func BenchmarkConnectionsPerBroker_1(b *testing.B) {
+	benchmarkConnectionsPerBroker(b, 1)
+}
+
+func BenchmarkConnectionsPerBroker_5(b *testing.B) {
+	benchmarkConnectionsPerBroker(b, 5)
+}
+
+func BenchmarkConnectionsPerBroker_10(b *testing.B) {
+	benchmarkConnectionsPerBroker(b, 10)
+}
+
+// Issues concurrent requests to a single broker that takes a millisecond to answer each of them. Fetch and produce requests
+// to a broker go through the same connection pool, so their throughput scales with the pool size the same way.
+func benchmarkConnectionsPerBroker(b *testing.B, connections int) {
+	broker := slowMetadataBroker(b, time.Millisecond)
+	defer broker.Close()
+
+	config := DefaultConsumerConfig()
+	config.ConnectionsPerBroker = connections
+	connector, err := siesta.NewDefaultConnector(NewSiestaClient(config).connectorConfig([]string{broker.Addr().String()}))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer connector.Close()
+	// the connector sets up its bootstrap links on the first request, which is not safe to do concurrently
+	if _, err := connector.GetTopicMetadata(nil); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetParallelism(10)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := connector.GetTopicMetadata(nil); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+//a broker that answers every request on a connection with an empty metadata response after a given delay
+func slowMetadataBroker(b *testing.B, delay time.Duration) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					size := make([]byte, 4)
+					if _, err := io.ReadFull(conn, size); err != nil {
+						return
+					}
+					sizeValue, _ := siesta.NewBinaryDecoder(size).GetInt32()
+					request := make([]byte, sizeValue)
+					if _, err := io.ReadFull(conn, request); err != nil {
+						return
+					}
+					time.Sleep(delay)
+
+					//api key and version precede the correlation id
+					correlationId, _ := siesta.NewBinaryDecoder(request[4:8]).GetInt32()
+					response := make([]byte, 4+4+4+4)
+					encoder := siesta.NewBinaryEncoder(response)
+					encoder.WriteInt32(int32(len(response) - 4))
+					encoder.WriteInt32(correlationId)
+					encoder.WriteInt32(0)
+					encoder.WriteInt32(0)
+					if _, err := conn.Write(response); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestSiestaClientUnresponsiveBroker(t *testing.T) {
+	// accepts connections but never responds
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			if _, err := listener.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	config := DefaultConsumerConfig()
+	config.ReadTimeout = 200 * time.Millisecond
+	client := NewSiestaClient(config)
+	connectorConfig := client.connectorConfig([]string{listener.Addr().String()})
+	connectorConfig.MetadataRetries = 0
+	client.connector, err = siesta.NewDefaultConnector(connectorConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = client.GetAvailableOffset("test", 0, SmallestOffset)
+	assertNot(t, err, nil)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Request to an unresponsive broker should time out within the read timeout, took %s", elapsed)
+	}
+}
+
+func TestSiestaClientUnresponsiveDial(t *testing.T) {
+	// listens with a zero backlog and never accepts, so connection attempts hang once the accept queue is full
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sockaddr, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", sockaddr.(*syscall.SockaddrInet4).Port)
+
+	filled := false
+	for i := 0; i < 10 && !filled; i++ {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			filled = true
+		} else {
+			defer conn.Close()
+		}
+	}
+	if !filled {
+		t.Skip("Could not fill the accept queue of the listener")
+	}
+
+	config := DefaultConsumerConfig()
+	config.DialTimeout = 200 * time.Millisecond
+	client := NewSiestaClient(config)
+	connectorConfig := client.connectorConfig([]string{addr})
+	connectorConfig.MetadataRetries = 0
+	client.connector, err = siesta.NewDefaultConnector(connectorConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = client.GetAvailableOffset("test", 0, SmallestOffset)
+	assertNot(t, err, nil)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Connecting to an unresponsive broker should time out within the dial timeout, took %s", elapsed)
+	}
+}
+
+func TestSendToBroker(t *testing.T) {
+	leader := fakeProduceBroker(t, siesta.ErrNoError, 42)
+	defer leader.Close()
+	follower := fakeProduceBroker(t, siesta.ErrNotLeaderForPartition, -1)
+	defer follower.Close()
+	connector := &brokersConnector{brokers: []*siesta.Broker{brokerAt(t, 1, leader), brokerAt(t, 2, follower)}}
+
+	record := &producer.ProducerRecord{Topic: "test", Partition: 0, Value: []byte("value")}
+	offset, err := SendToBroker(connector, 1, record)
+	assert(t, err, nil)
+	assert(t, offset, int64(42))
+
+	_, err = SendToBroker(connector, 2, record)
+	assert(t, err, siesta.ErrNotLeaderForPartition)
+
+	_, err = SendToBroker(connector, 3, record)
+	assertNot(t, err, nil)
+}
+
+//a broker that answers every produce request for partition 0 of topic "test" with a given error and offset
+func fakeProduceBroker(t *testing.T, produceErr error, offset int64) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorCode := int16(-1)
+	for code, brokerErr := range siesta.BrokerErrors {
+		if brokerErr == produceErr {
+			errorCode = int16(code)
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			size := make([]byte, 4)
+			io.ReadFull(conn, size)
+			sizeValue, _ := siesta.NewBinaryDecoder(size).GetInt32()
+			io.ReadFull(conn, make([]byte, sizeValue))
+
+			response := make([]byte, 4+4+4+2+len("test")+4+4+2+8)
+			encoder := siesta.NewBinaryEncoder(response)
+			encoder.WriteInt32(int32(len(response) - 4))
+			encoder.WriteInt32(0)
+			encoder.WriteInt32(1)
+			encoder.WriteString("test")
+			encoder.WriteInt32(1)
+			encoder.WriteInt32(0)
+			encoder.WriteInt16(errorCode)
+			encoder.WriteInt64(offset)
+			conn.Write(response)
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+func brokerAt(t *testing.T, id int32, listener net.Listener) *siesta.Broker {
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portValue, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &siesta.Broker{ID: id, Host: host, Port: int32(portValue)}
+}
+
+type brokersConnector struct {
+	siesta.Connector
+	brokers []*siesta.Broker
+}
+
+func (this *brokersConnector) GetTopicMetadata(topics []string) (*siesta.MetadataResponse, error) {
+	return &siesta.MetadataResponse{Brokers: this.brokers}, nil
+}
+
+func TestDeadLetter(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.ValueDecoder = NewAvroDecoderWithRegistry(&mockSchemaRegistry{})
+	client := NewSiestaClient(config)
+
+	message := &Message{Key: []byte("key"), Value: []byte("not avro"), Topic: "avro", Partition: 1, Offset: 5}
+	assertNot(t, client.decode(message), nil)
+	assert(t, message.deadLettered, false)
+
+	deadLetterProducer := newMockProducer(true)
+	config.DeadLetterTopic = "avro-dead-letters"
+	config.DeadLetterProducer = deadLetterProducer
+	assert(t, client.decode(message), nil)
+	assert(t, message.deadLettered, true)
+
+	assert(t, len(deadLetterProducer.records), 1)
+	record := deadLetterProducer.records[0]
+	assert(t, record.Topic, "avro-dead-letters")
+	assert(t, record.Key, []byte("key"))
+	deadLetter := &DeadLetter{}
+	assert(t, json.Unmarshal(record.Value.([]byte), deadLetter), nil)
+	assert(t, deadLetter.Topic, "avro")
+	assert(t, deadLetter.Partition, int32(1))
+	assert(t, deadLetter.Offset, int64(5))
+	assert(t, deadLetter.Value, []byte("not avro"))
+	assertNot(t, deadLetter.Error, "")
+
+	config.SocketTimeout = 100 * time.Millisecond
+	config.DeadLetterProducer = newMockProducer(false)
+	message.deadLettered = false
+	assertNot(t, client.decode(message), nil)
+	assert(t, message.deadLettered, false)
+}
+
+func TestCorruptedMessages(t *testing.T) {
+	topicPartition := TopicAndPartition{"topic", 0}
+	messages := make([]*siesta.MessageAndOffset, 0)
+	for offset := int64(0); offset < 5; offset++ {
+		messages = append(messages, encodeSiestaMessage(t, offset, nil, []byte(fmt.Sprintf("message-%d", offset))))
+	}
+	messages[2].Message.Value[0] ^= 0xFF
+	response := &siesta.FetchResponse{Data: map[string]map[int32]*siesta.FetchResponsePartitionData{
+		"topic": {0: &siesta.FetchResponsePartitionData{Error: siesta.ErrNoError, HighwaterMarkOffset: 5, Messages: messages}},
+	}}
+
+	collected := make([]int64, 0)
+	err := collectMessages(response, func(topic string, partition int32, offset int64, key []byte, value []byte) error {
+		assert(t, value, []byte(fmt.Sprintf("message-%d", offset)))
+		collected = append(collected, offset)
+		return nil
+	})
+	assert(t, err, siesta.ErrInvalidMessage)
+	assert(t, collected, []int64{0, 1})
+
+	config := DefaultConsumerConfig()
+	assert(t, config.SkipCorruptedMessages, true)
+	client := NewSiestaClient(config)
+	assert(t, client.GetErrorType(err), ErrorTypeCorruptedResponse)
+
+	metrics := newConsumerMetrics("test-corrupted", "", nil)
+	fetcher := &consumerFetcherRoutine{
+		manager:      &consumerFetcherManager{config: config, client: client, metrics: metrics},
+		partitionMap: map[TopicAndPartition]*partitionTopicInfo{topicPartition: &partitionTopicInfo{FetchedOffset: 2}},
+	}
+	fetcher.handleFetchError(topicPartition, 2, err)
+	assert(t, fetcher.partitionMap[topicPartition].FetchedOffset, int64(3))
+	assert(t, metrics.corruptedMessages().Count(), int64(1))
+
+	//the partition does not progress if corrupted messages should not be skipped
+	config.SkipCorruptedMessages = false
+	fetcher.handleFetchError(topicPartition, 3, err)
+	assert(t, fetcher.partitionMap[topicPartition].FetchedOffset, int64(3))
+	assert(t, metrics.corruptedMessages().Count(), int64(1))
+	metrics.close()
+}
+
+func TestFetchErrorCallback(t *testing.T) {
+	topicPartition := TopicAndPartition{"topic", 0}
+	config := DefaultConsumerConfig()
+	client := &availableOffsetClient{SiestaClient: NewSiestaClient(config), availableOffset: 100}
+	metrics := newConsumerMetrics("test-fetch-error-callback", "", nil)
+	fetcher := &consumerFetcherRoutine{
+		manager:      &consumerFetcherManager{config: config, client: client, metrics: metrics},
+		partitionMap: map[TopicAndPartition]*partitionTopicInfo{topicPartition: &partitionTopicInfo{FetchedOffset: 10}},
+	}
+
+	//by default out of range offsets are reset
+	fetcher.handleFetchError(topicPartition, 10, siesta.ErrOffsetOutOfRange)
+	assert(t, fetcher.partitionMap[topicPartition].FetchedOffset, int64(100))
+
+	errs := make([]error, 0)
+	action := SkipOffset
+	config.OnFetchError = func(tp TopicAndPartition, err error) FetchErrorAction {
+		assert(t, tp, topicPartition)
+		errs = append(errs, err)
+		return action
+	}
+	fetcher.handleFetchError(topicPartition, 100, siesta.ErrOffsetOutOfRange)
+	assert(t, fetcher.partitionMap[topicPartition].FetchedOffset, int64(101))
+
+	fetcher.handleFetchError(topicPartition, 101, siesta.ErrInvalidMessage)
+	assert(t, fetcher.partitionMap[topicPartition].FetchedOffset, int64(102))
+	assert(t, metrics.corruptedMessages().Count(), int64(1))
+
+	action = RetryFetch
+	fetcher.handleFetchError(topicPartition, 102, siesta.ErrOffsetOutOfRange)
+	assert(t, fetcher.partitionMap[topicPartition].FetchedOffset, int64(102))
+
+	action = ResetOffset
+	client.availableOffset = 50
+	fetcher.handleFetchError(topicPartition, 102, siesta.ErrNotLeaderForPartition)
+	assert(t, fetcher.partitionMap[topicPartition].FetchedOffset, int64(50))
+
+	assert(t, errs, []error{siesta.ErrOffsetOutOfRange, siesta.ErrInvalidMessage, siesta.ErrOffsetOutOfRange, siesta.ErrNotLeaderForPartition})
+	metrics.close()
+}
+
+func TestOffsetOutOfRangeReset(t *testing.T) {
+	belowStart := TopicAndPartition{"topic", 0}
+	aboveEnd := TopicAndPartition{"topic", 1}
+	config := DefaultConsumerConfig()
+	metrics := newConsumerMetrics("test-offset-out-of-range", "", nil)
+	fetcher := &consumerFetcherRoutine{
+		manager: &consumerFetcherManager{config: config, client: &offsetRangeClient{smallest: 10, largest: 100}, metrics: metrics},
+		partitionMap: map[TopicAndPartition]*partitionTopicInfo{
+			belowStart: &partitionTopicInfo{FetchedOffset: 5},
+			aboveEnd:   &partitionTopicInfo{FetchedOffset: 150},
+		},
+	}
+
+	config.AutoOffsetReset = SmallestOffset
+	fetcher.handleFetchError(belowStart, 5, siesta.ErrOffsetOutOfRange)
+	assert(t, fetcher.partitionMap[belowStart].fetchedOffset(), int64(10))
+	assert(t, fetcher.partitionMap[aboveEnd].fetchedOffset(), int64(150))
+	assert(t, metrics.offsetResets().Count(), int64(1))
+
+	config.AutoOffsetReset = LargestOffset
+	fetcher.handleFetchError(aboveEnd, 150, siesta.ErrOffsetOutOfRange)
+	assert(t, fetcher.partitionMap[aboveEnd].fetchedOffset(), int64(100))
+	assert(t, fetcher.partitionMap[belowStart].fetchedOffset(), int64(10))
+	assert(t, metrics.offsetResets().Count(), int64(2))
+
+	//starting a partition without a committed offset is not an out of range reset
+	fetcher.partitionMap[belowStart].setFetchedOffset(InvalidOffset)
+	assert(t, fetcher.handleOffsetOutOfRange(&belowStart), true)
+	assert(t, fetcher.partitionMap[belowStart].fetchedOffset(), int64(100))
+	assert(t, metrics.offsetResets().Count(), int64(2))
+
+	//neither is a reset requested for other fetch errors
+	config.OnFetchError = func(TopicAndPartition, error) FetchErrorAction { return ResetOffset }
+	fetcher.handleFetchError(belowStart, 100, siesta.ErrNotLeaderForPartition)
+	assert(t, metrics.offsetResets().Count(), int64(2))
+	metrics.close()
+}
+
+type availableOffsetClient struct {
+	*SiestaClient
+	availableOffset int64
+}
+
+func (this *availableOffsetClient) GetAvailableOffset(topic string, partition int32, offsetTime string) (int64, error) {
+	return this.availableOffset, nil
+}
+
+func encodeSiestaMessage(t *testing.T, offset int64, key []byte, value []byte) *siesta.MessageAndOffset {
+	message := &siesta.MessageAndOffset{Offset: offset, Message: &siesta.Message{Key: key, Value: value}}
+	sizing := siesta.NewSizingEncoder()
+	message.Write(sizing)
+	buffer := make([]byte, sizing.Size())
+	message.Write(siesta.NewBinaryEncoder(buffer))
+
+	decoded := &siesta.MessageAndOffset{}
+	if err := decoded.Read(siesta.NewBinaryDecoder(buffer)); err != nil {
+		t.Fatal(err.Reason())
+	}
+	return decoded
+}
+
+func TestFetchGroupOffsets(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.RefreshLeaderBackoff = 10 * time.Millisecond
+	mockZk := newMockZookeeperCoordinator()
+	mockZk.partitions["topic1"] = []int32{0, 1}
+	mockZk.partitions["topic2"] = []int32{0}
+	config.Coordinator = mockZk
+
+	storage := &flakyOffsetStorage{
+		failures: 2,
+		offsets: map[TopicAndPartition]int64{
+			TopicAndPartition{"topic1", 0}: 10,
+			TopicAndPartition{"topic1", 1}: 20,
+		},
+	}
+
+	offsets, err := fetchGroupOffsets(config, storage, "lag-group")
+	assert(t, err, nil)
+	assert(t, len(offsets), 2)
+	assert(t, offsets[TopicAndPartition{"topic1", 0}], int64(10))
+	assert(t, offsets[TopicAndPartition{"topic1", 1}], int64(20))
+
+	storage.failures = config.OffsetsCommitMaxRetries + 1
+	_, err = fetchGroupOffsets(config, storage, "lag-group")
+	assert(t, err, siesta.ErrNotCoordinatorForConsumerCode)
+}
+
+func TestResetGroupOffsets(t *testing.T) {
+	config := DefaultConsumerConfig()
+	mockZk := newMockZookeeperCoordinator()
+	mockZk.partitions["topic1"] = []int32{0, 1}
+	coordinator := &groupMembersCoordinator{mockZookeeperCoordinator: mockZk}
+	config.Coordinator = coordinator
+	storage := NewInMemoryOffsetStorage()
+	client := &timestampOffsetRangeClient{
+		offsetRangeClient: &offsetRangeClient{smallest: 10, largest: 100},
+		offsets:           map[int64]int64{1000: 40},
+	}
+
+	offsets, err := resetGroupOffsets(config, client, storage, "reset-group", "topic1", ResetToEarliest())
+	assert(t, err, nil)
+	assert(t, offsets, map[TopicAndPartition]int64{TopicAndPartition{"topic1", 0}: 10, TopicAndPartition{"topic1", 1}: 10})
+	committed, _ := storage.GetOffset("reset-group", "topic1", 1)
+	assert(t, committed, int64(9))
+
+	offsets, err = resetGroupOffsets(config, client, storage, "reset-group", "topic1", ResetToTimestamp(time.Unix(1, 0)))
+	assert(t, err, nil)
+	assert(t, offsets[TopicAndPartition{"topic1", 0}], int64(40))
+	committed, _ = storage.GetOffset("reset-group", "topic1", 0)
+	assert(t, committed, int64(39))
+
+	_, err = resetGroupOffsets(config, client, storage, "reset-group", "topic1", ResetToOffset(500))
+	assertNot(t, err, nil)
+	_, err = resetGroupOffsets(config, client, storage, "reset-group", "unknown", ResetToLatest())
+	assertNot(t, err, nil)
+
+	coordinator.consumers = []string{"consumer-1"}
+	_, err = resetGroupOffsets(config, client, storage, "reset-group", "topic1", ResetToLatest())
+	assertNot(t, err, nil)
+	committed, _ = storage.GetOffset("reset-group", "topic1", 0)
+	assert(t, committed, int64(39))
+}
+
+func TestResetGroupOffsetsToStart(t *testing.T) {
+	config := DefaultConsumerConfig()
+	mockZk := newMockZookeeperCoordinator()
+	mockZk.partitions["topic1"] = []int32{0}
+	config.Coordinator = &groupMembersCoordinator{mockZookeeperCoordinator: mockZk}
+	storage := NewInMemoryOffsetStorage()
+	client := &offsetRangeClient{smallest: 0, largest: 100}
+
+	offsets, err := resetGroupOffsets(config, client, storage, "reset-group", "topic1", ResetToEarliest())
+	assert(t, err, nil)
+	assert(t, offsets[TopicAndPartition{"topic1", 0}], int64(0))
+	committed, _ := storage.GetOffset("reset-group", "topic1", 0)
+	assert(t, committed, InvalidOffset)
+
+	//the default AutoOffsetReset would start a partition without committed offset at the log end
+	config.AutoOffsetReset = LargestOffset
+	config.ResetUncommittedToSmallest = true
+	metrics := newConsumerMetrics("test-reset-to-start", "", nil)
+	defer metrics.close()
+	fetcher := newConsumerFetcher(&consumerFetcherManager{config: config, client: client, metrics: metrics}, "test-fetcher")
+	reset := TopicAndPartition{"topic1", 0}
+	uncommitted := TopicAndPartition{"topic1", 1}
+	resetBuffer := newMessageBuffer(reset, make(chan []*Message), config)
+	uncommittedBuffer := newMessageBuffer(uncommitted, make(chan []*Message), config)
+	fetcher.addPartitions(map[TopicAndPartition]*partitionTopicInfo{
+		reset:       &partitionTopicInfo{Topic: "topic1", Partition: 0, FetchedOffset: committed, Buffer: resetBuffer},
+		uncommitted: &partitionTopicInfo{Topic: "topic1", Partition: 1, FetchedOffset: InvalidOffset, Buffer: uncommittedBuffer},
+	})
+	resetBuffer.stop()
+	uncommittedBuffer.stop()
+
+	assert(t, fetcher.partitionMap[reset].fetchedOffset(), int64(0))
+	assert(t, fetcher.partitionMap[uncommitted].fetchedOffset(), int64(0))
+
+	//out of range offsets are still reset according to AutoOffsetReset
+	fetcher.handleFetchError(reset, 150, siesta.ErrOffsetOutOfRange)
+	assert(t, fetcher.partitionMap[reset].fetchedOffset(), int64(100))
+}
+
+type groupMembersCoordinator struct {
+	*mockZookeeperCoordinator
+	consumers []string
+}
+
+func (this *groupMembersCoordinator) GetConsumersInGroup(group string) ([]string, error) {
+	return this.consumers, nil
+}
+
+type timestampOffsetRangeClient struct {
+	*offsetRangeClient
+	offsets map[int64]int64
+}
+
+func (this *timestampOffsetRangeClient) GetOffsetBefore(topic string, partition int32, timestamp time.Time) (int64, error) {
+	return this.offsets[timestamp.UnixNano()/int64(time.Millisecond)], nil
+}
+
+// flakyOffsetStorage fails with NotCoordinator error a given number of times before returning known offsets.
+type flakyOffsetStorage struct {
+	failures int
+	offsets  map[TopicAndPartition]int64
+}
+
+func (f *flakyOffsetStorage) GetOffset(group string, topic string, partition int32) (int64, error) {
+	if f.failures > 0 {
+		f.failures--
+		return InvalidOffset, siesta.ErrNotCoordinatorForConsumerCode
+	}
+	if offset, exists := f.offsets[TopicAndPartition{topic, partition}]; exists {
+		return offset, nil
+	}
+	return InvalidOffset, nil
+}
+
+func (f *flakyOffsetStorage) CommitOffset(group string, topic string, partition int32, offset int64) error {
+	f.offsets[TopicAndPartition{topic, partition}] = offset
+	return nil
+}
@@ -50,6 +50,39 @@ func TestMessageBuffer(t *testing.T) {
 	receiveNoMessages(t, 4*time.Second, out)
 }
 
+func TestMessageBufferBatchSizeCap(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.FetchBatchSize = 3
+	config.FetchBatchTimeout = 3 * time.Second
+
+	out := make(chan []*Message)
+	topicPartition := TopicAndPartition{"fakeTopic", 0}
+	askNextBatch := make(chan TopicAndPartition)
+	buffer := newMessageBuffer(topicPartition, out, config)
+	buffer.start(askNextBatch)
+
+	go buffer.addBatch(generateBatch(topicPartition, 10))
+
+	offsets := make([]int64, 0)
+	for len(offsets) < 9 {
+		select {
+		case batch := <-out:
+			assert(t, len(batch), config.FetchBatchSize)
+			for _, message := range batch {
+				offsets = append(offsets, message.Offset)
+			}
+		case <-time.After(4 * time.Second):
+			t.Fatal("Failed to receive full batches")
+		}
+	}
+	assert(t, offsets, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8})
+	expectAskNext(t, askNextBatch, 2*time.Second)
+
+	//the remainder is flushed on timeout
+	receiveN(t, 1, 4*time.Second, out)
+	buffer.stop()
+}
+
 func expectAskNext(t *testing.T, askNext chan TopicAndPartition, timeout time.Duration) {
 	select {
 	case <-askNext:
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	metrics "github.com/rcrowley/go-metrics"
@@ -26,7 +27,7 @@ import (
 )
 
 type ConsumerMetrics struct {
-	registry     metrics.Registry
+	registry     *TaggedRegistry
 	consumerName string
 	prefix       string
 
@@ -34,25 +35,45 @@ type ConsumerMetrics struct {
 	fetchersIdleTimer       metrics.Timer
 	fetchDurationTimer      metrics.Timer
 
-	numWorkerManagersGauge metrics.Gauge
-	activeWorkersCounter   metrics.Counter
-	pendingWMsTasksCounter metrics.Counter
-	taskTimeoutCounter     metrics.Counter
-	wmsBatchDurationTimer  metrics.Timer
-	wmsIdleTimer           metrics.Timer
+	numWorkerManagersGauge       metrics.Gauge
+	activeWorkersCounter         metrics.Counter
+	pendingWMsTasksCounter       metrics.Counter
+	taskTimeoutCounter           metrics.Counter
+	strategyPanicCounter         metrics.Counter
+	quarantinedPartitionsCounter metrics.Counter
+	rebalancesThrottledCounter   metrics.Counter
+	claimConflictsCounter        metrics.Counter
+	wmsBatchDurationTimer        metrics.Timer
+	wmsIdleTimer                 metrics.Timer
 
 	numFetchedMessagesCounter  metrics.Counter
 	numConsumedMessagesCounter metrics.Counter
 	numAcksCounter             metrics.Counter
+	numSkipsCounter            metrics.Counter
+	offsetCommitRequestCounter metrics.Counter
+	offsetCommitFailureCounter metrics.Counter
+	corruptedMessagesCounter   metrics.Counter
+	offsetResetsCounter        metrics.Counter
+	staleMessagesCounter       metrics.Counter
+	compactedMessagesCounter   metrics.Counter
+	clockSkewCounter           metrics.Counter
 	topicPartitionLag          map[TopicAndPartition]metrics.Gauge
+	endToEndLatency            map[string]metrics.Histogram
+
+	rebalanceDurationTimer      metrics.Timer
+	rebalancesCounter           metrics.Counter
+	timeSinceLastRebalanceGauge metrics.Gauge
 
 	metricLock            sync.Mutex
 	reportingStopChannels []chan struct{}
 }
 
-func newConsumerMetrics(consumerName, prefix string) *ConsumerMetrics {
+func newConsumerMetrics(consumerName, prefix string, registry metrics.Registry) *ConsumerMetrics {
+	if registry == nil {
+		registry = metrics.NewRegistry()
+	}
 	kafkaMetrics := &ConsumerMetrics{
-		registry: metrics.DefaultRegistry,
+		registry: NewTaggedRegistry(registry),
 	}
 
 	// Ensure prefix ends with a dot (.) so it plays nice with statsd/graphite
@@ -69,20 +90,53 @@ func newConsumerMetrics(consumerName, prefix string) *ConsumerMetrics {
 	kafkaMetrics.numWorkerManagersGauge = metrics.NewRegisteredGauge(fmt.Sprintf("%sNumWorkerManagers-%s", prefix, consumerName), kafkaMetrics.registry)
 	kafkaMetrics.activeWorkersCounter = metrics.NewRegisteredCounter(fmt.Sprintf("%sWMsActiveWorkers-%s", prefix, consumerName), kafkaMetrics.registry)
 	kafkaMetrics.pendingWMsTasksCounter = metrics.NewRegisteredCounter(fmt.Sprintf("%sWMsPendingTasks-%s", prefix, consumerName), kafkaMetrics.registry)
-	kafkaMetrics.taskTimeoutCounter = metrics.NewRegisteredCounter(fmt.Sprintf("%sTaskTimeouts-%s", prefix, consumerName), kafkaMetrics.registry)
+	kafkaMetrics.taskTimeoutCounter = kafkaMetrics.taggedCounter("TaskTimeouts")
+	kafkaMetrics.strategyPanicCounter = kafkaMetrics.taggedCounter("StrategyPanics")
+	kafkaMetrics.quarantinedPartitionsCounter = kafkaMetrics.taggedCounter("QuarantinedPartitions")
+	kafkaMetrics.rebalancesThrottledCounter = kafkaMetrics.taggedCounter("RebalancesThrottled")
+	kafkaMetrics.claimConflictsCounter = kafkaMetrics.taggedCounter("ClaimConflicts")
 	kafkaMetrics.wmsBatchDurationTimer = metrics.NewRegisteredTimer(fmt.Sprintf("%sWMsBatchDuration-%s", prefix, consumerName), kafkaMetrics.registry)
 	kafkaMetrics.wmsIdleTimer = metrics.NewRegisteredTimer(fmt.Sprintf("%sWMsIdleTime-%s", prefix, consumerName), kafkaMetrics.registry)
 
-	kafkaMetrics.numFetchedMessagesCounter = metrics.NewRegisteredCounter(fmt.Sprintf("%sFetchedMessages-%s", prefix, consumerName), kafkaMetrics.registry)
-	kafkaMetrics.numConsumedMessagesCounter = metrics.NewRegisteredCounter(fmt.Sprintf("%sConsumedMessages-%s", prefix, consumerName), kafkaMetrics.registry)
-	kafkaMetrics.numAcksCounter = metrics.NewRegisteredCounter(fmt.Sprintf("%sAcks-%s", prefix, consumerName), kafkaMetrics.registry)
+	kafkaMetrics.numFetchedMessagesCounter = kafkaMetrics.taggedCounter("FetchedMessages")
+	kafkaMetrics.numConsumedMessagesCounter = kafkaMetrics.taggedCounter("ConsumedMessages")
+	kafkaMetrics.numAcksCounter = kafkaMetrics.taggedCounter("Acks")
+	kafkaMetrics.numSkipsCounter = kafkaMetrics.taggedCounter("Skips")
+	kafkaMetrics.offsetCommitRequestCounter = metrics.NewRegisteredCounter(fmt.Sprintf("%sOffsetCommitRequests-%s", prefix, consumerName), kafkaMetrics.registry)
+	kafkaMetrics.offsetCommitFailureCounter = kafkaMetrics.taggedCounter("OffsetCommitFailures")
+	kafkaMetrics.corruptedMessagesCounter = kafkaMetrics.taggedCounter("CorruptedMessages")
+	kafkaMetrics.offsetResetsCounter = kafkaMetrics.taggedCounter("OffsetResets")
+	kafkaMetrics.staleMessagesCounter = kafkaMetrics.taggedCounter("StaleMessages")
+	kafkaMetrics.compactedMessagesCounter = kafkaMetrics.taggedCounter("CompactedMessages")
+	kafkaMetrics.clockSkewCounter = kafkaMetrics.taggedCounter("ClockSkews")
 	kafkaMetrics.topicPartitionLag = make(map[TopicAndPartition]metrics.Gauge)
+	kafkaMetrics.endToEndLatency = make(map[string]metrics.Histogram)
+
+	kafkaMetrics.rebalanceDurationTimer = metrics.NewRegisteredTimer(fmt.Sprintf("%sRebalanceDuration-%s", prefix, consumerName), kafkaMetrics.registry)
+	kafkaMetrics.rebalancesCounter = metrics.NewRegisteredCounter(fmt.Sprintf("%sRebalances-%s", prefix, consumerName), kafkaMetrics.registry)
+	kafkaMetrics.timeSinceLastRebalanceGauge = &elapsedGauge{}
+	kafkaMetrics.registry.Register(fmt.Sprintf("%sTimeSinceLastRebalance-%s", prefix, consumerName), kafkaMetrics.timeSinceLastRebalanceGauge)
 
 	kafkaMetrics.reportingStopChannels = make([]chan struct{}, 0)
 
 	return kafkaMetrics
 }
 
+// Registers a given metric of a given family tagged with the consumer name and given tags.
+func (this *ConsumerMetrics) registerTagged(name string, family string, tags map[string]string, metric interface{}) {
+	consumerTags := map[string]string{"consumer": this.consumerName}
+	for key, value := range tags {
+		consumerTags[key] = value
+	}
+	this.registry.RegisterTagged(name, this.prefix+family, consumerTags, metric)
+}
+
+func (this *ConsumerMetrics) taggedCounter(family string) metrics.Counter {
+	counter := metrics.NewCounter()
+	this.registerTagged(fmt.Sprintf("%s%s-%s", this.prefix, family, this.consumerName), family, nil, counter)
+	return counter
+}
+
 func (this *ConsumerMetrics) fetchersIdle() metrics.Timer {
 	return this.fetchersIdleTimer
 }
@@ -111,6 +165,22 @@ func (this *ConsumerMetrics) taskTimeouts() metrics.Counter {
 	return this.taskTimeoutCounter
 }
 
+func (this *ConsumerMetrics) strategyPanics() metrics.Counter {
+	return this.strategyPanicCounter
+}
+
+func (this *ConsumerMetrics) quarantinedPartitions() metrics.Counter {
+	return this.quarantinedPartitionsCounter
+}
+
+func (this *ConsumerMetrics) rebalancesThrottled() metrics.Counter {
+	return this.rebalancesThrottledCounter
+}
+
+func (this *ConsumerMetrics) claimConflicts() metrics.Counter {
+	return this.claimConflictsCounter
+}
+
 func (this *ConsumerMetrics) activeWorkers() metrics.Counter {
 	return this.activeWorkersCounter
 }
@@ -127,21 +197,123 @@ func (this *ConsumerMetrics) numAcks() metrics.Counter {
 	return this.numAcksCounter
 }
 
+func (this *ConsumerMetrics) numSkips() metrics.Counter {
+	return this.numSkipsCounter
+}
+
+func (this *ConsumerMetrics) offsetCommitRequests() metrics.Counter {
+	return this.offsetCommitRequestCounter
+}
+
+func (this *ConsumerMetrics) offsetCommitFailures() metrics.Counter {
+	return this.offsetCommitFailureCounter
+}
+
+func (this *ConsumerMetrics) corruptedMessages() metrics.Counter {
+	return this.corruptedMessagesCounter
+}
+
+func (this *ConsumerMetrics) offsetResets() metrics.Counter {
+	return this.offsetResetsCounter
+}
+
+func (this *ConsumerMetrics) staleMessages() metrics.Counter {
+	return this.staleMessagesCounter
+}
+
+func (this *ConsumerMetrics) compactedMessages() metrics.Counter {
+	return this.compactedMessagesCounter
+}
+
+func (this *ConsumerMetrics) clockSkews() metrics.Counter {
+	return this.clockSkewCounter
+}
+
+// Returns a histogram of nanoseconds between producing and consuming messages of a given topic.
+func (this *ConsumerMetrics) topicEndToEndLatency(topic string) metrics.Histogram {
+	var latency metrics.Histogram
+	inLock(&this.metricLock, func() {
+		var ok bool
+		if latency, ok = this.endToEndLatency[topic]; !ok {
+			latency = metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015))
+			this.registerTagged(fmt.Sprintf("%sEndToEndLatency-%s-%s", this.prefix, this.consumerName, topic), "EndToEndLatency", map[string]string{"topic": topic}, latency)
+			this.endToEndLatency[topic] = latency
+		}
+	})
+	return latency
+}
+
+// messageConsumed records the end-to-end latency of a message of a given topic produced at a given time. Latencies that are negative
+// because of clock skew between producers and this consumer are recorded as zero and counted separately.
+func (this *ConsumerMetrics) messageConsumed(topic string, produced time.Time) {
+	latency := time.Since(produced)
+	if latency < 0 {
+		this.clockSkews().Inc(1)
+		latency = 0
+	}
+	this.topicEndToEndLatency(topic).Update(int64(latency))
+}
+
+func (this *ConsumerMetrics) rebalanceDuration() metrics.Timer {
+	return this.rebalanceDurationTimer
+}
+
+func (this *ConsumerMetrics) rebalances() metrics.Counter {
+	return this.rebalancesCounter
+}
+
+func (this *ConsumerMetrics) timeSinceLastRebalance() metrics.Gauge {
+	return this.timeSinceLastRebalanceGauge
+}
+
+// rebalanceCompleted records a successful rebalance that started at a given time.
+func (this *ConsumerMetrics) rebalanceCompleted(start time.Time) {
+	this.rebalanceDuration().UpdateSince(start)
+	this.rebalances().Inc(1)
+	this.timeSinceLastRebalance().Update(time.Now().UnixNano())
+}
+
 func (this *ConsumerMetrics) topicAndPartitionLag(topic string, partition int32) metrics.Gauge {
 	topicAndPartition := TopicAndPartition{Topic: topic, Partition: partition}
-	lag, ok := this.topicPartitionLag[topicAndPartition]
-	if !ok {
-		inLock(&this.metricLock, func() {
-			lag, ok = this.topicPartitionLag[topicAndPartition]
-			if !ok {
-				this.topicPartitionLag[topicAndPartition] = metrics.NewRegisteredGauge(fmt.Sprintf("%sLag-%s-%s", this.prefix, this.consumerName, &topicAndPartition), this.registry)
-				lag = this.topicPartitionLag[topicAndPartition]
-			}
-		})
-	}
+	var lag metrics.Gauge
+	inLock(&this.metricLock, func() {
+		var ok bool
+		if lag, ok = this.topicPartitionLag[topicAndPartition]; !ok {
+			lag = metrics.NewGauge()
+			this.registerTagged(fmt.Sprintf("%sLag-%s-%s", this.prefix, this.consumerName, &topicAndPartition), "Lag",
+				map[string]string{"topic": topic, "partition": fmt.Sprint(partition)}, lag)
+			this.topicPartitionLag[topicAndPartition] = lag
+		}
+	})
 	return lag
 }
 
+func (this *ConsumerMetrics) registerZookeeperState(state metrics.Gauge) {
+	this.registry.Register(fmt.Sprintf("%sZookeeperState-%s", this.prefix, this.consumerName), state)
+}
+
+// elapsedGauge is a gauge that reports milliseconds elapsed since the Unix nanosecond timestamp it was last updated with.
+// Reports 0 until the first update.
+type elapsedGauge struct {
+	since int64
+}
+
+func (g *elapsedGauge) Snapshot() metrics.Gauge {
+	return metrics.GaugeSnapshot(g.Value())
+}
+
+func (g *elapsedGauge) Update(timestamp int64) {
+	atomic.StoreInt64(&g.since, timestamp)
+}
+
+func (g *elapsedGauge) Value() int64 {
+	since := atomic.LoadInt64(&g.since)
+	if since == 0 {
+		return 0
+	}
+	return int64(time.Since(time.Unix(0, since)) / time.Millisecond)
+}
+
 func (this *ConsumerMetrics) Stats() map[string]map[string]float64 {
 	metricsMap := make(map[string]map[string]float64)
 	this.registry.Each(func(name string, metric interface{}) {
@@ -211,6 +383,17 @@ func (this *ConsumerMetrics) WriteJSON(reportingInterval time.Duration, writer i
 	}
 }
 
+// Writes all metrics once in Prometheus text exposition format. Lag, throughput and error metrics are tagged with the consumer name
+// and where applicable with topic and partition. Suitable to be called from an HTTP handler scraped by Prometheus.
+func (this *ConsumerMetrics) WritePrometheus(writer io.Writer) error {
+	return this.registry.WritePrometheus(writer)
+}
+
+// Returns the registry this consumer reports its metrics to.
+func (this *ConsumerMetrics) Registry() metrics.Registry {
+	return this.registry.Registry
+}
+
 func (this *ConsumerMetrics) close() {
 	for _, ch := range this.reportingStopChannels {
 		ch <- struct{}{}
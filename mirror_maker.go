@@ -19,7 +19,9 @@ import (
 	"fmt"
 	"github.com/elodina/siesta"
 	"github.com/elodina/siesta-producer"
+	metrics "github.com/rcrowley/go-metrics"
 	"hash/fnv"
+	"time"
 )
 
 // MirrorMakerConfig defines configuration options for MirrorMaker
@@ -45,15 +47,59 @@ type MirrorMakerConfig struct {
 	// Flag to preserve partition number. E.g. if message was read from partition 5 it'll be written to partition 5. Note that this can affect performance.
 	PreservePartitions bool
 
+	// Number of consecutive keyless messages written to the same destination partition before moving on to the next one, see StickyPartitioner.
+	// Zero keeps the partitioner of the producer config. Ignored if PreservePartitions is set.
+	StickyBatchSize int
+
 	// Flag to preserve message order. E.g. message sequence 1, 2, 3, 4, 5 will remain 1, 2, 3, 4, 5 in destination topic. Note that this can affect performance.
 	PreserveOrder bool
 
 	// Destination topic prefix. E.g. if message was read from topic "test" and prefix is "dc1_" it'll be written to topic "dc1_test".
 	TopicPrefix string
 
+	// Destination topic template evaluated against the fields of each JSON encoded message value, e.g. "events.{{.eventType}}.{{.appId}}".
+	// Characters not allowed in topic names are replaced with '_'. Messages that are not JSON or lack referenced fields are written to the TopicPrefix topic.
+	// NewMirrorMaker fails if the template can't be parsed.
+	TopicTemplate string
+
 	// Number of messages that are buffered between the consumer and producer.
 	ChannelSize int
 
+	// What to do with consumed messages when the buffer between the consumer and producer is full. Defaults to BlockWhenFull.
+	OverflowPolicy OverflowPolicy
+
+	// Maximum time to wait for a mirrored message to be acknowledged by the target cluster. Messages that are not acknowledged
+	// within this deadline are abandoned and counted as failed. Zero means producers don't wait for acknowledgements. Note that this can affect performance.
+	SendDeadline time.Duration
+
+	// Maximum mirroring throughput per source topic in messages per second. Topics not listed here are not throttled.
+	TopicRateLimits map[string]float64
+
+	// Maximum number of records each producer may have sent but not yet acknowledged. Producers block once the limit is reached.
+	// Combined with PreserveOrder a value of 1 guarantees strict ordering in the destination topic even when sends are retried. Zero means no limit.
+	MaxInFlightRequests int
+
+	// Maximum number of messages that are consumed from the source cluster but not yet acknowledged by the target cluster.
+	// Consumption is paused once the backlog reaches this threshold and resumes as producers catch up. Zero means no limit.
+	MaxBacklog int
+
+	// Client id sent with every request to both source and target clusters. Overrides client ids set in consumer and producer configs if set.
+	// Empty by default, i.e. consumer and producer configs use their own client ids.
+	ClientID string
+
+	// Topic to send records that failed to serialize with KeyEncoder or ValueEncoder to. Each such record is sent as a JSON encoded DeadLetter.
+	DeadLetterTopic string
+
+	// Producer used to send records to DeadLetterTopic. Should be configured with byte array key and value serializers.
+	DeadLetterProducer producer.Producer
+
+	// Registry MirrorMaker reports its metrics to, e.g. metrics.DefaultRegistry. MirrorMakers sharing a registry share their metrics.
+	// If not set every MirrorMaker instance reports to its own registry available via MirrorMaker.Metrics(). (optional)
+	MetricsRegistry metrics.Registry
+
+	// Interceptors applied to every mirrored record, in order.
+	ProducerInterceptors []ProducerInterceptor
+
 	// Message keys encoder for producer
 	KeyEncoder producer.Serializer
 
@@ -67,6 +113,23 @@ type MirrorMakerConfig struct {
 	ValueDecoder Decoder
 }
 
+// Defines what MirrorMaker does with consumed messages when producers can't keep up and the message buffer is full.
+type OverflowPolicy int
+
+const (
+	// Pauses consumption until there is room in the buffer.
+	BlockWhenFull OverflowPolicy = iota
+
+	// Drops the message that doesn't fit into the buffer.
+	DropNewestWhenFull
+
+	// Drops the oldest buffered message to make room for the new one.
+	DropOldestWhenFull
+
+	// Fails the message that doesn't fit into the buffer so that the consumer retries it up to MaxWorkerRetries times before skipping it.
+	RejectWhenFull
+)
+
 // Creates an empty MirrorMakerConfig.
 func NewMirrorMakerConfig() *MirrorMakerConfig {
 	return &MirrorMakerConfig{
@@ -82,18 +145,67 @@ func NewMirrorMakerConfig() *MirrorMakerConfig {
 type MirrorMaker struct {
 	config          *MirrorMakerConfig
 	metricReporter  *KafkaMetricReporter
+	registry        metrics.Registry
 	consumers       []*Consumer
 	producers       []producer.Producer
 	messageChannels []chan *Message
 	stopped         chan struct{}
+	failedSends     metrics.Counter
+	rateLimiters    map[string]*rateLimiter
+	throttledRates  map[string]metrics.Meter
+	droppedMessages metrics.Counter
+	backlogSlots    chan struct{}
+	backlog         metrics.Counter
+	topicTemplate   *TopicTemplate
 }
 
-// Creates a new MirrorMaker using given MirrorMakerConfig.
-func NewMirrorMaker(config *MirrorMakerConfig) *MirrorMaker {
-	return &MirrorMaker{
-		config:  config,
-		stopped: make(chan struct{}),
+// Creates a new MirrorMaker using given MirrorMakerConfig. Returns an error if the config is invalid.
+func NewMirrorMaker(config *MirrorMakerConfig) (*MirrorMaker, error) {
+	registry := config.MetricsRegistry
+	if registry == nil {
+		registry = metrics.NewRegistry()
+	}
+
+	rateLimiters := make(map[string]*rateLimiter)
+	throttledRates := make(map[string]metrics.Meter)
+	for topic, rate := range config.TopicRateLimits {
+		if rate <= 0 {
+			return nil, fmt.Errorf("Rate limit for topic %s must be positive, got %f", topic, rate)
+		}
+		rateLimiters[topic] = newRateLimiter(rate)
+		throttledRates[topic] = metrics.GetOrRegisterMeter(fmt.Sprintf("MirrorMakerThrottledRate-%s", topic), registry)
+	}
+
+	var backlogSlots chan struct{}
+	if config.MaxBacklog > 0 {
+		backlogSlots = make(chan struct{}, config.MaxBacklog)
+	}
+
+	var topicTemplate *TopicTemplate
+	if config.TopicTemplate != "" {
+		var err error
+		topicTemplate, err = NewTopicTemplate(config.TopicTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid topic template %s: %s", config.TopicTemplate, err)
+		}
 	}
+
+	return &MirrorMaker{
+		config:          config,
+		registry:        registry,
+		stopped:         make(chan struct{}),
+		failedSends:     metrics.GetOrRegisterCounter("MirrorMakerFailedSends", registry),
+		rateLimiters:    rateLimiters,
+		throttledRates:  throttledRates,
+		droppedMessages: metrics.GetOrRegisterCounter("MirrorMakerDroppedMessages", registry),
+		backlogSlots:    backlogSlots,
+		backlog:         metrics.GetOrRegisterCounter("MirrorMakerBacklog", registry),
+		topicTemplate:   topicTemplate,
+	}, nil
+}
+
+func (this *MirrorMaker) String() string {
+	return "mirror-maker"
 }
 
 // Starts the MirrorMaker. This method is blocking and should probably be run in a separate goroutine.
@@ -135,6 +247,9 @@ func (this *MirrorMaker) startConsumers() {
 		if err != nil {
 			panic(err)
 		}
+		if this.config.ClientID != "" {
+			config.Clientid = this.config.ClientID
+		}
 		config.KeyDecoder = this.config.KeyDecoder
 		config.ValueDecoder = this.config.ValueDecoder
 
@@ -151,20 +266,9 @@ func (this *MirrorMaker) startConsumers() {
 			return CommitOffsetAndContinue
 		}
 		if this.config.PreserveOrder {
-			numProducers := this.config.NumProducers
-			config.NumWorkers = 1 // NumWorkers must be 1 to guarantee order
-			config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
-				this.messageChannels[topicPartitionHash(msg)%numProducers] <- msg
-
-				return NewSuccessfulResult(id)
-			}
-		} else {
-			config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
-				this.messageChannels[0] <- msg
-
-				return NewSuccessfulResult(id)
-			}
+			config.OrderedWithinPartition = true
 		}
+		config.Strategy = this.mirrorStrategy()
 
 		consumer := NewConsumer(config)
 		this.consumers = append(this.consumers, consumer)
@@ -178,6 +282,99 @@ func (this *MirrorMaker) startConsumers() {
 	}
 }
 
+// Hands consumed messages over to producers. Blocks while the backlog is at MaxBacklog so that consumption slows down
+// to the rate the target cluster accepts messages at. Messages of topics listed in TopicRateLimits are throttled before
+// they are handed over so that a throttled topic never holds up producers of other topics.
+func (this *MirrorMaker) mirrorStrategy() WorkerStrategy {
+	if this.config.PreserveOrder {
+		numProducers := this.config.NumProducers
+		return func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+			this.throttle(msg)
+			return this.enqueue(this.messageChannels[topicPartitionHash(msg)%numProducers], msg, id)
+		}
+	}
+
+	return func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		this.throttle(msg)
+		return this.enqueue(this.messageChannels[0], msg, id)
+	}
+}
+
+func (this *MirrorMaker) throttle(msg *Message) {
+	if limiter, exists := this.rateLimiters[msg.Topic]; exists {
+		limiter.wait()
+		this.throttledRates[msg.Topic].Mark(1)
+	}
+}
+
+// Puts a given message into a given message channel handling a full channel according to OverflowPolicy.
+func (this *MirrorMaker) enqueue(channel chan *Message, msg *Message, id TaskId) WorkerResult {
+	this.acquireBacklog()
+	switch this.config.OverflowPolicy {
+	case DropNewestWhenFull:
+		select {
+		case channel <- msg:
+		default:
+			this.drop(msg)
+		}
+	case DropOldestWhenFull:
+		for {
+			select {
+			case channel <- msg:
+				return NewSuccessfulResult(id)
+			default:
+			}
+			select {
+			case oldest := <-channel:
+				this.drop(oldest)
+			default:
+			}
+		}
+	case RejectWhenFull:
+		select {
+		case channel <- msg:
+		default:
+			this.releaseBacklog()
+			return NewProcessingFailedResult(id)
+		}
+	default:
+		channel <- msg
+	}
+
+	return NewSuccessfulResult(id)
+}
+
+func (this *MirrorMaker) drop(msg *Message) {
+	Warnf(this, "Dropping message from topic %s, partition %d, offset %d as the message buffer is full", msg.Topic, msg.Partition, msg.Offset)
+	this.releaseBacklog()
+	this.droppedMessages.Inc(1)
+}
+
+func (this *MirrorMaker) acquireBacklog() {
+	if this.backlogSlots != nil {
+		this.backlogSlots <- struct{}{}
+	}
+	this.backlog.Inc(1)
+}
+
+func (this *MirrorMaker) releaseBacklog() {
+	this.backlog.Dec(1)
+	if this.backlogSlots != nil {
+		<-this.backlogSlots
+	}
+}
+
+// Returns the registry this MirrorMaker reports its metrics to.
+func (this *MirrorMaker) Metrics() metrics.Registry {
+	return this.registry
+}
+
+// Returns the number of messages that are consumed but not yet acknowledged by the target cluster. Acknowledgements are only
+// tracked if SendDeadline or MaxBacklog is set, otherwise messages are counted until they are sent.
+func (this *MirrorMaker) Backlog() metrics.Counter {
+	return this.backlog
+}
+
 func (this *MirrorMaker) initializeMessageChannels() {
 	if this.config.PreserveOrder {
 		for i := 0; i < this.config.NumProducers; i++ {
@@ -190,21 +387,37 @@ func (this *MirrorMaker) initializeMessageChannels() {
 
 func (this *MirrorMaker) startProducers() {
 	for i := 0; i < this.config.NumProducers; i++ {
-		conf, err := producer.ProducerConfigFromFile(this.config.ProducerConfig)
+		conf, connectorConfig, err := this.producerConfigs()
 		if err != nil {
 			panic(err)
 		}
-		if this.config.PreservePartitions {
-			conf.Partitioner = producer.NewManualPartitioner()
-		}
-		connectorConfig := siesta.NewConnectorConfig()
-		connectorConfig.BrokerList = conf.BrokerList
 		connector, err := siesta.NewDefaultConnector(connectorConfig)
 		if err != nil {
 			panic(err)
 		}
 
-		producer := producer.NewKafkaProducer(conf, this.config.KeyEncoder, this.config.ValueEncoder, connector)
+		keyEncoder, valueEncoder := this.config.KeyEncoder, this.config.ValueEncoder
+		if this.config.DeadLetterTopic != "" {
+			// records are serialized by the dead lettering producer
+			keyEncoder, valueEncoder = producer.ByteSerializer, producer.ByteSerializer
+		}
+		var producer producer.Producer = producer.NewKafkaProducer(conf, keyEncoder, valueEncoder, connector)
+		if this.config.DeadLetterTopic != "" {
+			if this.config.DeadLetterProducer == nil {
+				panic("Dead letter producer is not set")
+			}
+			producer = NewDeadLetteringProducer(producer, this.config.KeyEncoder, this.config.ValueEncoder, this.config.DeadLetterTopic, this.config.DeadLetterProducer)
+		}
+		if this.config.MaxInFlightRequests > 0 {
+			limiting := NewInFlightLimitingProducer(producer, this.config.MaxInFlightRequests)
+			if err := this.registry.Register(fmt.Sprintf("MirrorMakerInFlightRequests-%d", i), limiting.InFlight()); err != nil {
+				Warnf(this, "Failed to register in-flight requests of producer %d: %s", i, err)
+			}
+			producer = limiting
+		}
+		if len(this.config.ProducerInterceptors) > 0 {
+			producer = NewInterceptingProducer(producer, this.config.ProducerInterceptors...)
+		}
 		this.producers = append(this.producers, producer)
 		if this.config.PreserveOrder {
 			go this.produceRoutine(producer, i)
@@ -214,14 +427,81 @@ func (this *MirrorMaker) startProducers() {
 	}
 }
 
+func (this *MirrorMaker) producerConfigs() (*producer.ProducerConfig, *siesta.ConnectorConfig, error) {
+	conf, err := producer.ProducerConfigFromFile(this.config.ProducerConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if this.config.PreservePartitions {
+		conf.Partitioner = producer.NewManualPartitioner()
+	} else if this.config.StickyBatchSize > 0 {
+		conf.Partitioner = NewStickyPartitioner(this.config.StickyBatchSize)
+	}
+	if this.config.ClientID != "" {
+		conf.ClientID = this.config.ClientID
+	}
+	connectorConfig := siesta.NewConnectorConfig()
+	connectorConfig.BrokerList = conf.BrokerList
+	connectorConfig.ClientID = conf.ClientID
+
+	return conf, connectorConfig, nil
+}
+
 func (this *MirrorMaker) produceRoutine(p producer.Producer, channelIndex int) {
+	var acks chan (<-chan *producer.RecordMetadata)
+	if this.config.SendDeadline <= 0 && this.config.MaxBacklog > 0 {
+		acks = make(chan (<-chan *producer.RecordMetadata), this.config.MaxBacklog)
+		defer close(acks)
+		go this.ackRoutine(acks)
+	}
+
 	for msg := range this.messageChannels[channelIndex] {
-		p.Send(&producer.ProducerRecord{
-			Topic:     this.config.TopicPrefix + msg.Topic,
+		metadata := p.Send(&producer.ProducerRecord{
+			Topic:     this.destinationTopic(msg),
 			Partition: msg.Partition,
 			Key:       msg.Key,
 			Value:     msg.DecodedValue,
 		})
+		if this.config.SendDeadline > 0 {
+			this.awaitAck(msg, metadata)
+			this.releaseBacklog()
+		} else if acks != nil {
+			acks <- metadata
+		} else {
+			this.releaseBacklog()
+		}
+	}
+}
+
+// Releases the backlog of a producer as its records are acknowledged. Acknowledgements are awaited in the order records were sent.
+func (this *MirrorMaker) ackRoutine(acks <-chan (<-chan *producer.RecordMetadata)) {
+	for metadata := range acks {
+		<-metadata
+		this.releaseBacklog()
+	}
+}
+
+func (this *MirrorMaker) destinationTopic(msg *Message) string {
+	topic := this.config.TopicPrefix + msg.Topic
+	if this.topicTemplate != nil {
+		return this.topicTemplate.Resolve(msg.Value, topic)
+	}
+
+	return topic
+}
+
+func (this *MirrorMaker) awaitAck(msg *Message, metadata <-chan *producer.RecordMetadata) {
+	timeout := time.NewTimer(this.config.SendDeadline)
+	defer timeout.Stop()
+	select {
+	case result := <-metadata:
+		if result.Error != nil {
+			Warnf(this, "Failed to mirror message from topic %s, partition %d, offset %d: %s", msg.Topic, msg.Partition, msg.Offset, result.Error)
+			this.failedSends.Inc(1)
+		}
+	case <-timeout.C:
+		Warnf(this, "Abandoning message from topic %s, partition %d, offset %d as it was not acknowledged within %s", msg.Topic, msg.Partition, msg.Offset, this.config.SendDeadline)
+		this.failedSends.Inc(1)
 	}
 }
 
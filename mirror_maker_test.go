@@ -18,9 +18,12 @@ package go_kafka_client
 import (
 	"fmt"
 	"github.com/Shopify/sarama"
+	"github.com/elodina/siesta-producer"
+	metrics "github.com/rcrowley/go-metrics"
 	"io/ioutil"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -51,7 +54,7 @@ func TestMirrorMakerWorks(t *testing.T) {
 	config.ProducerConfig = producerConfigLocation
 	config.TopicPrefix = "mirror_"
 	config.Whitelist = fmt.Sprintf("^%s$", topic)
-	mirrorMaker := NewMirrorMaker(config)
+	mirrorMaker := newTestMirrorMaker(t, config)
 	go mirrorMaker.Start()
 
 	messages := make([]string, 0)
@@ -100,7 +103,7 @@ func TestMirrorMakerPreservesPartitions(t *testing.T) {
 	config.ProducerConfig = producerConfigLocation
 	config.TopicPrefix = prefix
 	config.Whitelist = fmt.Sprintf("^%s$", topic)
-	mirrorMaker := NewMirrorMaker(config)
+	mirrorMaker := newTestMirrorMaker(t, config)
 	go mirrorMaker.Start()
 
 	produceN(t, consumeMessages, topic, localBroker)
@@ -177,7 +180,7 @@ func TestMirrorMakerPreservesOrder(t *testing.T) {
 	config.ProducerConfig = producerConfigLocation
 	config.TopicPrefix = prefix
 	config.Whitelist = fmt.Sprintf("^%s$", topic)
-	mirrorMaker := NewMirrorMaker(config)
+	mirrorMaker := newTestMirrorMaker(t, config)
 	go mirrorMaker.Start()
 
 	produceN(t, consumeMessages, topic, localBroker)
@@ -233,6 +236,184 @@ func TestMirrorMakerPreservesOrder(t *testing.T) {
 	mirrorMaker.Stop()
 }
 
+func TestMirrorMakerSendDeadline(t *testing.T) {
+	config := NewMirrorMakerConfig()
+	config.ChannelSize = 10
+	config.SendDeadline = 500 * time.Millisecond
+	mirrorMaker := newTestMirrorMaker(t, config)
+	mirrorMaker.initializeMessageChannels()
+
+	failedBefore := mirrorMaker.failedSends.Count()
+	p := newMockProducer(false)
+	routineFinished := make(chan struct{})
+	go func() {
+		mirrorMaker.produceRoutine(p, 0)
+		close(routineFinished)
+	}()
+
+	start := time.Now()
+	mirrorMaker.messageChannels[0] <- &Message{Topic: "never-acked", Offset: 0}
+	mirrorMaker.messageChannels[0] <- &Message{Topic: "never-acked", Offset: 1}
+	close(mirrorMaker.messageChannels[0])
+
+	select {
+	case <-routineFinished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Produce routine should abandon messages that are not acknowledged within the deadline")
+	}
+	if elapsed := time.Since(start); elapsed < 2*config.SendDeadline {
+		t.Errorf("Produce routine should wait for acknowledgements up to the deadline, waited %s", elapsed)
+	}
+	assert(t, len(p.records), 2)
+	assert(t, mirrorMaker.failedSends.Count()-failedBefore, int64(2))
+}
+
+func TestMirrorMakerTopicRateLimits(t *testing.T) {
+	config := NewMirrorMakerConfig()
+	config.ChannelSize = 100
+	config.TopicRateLimits = map[string]float64{"hot-topic": 20}
+	mirrorMaker := newTestMirrorMaker(t, config)
+	mirrorMaker.initializeMessageChannels()
+
+	p := newMockProducer(true)
+	go mirrorMaker.produceRoutine(p, 0)
+
+	strategy := mirrorMaker.mirrorStrategy()
+	sent := func(topic string) int {
+		count := 0
+		inLock(&p.lock, func() {
+			for _, record := range p.records {
+				if record.Topic == topic {
+					count++
+				}
+			}
+		})
+		return count
+	}
+	mirrorAll := func(topic string, numMessages int) <-chan time.Duration {
+		elapsed := make(chan time.Duration, 1)
+		go func() {
+			start := time.Now()
+			for i := 0; i < numMessages; i++ {
+				strategy(nil, &Message{Topic: topic, Offset: int64(i)}, TaskId{TopicAndPartition{topic, 0}, int64(i)})
+			}
+			for sent(topic) < numMessages {
+				time.Sleep(10 * time.Millisecond)
+			}
+			elapsed <- time.Since(start)
+		}()
+		return elapsed
+	}
+
+	//mirror both topics at the same time so that the hot topic is being throttled while the cold one is mirrored
+	hot := mirrorAll("hot-topic", 50)
+	time.Sleep(100 * time.Millisecond)
+	cold := mirrorAll("cold-topic", 50)
+
+	if elapsed := <-cold; elapsed > 500*time.Millisecond {
+		t.Errorf("Unthrottled topic should not be slowed down by a throttled one, mirroring took %s", elapsed)
+	}
+	assert(t, sent("hot-topic") < 50, true)
+	if elapsed := <-hot; elapsed < 2*time.Second {
+		t.Errorf("Mirroring 50 messages at 20 messages/sec should take at least 2s, took %s", elapsed)
+	}
+	assert(t, mirrorMaker.throttledRates["hot-topic"].Count(), int64(50))
+	close(mirrorMaker.messageChannels[0])
+
+	config.TopicRateLimits = map[string]float64{"hot-topic": 0}
+	_, err := NewMirrorMaker(config)
+	assertNot(t, err, nil)
+}
+
+func TestMirrorMakerOverflowPolicy(t *testing.T) {
+	enqueue := func(policy OverflowPolicy) (*MirrorMaker, []WorkerResult) {
+		config := NewMirrorMakerConfig()
+		config.ChannelSize = 2
+		config.OverflowPolicy = policy
+		mirrorMaker := newTestMirrorMaker(t, config)
+		mirrorMaker.initializeMessageChannels()
+
+		strategy := mirrorMaker.mirrorStrategy()
+		results := make([]WorkerResult, 0)
+		for i := 0; i < 3; i++ {
+			results = append(results, strategy(nil, &Message{Topic: "test", Offset: int64(i)}, TaskId{TopicAndPartition{"test", 0}, int64(i)}))
+		}
+		close(mirrorMaker.messageChannels[0])
+		return mirrorMaker, results
+	}
+	buffered := func(mirrorMaker *MirrorMaker) []int64 {
+		offsets := make([]int64, 0)
+		for msg := range mirrorMaker.messageChannels[0] {
+			offsets = append(offsets, msg.Offset)
+		}
+		return offsets
+	}
+
+	mirrorMaker, results := enqueue(DropNewestWhenFull)
+	assert(t, results[2].Success(), true)
+	assert(t, buffered(mirrorMaker), []int64{0, 1})
+	assert(t, mirrorMaker.droppedMessages.Count(), int64(1))
+	assert(t, mirrorMaker.Backlog().Count(), int64(2))
+
+	mirrorMaker, results = enqueue(DropOldestWhenFull)
+	assert(t, results[2].Success(), true)
+	assert(t, buffered(mirrorMaker), []int64{1, 2})
+	assert(t, mirrorMaker.droppedMessages.Count(), int64(1))
+	assert(t, mirrorMaker.Backlog().Count(), int64(2))
+
+	mirrorMaker, results = enqueue(RejectWhenFull)
+	assert(t, results[1].Success(), true)
+	assert(t, results[2].Success(), false)
+	assert(t, buffered(mirrorMaker), []int64{0, 1})
+	assert(t, mirrorMaker.droppedMessages.Count(), int64(0))
+	assert(t, mirrorMaker.Backlog().Count(), int64(2))
+
+	//the default policy blocks until there is room in the buffer
+	config := NewMirrorMakerConfig()
+	config.ChannelSize = 1
+	mirrorMaker = newTestMirrorMaker(t, config)
+	mirrorMaker.initializeMessageChannels()
+	strategy := mirrorMaker.mirrorStrategy()
+	strategy(nil, &Message{Topic: "test", Offset: 0}, TaskId{TopicAndPartition{"test", 0}, 0})
+	enqueued := make(chan struct{})
+	go func() {
+		strategy(nil, &Message{Topic: "test", Offset: 1}, TaskId{TopicAndPartition{"test", 0}, 1})
+		close(enqueued)
+	}()
+	select {
+	case <-enqueued:
+		t.Fatal("Blocking overflow policy should wait for room in the buffer")
+	case <-time.After(100 * time.Millisecond):
+	}
+	<-mirrorMaker.messageChannels[0]
+	select {
+	case <-enqueued:
+	case <-time.After(time.Second):
+		t.Fatal("Blocking overflow policy should enqueue once there is room in the buffer")
+	}
+}
+
+func TestMirrorMakerClientID(t *testing.T) {
+	config := NewMirrorMakerConfig()
+	assert(t, config.ClientID, "")
+
+	//client id of the producer config is kept unless set explicitly
+	config.ProducerConfig = createProducerConfig(t, 0)
+	contents, err := ioutil.ReadFile(config.ProducerConfig)
+	assert(t, err, nil)
+	assert(t, ioutil.WriteFile(config.ProducerConfig, append(contents, []byte("client.id=from-file\n")...), 0700), nil)
+	conf, connectorConfig, err := newTestMirrorMaker(t, config).producerConfigs()
+	assert(t, err, nil)
+	assert(t, conf.ClientID, "from-file")
+	assert(t, connectorConfig.ClientID, "from-file")
+
+	config.ClientID = "mirror-client"
+	conf, connectorConfig, err = newTestMirrorMaker(t, config).producerConfigs()
+	assert(t, err, nil)
+	assert(t, conf.ClientID, "mirror-client")
+	assert(t, connectorConfig.ClientID, "mirror-client")
+}
+
 func createConsumerConfig(t *testing.T, id int) string {
 	tmpPath, err := ioutil.TempDir("", "go_kafka_client")
 	if err != nil {
@@ -276,3 +457,120 @@ func createProducerConfig(t *testing.T, id int) string {
 
 	return configPath
 }
+
+func TestMirrorMakerMaxBacklog(t *testing.T) {
+	config := NewMirrorMakerConfig()
+	config.ChannelSize = 100
+	config.MaxBacklog = 3
+	mirrorMaker := newTestMirrorMaker(t, config)
+	mirrorMaker.initializeMessageChannels()
+
+	slow := &manualAckProducer{mockProducer: newMockProducer(false), acks: make(chan chan *producer.RecordMetadata, 100)}
+	go mirrorMaker.produceRoutine(slow, 0)
+
+	var consumed int64
+	strategy := mirrorMaker.mirrorStrategy()
+	go func() {
+		for i := 0; i < 10; i++ {
+			strategy(nil, &Message{Topic: "test", Offset: int64(i)}, TaskId{TopicAndPartition{"test", 0}, int64(i)})
+			atomic.AddInt64(&consumed, 1)
+		}
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	assert(t, atomic.LoadInt64(&consumed), int64(3))
+	assert(t, mirrorMaker.Backlog().Count(), int64(3))
+
+	(<-slow.acks) <- &producer.RecordMetadata{}
+	time.Sleep(200 * time.Millisecond)
+	assert(t, atomic.LoadInt64(&consumed), int64(4))
+	assert(t, mirrorMaker.Backlog().Count(), int64(3))
+
+	for i := 1; i < 10; i++ {
+		(<-slow.acks) <- &producer.RecordMetadata{}
+	}
+	time.Sleep(200 * time.Millisecond)
+	assert(t, atomic.LoadInt64(&consumed), int64(10))
+	assert(t, mirrorMaker.Backlog().Count(), int64(0))
+	close(mirrorMaker.messageChannels[0])
+
+	//acknowledgements are not awaited without a backlog limit
+	config.MaxBacklog = 0
+	mirrorMaker = newTestMirrorMaker(t, config)
+	mirrorMaker.initializeMessageChannels()
+	neverAcked := newMockProducer(false)
+	go mirrorMaker.produceRoutine(neverAcked, 0)
+	strategy = mirrorMaker.mirrorStrategy()
+	for i := 0; i < 10; i++ {
+		strategy(nil, &Message{Topic: "test", Offset: int64(i)}, TaskId{TopicAndPartition{"test", 0}, int64(i)})
+	}
+	time.Sleep(200 * time.Millisecond)
+	inLock(&neverAcked.lock, func() {
+		assert(t, len(neverAcked.records), 10)
+	})
+	assert(t, mirrorMaker.Backlog().Count(), int64(0))
+	close(mirrorMaker.messageChannels[0])
+}
+
+func TestMirrorMakerMetrics(t *testing.T) {
+	config := NewMirrorMakerConfig()
+	first := newTestMirrorMaker(t, config)
+	second := newTestMirrorMaker(t, config)
+	first.failedSends.Inc(1)
+	assert(t, first.Metrics().Get("MirrorMakerFailedSends"), first.failedSends)
+	assert(t, second.Metrics().Get("MirrorMakerFailedSends"), second.failedSends)
+	assert(t, second.failedSends.Count(), int64(0))
+
+	//mirror makers reporting to the same registry share their metrics
+	config.MetricsRegistry = metrics.NewRegistry()
+	first = newTestMirrorMaker(t, config)
+	second = newTestMirrorMaker(t, config)
+	first.droppedMessages.Inc(1)
+	assert(t, config.MetricsRegistry.Get("MirrorMakerDroppedMessages"), first.droppedMessages)
+	assert(t, second.droppedMessages.Count(), int64(1))
+}
+
+func newTestMirrorMaker(t *testing.T, config *MirrorMakerConfig) *MirrorMaker {
+	mirrorMaker, err := NewMirrorMaker(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mirrorMaker
+}
+
+//a producer that records sent messages and acknowledges them only if ack is true
+type mockProducer struct {
+	ack     bool
+	records []*producer.ProducerRecord
+	lock    sync.Mutex
+}
+
+func newMockProducer(ack bool) *mockProducer {
+	return &mockProducer{
+		ack:     ack,
+		records: make([]*producer.ProducerRecord, 0),
+	}
+}
+
+func (this *mockProducer) Send(record *producer.ProducerRecord) <-chan *producer.RecordMetadata {
+	metadata := make(chan *producer.RecordMetadata, 1)
+	inLock(&this.lock, func() {
+		this.records = append(this.records, record)
+	})
+	if this.ack {
+		metadata <- &producer.RecordMetadata{Record: record, Topic: record.Topic, Partition: record.Partition}
+	}
+	return metadata
+}
+
+func (this *mockProducer) Flush() {}
+
+func (this *mockProducer) PartitionsFor(topic string) []producer.PartitionInfo {
+	return nil
+}
+
+func (this *mockProducer) Metrics() map[string]producer.Metric {
+	return nil
+}
+
+func (this *mockProducer) Close(timeout time.Duration) {}
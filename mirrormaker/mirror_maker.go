@@ -43,11 +43,17 @@ var producerConfig = flag.String("producer.config", "", "Path to producer config
 var numProducers = flag.Int("num.producers", 1, "Number of producers.")
 var numStreams = flag.Int("num.streams", 1, "Number of consumption streams.")
 var preservePartitions = flag.Bool("preserve.partitions", false, "preserve partition number. E.g. if message was read from partition 5 it'll be written to partition 5.")
+var stickyBatchSize = flag.Int("sticky.batch.size", 0, "Number of consecutive keyless messages written to the same destination partition before moving on to the next one. 0 keeps the producer partitioner.")
 var preserveOrder = flag.Bool("preserve.order", false, "E.g. message sequence 1, 2, 3, 4, 5 will remain 1, 2, 3, 4, 5 in destination topic.")
 var prefix = flag.String("prefix", "", "Destination topic prefix.")
+var topicTemplate = flag.String("topic.template", "", "Destination topic template evaluated against JSON message values, e.g. events.{{.eventType}}. Falls back to the prefixed source topic.")
 var queueSize = flag.Int("queue.size", 10000, "Number of messages that are buffered between the consumer and producer.")
+var maxBacklog = flag.Int("max.backlog", 0, "Maximum number of messages that are consumed but not yet acknowledged by the target cluster. Consumption is paused once reached. 0 means no limit.")
+var clientId = flag.String("client.id", "", "Client id sent with every request to both source and target clusters. Defaults to client ids of consumer and producer configs.")
+var overflowPolicy = flag.String("overflow.policy", "block", "What to do with consumed messages when the queue is full: block, drop.newest, drop.oldest or reject.")
 var maxProcs = flag.Int("max.procs", runtime.NumCPU(), "Maximum number of CPUs that can be executing simultaneously.")
 var schemaRegistryUrl = flag.String("schema.registry.url", "", "Avro schema registry URL for message encoding/decoding")
+var avroConfluentFraming = flag.Bool("avro.confluent.framing", true, "Prefix mirrored Avro messages with the Confluent magic byte and schema id. Set to false to write raw Avro binary.")
 
 func parseAndValidateArgs() *kafka.MirrorMakerConfig {
 	flag.Var(&consumerConfig, "consumer.config", "Path to consumer configuration file.")
@@ -71,20 +77,46 @@ func parseAndValidateArgs() *kafka.MirrorMakerConfig {
 		os.Exit(1)
 	}
 
+	if *maxBacklog < 0 {
+		fmt.Println("Max backlog should be equal or greater than 0")
+		os.Exit(1)
+	}
+
+	overflowPolicies := map[string]kafka.OverflowPolicy{
+		"block":       kafka.BlockWhenFull,
+		"drop.newest": kafka.DropNewestWhenFull,
+		"drop.oldest": kafka.DropOldestWhenFull,
+		"reject":      kafka.RejectWhenFull,
+	}
+	policy, exists := overflowPolicies[*overflowPolicy]
+	if !exists {
+		fmt.Println("Overflow policy should be one of block, drop.newest, drop.oldest or reject")
+		os.Exit(1)
+	}
+
 	config := kafka.NewMirrorMakerConfig()
 	config.Blacklist = *blacklist
 	config.Whitelist = *whitelist
 	config.ChannelSize = *queueSize
+	config.MaxBacklog = *maxBacklog
+	config.OverflowPolicy = policy
 	config.ConsumerConfigs = []string(consumerConfig)
 	config.NumProducers = *numProducers
 	config.NumStreams = *numStreams
 	config.PreservePartitions = *preservePartitions
+	config.StickyBatchSize = *stickyBatchSize
 	config.PreserveOrder = *preserveOrder
 	config.ProducerConfig = *producerConfig
 	config.TopicPrefix = *prefix
+	config.TopicTemplate = *topicTemplate
+	config.ClientID = *clientId
 	if *schemaRegistryUrl != "" {
-		config.KeyEncoder = avro.NewKafkaAvroEncoder(*schemaRegistryUrl).Encode
-		config.ValueEncoder = avro.NewKafkaAvroEncoder(*schemaRegistryUrl).Encode
+		keyEncoder := kafka.NewAvroEncoder(*schemaRegistryUrl)
+		keyEncoder.ConfluentFraming = *avroConfluentFraming
+		valueEncoder := kafka.NewAvroEncoder(*schemaRegistryUrl)
+		valueEncoder.ConfluentFraming = *avroConfluentFraming
+		config.KeyEncoder = keyEncoder.Encode
+		config.ValueEncoder = valueEncoder.Encode
 		config.KeyDecoder = avro.NewKafkaAvroDecoder(*schemaRegistryUrl)
 		config.ValueDecoder = avro.NewKafkaAvroDecoder(*schemaRegistryUrl)
 	}
@@ -94,7 +126,11 @@ func parseAndValidateArgs() *kafka.MirrorMakerConfig {
 
 func main() {
 	config := parseAndValidateArgs()
-	mirrorMaker := kafka.NewMirrorMaker(config)
+	mirrorMaker, err := kafka.NewMirrorMaker(config)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	go mirrorMaker.Start()
 
 	ctrlc := make(chan os.Signal, 1)
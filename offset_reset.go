@@ -0,0 +1,142 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"fmt"
+	"time"
+)
+
+type offsetSpecKind int
+
+const (
+	earliestOffsetSpec offsetSpecKind = iota
+	latestOffsetSpec
+	timestampOffsetSpec
+	explicitOffsetSpec
+)
+
+// OffsetSpec defines where ResetGroupOffsets moves a consumer group to. Create it with ResetToEarliest, ResetToLatest,
+// ResetToTimestamp or ResetToOffset.
+type OffsetSpec struct {
+	kind      offsetSpecKind
+	timestamp time.Time
+	offset    int64
+}
+
+// Resets a group to the earliest available offset of each partition.
+func ResetToEarliest() OffsetSpec {
+	return OffsetSpec{kind: earliestOffsetSpec}
+}
+
+// Resets a group to the log end of each partition, so only messages produced after the reset are consumed.
+func ResetToLatest() OffsetSpec {
+	return OffsetSpec{kind: latestOffsetSpec}
+}
+
+// Resets a group to the first offset of the latest log segment written before a given time. Kafka 0.8 looks offsets up
+// by log segment, so some messages older than the timestamp may be consumed again.
+func ResetToTimestamp(timestamp time.Time) OffsetSpec {
+	return OffsetSpec{kind: timestampOffsetSpec, timestamp: timestamp}
+}
+
+// Resets a group so that consumption of each partition resumes at a given offset, which must be within the available offset range.
+func ResetToOffset(offset int64) OffsetSpec {
+	return OffsetSpec{kind: explicitOffsetSpec, offset: offset}
+}
+
+func (this OffsetSpec) String() string {
+	switch this.kind {
+	case earliestOffsetSpec:
+		return "earliest"
+	case latestOffsetSpec:
+		return "latest"
+	case timestampOffsetSpec:
+		return fmt.Sprintf("timestamp %s", this.timestamp)
+	default:
+		return fmt.Sprintf("offset %d", this.offset)
+	}
+}
+
+// A LowLevelClient that is able to look up offsets by time.
+type timestampOffsetClient interface {
+	GetOffsetBefore(topic string, partition int32, timestamp time.Time) (int64, error)
+}
+
+func resetGroupOffsets(config *ConsumerConfig, client LowLevelClient, storage OffsetStorage, group string, topic string,
+	to OffsetSpec) (map[TopicAndPartition]int64, error) {
+	consumers, err := config.Coordinator.GetConsumersInGroup(group)
+	if err != nil {
+		return nil, err
+	}
+	if len(consumers) > 0 {
+		return nil, fmt.Errorf("Consumer group %s is active with consumers %v, stop it before resetting offsets", group, consumers)
+	}
+
+	topicPartitions, err := config.Coordinator.GetPartitionsForTopics([]string{topic})
+	if err != nil {
+		return nil, err
+	}
+	if len(topicPartitions[topic]) == 0 {
+		return nil, fmt.Errorf("Topic %s does not exist", topic)
+	}
+
+	offsets := make(map[TopicAndPartition]int64)
+	for _, partition := range topicPartitions[topic] {
+		offset, err := resolveOffset(client, topic, partition, to)
+		if err != nil {
+			return nil, err
+		}
+		offsets[TopicAndPartition{topic, partition}] = offset
+	}
+	if to.kind == explicitOffsetSpec {
+		if err := validateSeekOffsets(client, offsets); err != nil {
+			return nil, err
+		}
+	}
+
+	for topicPartition, offset := range offsets {
+		// committed offsets are the last handled ones, consumption resumes right after them. Partitions reset to offset 0
+		// are committed as InvalidOffset, so consumers start them according to ConsumerConfig.ResetUncommittedToSmallest
+		if offset == 0 && !config.ResetUncommittedToSmallest && config.AutoOffsetReset != SmallestOffset {
+			Warnf(config.Clientid, "Consumers of group %s start %s at the %s offset instead of 0 unless ResetUncommittedToSmallest is set",
+				group, &topicPartition, config.AutoOffsetReset)
+		}
+		if err := storage.CommitOffset(group, topicPartition.Topic, topicPartition.Partition, offset-1); err != nil {
+			return nil, err
+		}
+		Infof(config.Clientid, "Reset offset of group %s for %s to %d (%s)", group, &topicPartition, offset, to)
+	}
+
+	return offsets, nil
+}
+
+func resolveOffset(client LowLevelClient, topic string, partition int32, to OffsetSpec) (int64, error) {
+	switch to.kind {
+	case earliestOffsetSpec:
+		return client.GetAvailableOffset(topic, partition, SmallestOffset)
+	case latestOffsetSpec:
+		return client.GetAvailableOffset(topic, partition, LargestOffset)
+	case timestampOffsetSpec:
+		timestampClient, ok := client.(timestampOffsetClient)
+		if !ok {
+			return InvalidOffset, fmt.Errorf("%T can't look up offsets by timestamp", client)
+		}
+		return timestampClient.GetOffsetBefore(topic, partition, to.timestamp)
+	default:
+		return to.offset, nil
+	}
+}
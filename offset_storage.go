@@ -0,0 +1,78 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import "sync"
+
+// InMemoryOffsetStorage is an OffsetStorage that keeps offsets in memory. Offsets are visible to every consumer sharing
+// the same instance and are lost once the process exits, so it is mainly useful for tests and static partition setups.
+type InMemoryOffsetStorage struct {
+	offsets     map[string]map[TopicAndPartition]int64
+	offsetsLock sync.Mutex
+}
+
+// NewInMemoryOffsetStorage creates an empty InMemoryOffsetStorage.
+func NewInMemoryOffsetStorage() *InMemoryOffsetStorage {
+	return &InMemoryOffsetStorage{
+		offsets: make(map[string]map[TopicAndPartition]int64),
+	}
+}
+
+// Gets the offset for a given group, topic and partition. Returns InvalidOffset if nothing was committed yet.
+func (this *InMemoryOffsetStorage) GetOffset(group string, topic string, partition int32) (int64, error) {
+	offset := InvalidOffset
+	inLock(&this.offsetsLock, func() {
+		if committed, exists := this.offsets[group][TopicAndPartition{Topic: topic, Partition: partition}]; exists {
+			offset = committed
+		}
+	})
+	return offset, nil
+}
+
+// Commits the given offset for a given group, topic and partition.
+func (this *InMemoryOffsetStorage) CommitOffset(group string, topic string, partition int32, offset int64) error {
+	inLock(&this.offsetsLock, func() {
+		groupOffsets, exists := this.offsets[group]
+		if !exists {
+			groupOffsets = make(map[TopicAndPartition]int64)
+			this.offsets[group] = groupOffsets
+		}
+		groupOffsets[TopicAndPartition{Topic: topic, Partition: partition}] = offset
+	})
+	return nil
+}
+
+// Removes the committed offset for a given group, topic and partition so that the next GetOffset returns InvalidOffset.
+func (this *InMemoryOffsetStorage) DeleteOffset(group string, topic string, partition int32) {
+	inLock(&this.offsetsLock, func() {
+		delete(this.offsets[group], TopicAndPartition{Topic: topic, Partition: partition})
+	})
+}
+
+// externalOffsetStorage is an OffsetStorage that delegates to ConsumerConfig.ExternalOffsetCommitter and ExternalOffsetFetcher.
+// The external storage is owned by a single consumer group, so the group is not passed on.
+type externalOffsetStorage struct {
+	commit ExternalOffsetCommitter
+	fetch  ExternalOffsetFetcher
+}
+
+func (this *externalOffsetStorage) GetOffset(group string, topic string, partition int32) (int64, error) {
+	return this.fetch(TopicAndPartition{Topic: topic, Partition: partition})
+}
+
+func (this *externalOffsetStorage) CommitOffset(group string, topic string, partition int32, offset int64) error {
+	return this.commit(map[TopicAndPartition]int64{TopicAndPartition{Topic: topic, Partition: partition}: offset})
+}
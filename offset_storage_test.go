@@ -0,0 +1,126 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInMemoryOffsetStorage(t *testing.T) {
+	storage := NewInMemoryOffsetStorage()
+
+	offset, err := storage.GetOffset("group", "topic", 0)
+	assert(t, err, nil)
+	assert(t, offset, InvalidOffset)
+
+	assert(t, storage.CommitOffset("group", "topic", 0, 10), nil)
+	assert(t, storage.CommitOffset("group", "topic", 0, 15), nil)
+	offset, _ = storage.GetOffset("group", "topic", 0)
+	assert(t, offset, int64(15))
+
+	offset, _ = storage.GetOffset("group", "topic", 1)
+	assert(t, offset, InvalidOffset)
+
+	// other groups should not see the commit
+	offset, _ = storage.GetOffset("other-group", "topic", 0)
+	assert(t, offset, InvalidOffset)
+
+	storage.DeleteOffset("group", "topic", 0)
+	offset, _ = storage.GetOffset("group", "topic", 0)
+	assert(t, offset, InvalidOffset)
+}
+
+func TestInMemoryOffsetStorageSharedByGroupMembers(t *testing.T) {
+	storage := NewInMemoryOffsetStorage()
+	topicPartition := TopicAndPartition{"fakeTopic", int32(3)}
+
+	first := DefaultConsumerConfig()
+	first.Groupid = "shared-group"
+	first.Strategy = goodStrategy
+	first.OffsetStorage = storage
+
+	metrics := newConsumerMetrics("test-WM-shared-offsets", "", nil)
+	manager := NewWorkerManager("test-WM-shared-offsets", first, topicPartition, metrics, make(chan bool))
+	go manager.Start()
+	manager.inputChannel <- []*Message{&Message{Offset: 40}, &Message{Offset: 41}, &Message{Offset: 42}}
+	time.Sleep(1 * time.Second)
+	<-manager.Stop()
+
+	second := DefaultConsumerConfig()
+	second.Groupid = "shared-group"
+	second.OffsetStorage = storage
+
+	offset, err := fetchGroupOffset(second, second.OffsetStorage, second.Groupid, topicPartition.Topic, topicPartition.Partition)
+	assert(t, err, nil)
+	assert(t, offset, int64(42))
+}
+
+func TestExternalOffsetStorage(t *testing.T) {
+	var lock sync.Mutex
+	external := make(map[TopicAndPartition]int64)
+	commits := 0
+	config := DefaultConsumerConfig()
+	config.Strategy = goodStrategy
+	config.WorkerFailureCallback = func(_ *WorkerManager) FailedDecision { return CommitOffsetAndContinue }
+	config.WorkerFailedAttemptCallback = func(_ *Task, _ WorkerResult) FailedDecision { return CommitOffsetAndContinue }
+	config.ExternalOffsetCommitter = func(offsets map[TopicAndPartition]int64) error {
+		inLock(&lock, func() {
+			for topicPartition, offset := range offsets {
+				external[topicPartition] = offset
+			}
+			commits++
+		})
+		return nil
+	}
+	assertNot(t, config.Validate(), nil)
+
+	config.ExternalOffsetFetcher = func(topicPartition TopicAndPartition) (int64, error) {
+		offset := InvalidOffset
+		inLock(&lock, func() {
+			if committed, exists := external[topicPartition]; exists {
+				offset = committed
+			}
+		})
+		return offset, nil
+	}
+	assert(t, config.Validate(), nil)
+
+	topicPartition := TopicAndPartition{"fakeTopic", int32(1)}
+	manager := NewWorkerManager("test-WM-external-offsets", config, topicPartition, newConsumerMetrics("test-WM-external-offsets", "", nil), make(chan bool))
+	go manager.Start()
+	manager.inputChannel <- []*Message{&Message{Offset: 7}, &Message{Offset: 8}}
+	time.Sleep(500 * time.Millisecond)
+	<-manager.Stop()
+	assert(t, external, map[TopicAndPartition]int64{topicPartition: 8})
+	assert(t, commits, 1)
+
+	//offsets are fetched from the external storage as well
+	offset, err := fetchGroupOffset(config, config.OffsetStorage, config.Groupid, topicPartition.Topic, topicPartition.Partition)
+	assert(t, err, nil)
+	assert(t, offset, int64(8))
+	offset, _ = fetchGroupOffset(config, config.OffsetStorage, config.Groupid, topicPartition.Topic, 2)
+	assert(t, offset, InvalidOffset)
+
+	//a failed external commit fails the commit of the consumer
+	config.ExternalOffsetCommitter = func(offsets map[TopicAndPartition]int64) error {
+		return errors.New("transaction aborted")
+	}
+	assert(t, config.Validate(), nil)
+	assertNot(t, config.OffsetStorage.CommitOffset(config.Groupid, topicPartition.Topic, topicPartition.Partition, 9), nil)
+}
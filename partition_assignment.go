@@ -49,20 +49,40 @@ const (
 	RoundRobinStrategy = "roundrobin"
 )
 
-type assignStrategy func(*assignmentContext) map[TopicAndPartition]ConsumerThreadId
+// PartitionAssignor decides which partitions should be owned by consumer threads of a consumer during rebalance.
+// Implement this interface and set it as ConsumerConfig.PartitionAssignor to use a custom partition assignment strategy.
+// Every consumer in a group computes its own ownership decision so all of them should use the same PartitionAssignor.
+type PartitionAssignor interface {
+	// Returns the partitions that should be owned by the consumer given by context.ConsumerId mapped to its consumer threads.
+	Assign(context *AssignmentContext) map[TopicAndPartition]ConsumerThreadId
+}
+
+// RangeAssignor is a PartitionAssignor that implements RangeStrategy.
+type RangeAssignor struct{}
+
+func (this *RangeAssignor) Assign(context *AssignmentContext) map[TopicAndPartition]ConsumerThreadId {
+	return rangeAssignor(context)
+}
 
-func newPartitionAssignor(strategy string) assignStrategy {
+// RoundRobinAssignor is a PartitionAssignor that implements RoundRobinStrategy.
+type RoundRobinAssignor struct{}
+
+func (this *RoundRobinAssignor) Assign(context *AssignmentContext) map[TopicAndPartition]ConsumerThreadId {
+	return roundRobinAssignor(context)
+}
+
+func newPartitionAssignor(strategy string) PartitionAssignor {
 	switch strategy {
 	case RoundRobinStrategy:
-		return roundRobinAssignor
+		return &RoundRobinAssignor{}
 	case RangeStrategy:
-		return rangeAssignor
+		return &RangeAssignor{}
 	default:
 		panic(fmt.Sprintf("Invalid partition assignment strategy: %s", strategy))
 	}
 }
 
-func roundRobinAssignor(context *assignmentContext) map[TopicAndPartition]ConsumerThreadId {
+func roundRobinAssignor(context *AssignmentContext) map[TopicAndPartition]ConsumerThreadId {
 	ownershipDecision := make(map[TopicAndPartition]ConsumerThreadId)
 
 	if len(context.ConsumersForTopic) > 0 {
@@ -105,7 +125,7 @@ func roundRobinAssignor(context *assignmentContext) map[TopicAndPartition]Consum
 	return ownershipDecision
 }
 
-func rangeAssignor(context *assignmentContext) map[TopicAndPartition]ConsumerThreadId {
+func rangeAssignor(context *AssignmentContext) map[TopicAndPartition]ConsumerThreadId {
 	ownershipDecision := make(map[TopicAndPartition]ConsumerThreadId)
 
 	for topic, consumerThreadIds := range context.MyTopicThreadIds {
@@ -164,19 +184,37 @@ func rangeAssignor(context *assignmentContext) map[TopicAndPartition]ConsumerThr
 	return ownershipDecision
 }
 
-type assignmentContext struct {
-	ConsumerId          string
-	Group               string
-	MyTopicThreadIds    map[string][]ConsumerThreadId
+// AssignmentContext describes the state of a consumer group a PartitionAssignor makes its decision upon.
+type AssignmentContext struct {
+	// Id of the consumer the assignment is made for.
+	ConsumerId string
+
+	// Consumer group.
+	Group string
+
+	// Consumer threads of this consumer per subscribed topic.
+	MyTopicThreadIds map[string][]ConsumerThreadId
+
+	// Subscription of this consumer.
 	MyTopicToNumStreams TopicsToNumStreams
-	PartitionsForTopic  map[string][]int32
-	ConsumersForTopic   map[string][]ConsumerThreadId
-	Consumers           []string
-	Brokers             []*BrokerInfo
-	AllTopics           []string
+
+	// Available partitions per subscribed topic, sorted.
+	PartitionsForTopic map[string][]int32
+
+	// Consumer threads of all consumers in the group per topic, sorted.
+	ConsumersForTopic map[string][]ConsumerThreadId
+
+	// Ids of all consumers in the group.
+	Consumers []string
+
+	// All brokers in the cluster.
+	Brokers []*BrokerInfo
+
+	// All topics in the cluster.
+	AllTopics []string
 }
 
-func (context *assignmentContext) hash() string {
+func (context *AssignmentContext) hash() string {
 	hash := md5.New()
 	sort.Sort(byId(context.Brokers))
 	for _, broker := range context.Brokers {
@@ -199,7 +237,7 @@ func (context *assignmentContext) hash() string {
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
-func newAssignmentContext(group string, consumerId string, excludeInternalTopics bool, coordinator ConsumerCoordinator) (*assignmentContext, error) {
+func newAssignmentContext(group string, consumerId string, excludeInternalTopics bool, coordinator ConsumerCoordinator) (*AssignmentContext, error) {
 	brokers, err := coordinator.GetAllBrokers()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to obtain broker list: %s", err))
@@ -231,7 +269,7 @@ func newAssignmentContext(group string, consumerId string, excludeInternalTopics
 		panic(fmt.Sprintf("Failed to obtain consumers: %s, group: %s", err, group))
 	}
 
-	return &assignmentContext{
+	return &AssignmentContext{
 		ConsumerId:          consumerId,
 		Group:               group,
 		MyTopicThreadIds:    myTopicThreadIds,
@@ -245,7 +283,7 @@ func newAssignmentContext(group string, consumerId string, excludeInternalTopics
 }
 
 func newStaticAssignmentContext(group string, consumerId string, consumersInGroup []string, allTopics []string, brokers []*BrokerInfo,
-	topicCount TopicsToNumStreams, topicPartitionMap map[string][]int32) *assignmentContext {
+	topicCount TopicsToNumStreams, topicPartitionMap map[string][]int32) *AssignmentContext {
 	myTopicThreadIds := topicCount.GetConsumerThreadIdsPerTopic()
 	consumersForTopic := make(map[string][]ConsumerThreadId)
 	for topic := range topicPartitionMap {
@@ -259,7 +297,7 @@ func newStaticAssignmentContext(group string, consumerId string, consumersInGrou
 		}
 	}
 
-	return &assignmentContext{
+	return &AssignmentContext{
 		ConsumerId:          consumerId,
 		Group:               group,
 		MyTopicThreadIds:    myTopicThreadIds,
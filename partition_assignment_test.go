@@ -16,6 +16,7 @@ limitations under the License. */
 package go_kafka_client
 
 import (
+	"sort"
 	"testing"
 )
 
@@ -39,7 +40,7 @@ var (
 func TestRoundRobinAssignor(t *testing.T) {
 	//basic scenario
 	assignor := newPartitionAssignor("roundrobin")
-	context := &assignmentContext{
+	context := &AssignmentContext{
 		Group:              "group",
 		PartitionsForTopic: partitionsForTopic,
 		ConsumersForTopic:  consumersForTopic,
@@ -55,7 +56,7 @@ func TestRoundRobinAssignor(t *testing.T) {
 				ConsumerThreadId{consumer, 0},
 				ConsumerThreadId{consumer, 1}},
 		}
-		ownershipDecision := assignor(context)
+		ownershipDecision := assignor.Assign(context)
 		decisionsNum := len(ownershipDecision)
 		if decisionsNum == totalPartitions {
 			t.Errorf("Too many partitions assigned to consumer %s", consumer)
@@ -96,7 +97,7 @@ func TestRoundRobinAssignor(t *testing.T) {
 			ConsumerThreadId{"consumerid2", 0},
 		},
 	}
-	assignor(context)
+	assignor.Assign(context)
 
 	assert(t, failed, true)
 }
@@ -104,7 +105,7 @@ func TestRoundRobinAssignor(t *testing.T) {
 func TestRangeAssignor(t *testing.T) {
 	//basic scenario
 	assignor := newPartitionAssignor("range")
-	context := &assignmentContext{
+	context := &AssignmentContext{
 		Group:              "group",
 		PartitionsForTopic: partitionsForTopic,
 		ConsumersForTopic:  consumersForTopic,
@@ -119,7 +120,7 @@ func TestRangeAssignor(t *testing.T) {
 				ConsumerThreadId{consumer, 0},
 				ConsumerThreadId{consumer, 1}},
 		}
-		ownershipDecision := assignor(context)
+		ownershipDecision := assignor.Assign(context)
 		decisionsNum := len(ownershipDecision)
 		if decisionsNum == totalPartitions {
 			t.Errorf("too many partitions assigned to consumer %s", consumer)
@@ -131,3 +132,85 @@ func TestRangeAssignor(t *testing.T) {
 
 	assert(t, totalDecisions, totalPartitions)
 }
+
+func TestRangeAssignorDistribution(t *testing.T) {
+	var assignor PartitionAssignor = &RangeAssignor{}
+	context := &AssignmentContext{
+		Group:              "group",
+		PartitionsForTopic: partitionsForTopic,
+		ConsumersForTopic:  consumersForTopic,
+		Consumers:          consumers,
+	}
+
+	expected := map[ConsumerThreadId][]int32{
+		ConsumerThreadId{"consumerid1", 0}: []int32{0, 1, 2},
+		ConsumerThreadId{"consumerid1", 1}: []int32{3, 4, 5},
+		ConsumerThreadId{"consumerid2", 0}: []int32{6, 7},
+		ConsumerThreadId{"consumerid2", 1}: []int32{8, 9},
+	}
+	actual := make(map[ConsumerThreadId][]int32)
+	for _, consumer := range consumers {
+		context.ConsumerId = consumer
+		context.MyTopicThreadIds = map[string][]ConsumerThreadId{
+			"topic1": []ConsumerThreadId{
+				ConsumerThreadId{consumer, 0},
+				ConsumerThreadId{consumer, 1}},
+		}
+		for topicPartition, threadId := range assignor.Assign(context) {
+			actual[threadId] = append(actual[threadId], topicPartition.Partition)
+		}
+	}
+	for threadId := range actual {
+		sort.Sort(int32Slice(actual[threadId]))
+	}
+	assert(t, actual, expected)
+}
+
+type int32Slice []int32
+
+func (s int32Slice) Len() int           { return len(s) }
+func (s int32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s int32Slice) Less(i, j int) bool { return s[i] < s[j] }
+
+func TestCustomPartitionAssignor(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.WorkerFailureCallback = func(_ *WorkerManager) FailedDecision { return CommitOffsetAndContinue }
+	config.WorkerFailedAttemptCallback = func(_ *Task, _ WorkerResult) FailedDecision { return CommitOffsetAndContinue }
+	config.Strategy = goodStrategy
+
+	assert(t, config.Validate(), nil)
+	_, isRange := config.PartitionAssignor.(*RangeAssignor)
+	assert(t, isRange, true)
+
+	config.PartitionAssignor = nil
+	config.PartitionAssignmentStrategy = RoundRobinStrategy
+	config.BlueGreenDeploymentEnabled = false
+	assert(t, config.Validate(), nil)
+	_, isRoundRobin := config.PartitionAssignor.(*RoundRobinAssignor)
+	assert(t, isRoundRobin, true)
+
+	custom := &firstThreadAssignor{}
+	config.PartitionAssignor = custom
+	assert(t, config.Validate(), nil)
+	assert(t, config.PartitionAssignor, custom)
+
+	config.BlueGreenDeploymentEnabled = true
+	assertNot(t, config.Validate(), nil)
+}
+
+//assigns all partitions to the first consumer thread in group
+type firstThreadAssignor struct{}
+
+func (this *firstThreadAssignor) Assign(context *AssignmentContext) map[TopicAndPartition]ConsumerThreadId {
+	decision := make(map[TopicAndPartition]ConsumerThreadId)
+	for topic, partitions := range context.PartitionsForTopic {
+		owner := context.ConsumersForTopic[topic][0]
+		if owner.Consumer != context.ConsumerId {
+			continue
+		}
+		for _, partition := range partitions {
+			decision[TopicAndPartition{topic, partition}] = owner
+		}
+	}
+	return decision
+}
@@ -0,0 +1,92 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"sync"
+
+	"github.com/elodina/siesta-producer"
+)
+
+// StickyPartitioner is a producer.Partitioner that sends consecutive keyless records of a topic to the same partition
+// and moves on to the next partition once batchSize records were sent, so that keyless records form full batches
+// instead of being spread over all partitions. Records with keys are partitioned by key hash like with producer.HashPartitioner.
+type StickyPartitioner struct {
+	batchSize int
+	hash      producer.Partitioner
+	sticky    map[string]*stickyPartition
+	lock      sync.Mutex
+}
+
+type stickyPartition struct {
+	index int
+	sent  int
+}
+
+// Creates a new StickyPartitioner that switches partitions of a topic after a given number of keyless records.
+// Matching it with the producer's BatchSize makes each batch go to a single partition.
+func NewStickyPartitioner(batchSize int) *StickyPartitioner {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &StickyPartitioner{
+		batchSize: batchSize,
+		hash:      producer.NewHashPartitioner(),
+		sticky:    make(map[string]*stickyPartition),
+	}
+}
+
+// Returns the partition a given record should be sent to.
+func (this *StickyPartitioner) Partition(record *producer.ProducerRecord, partitions []int32) (int32, error) {
+	if !isKeyless(record.Key) {
+		var partition int32
+		var err error
+		inLock(&this.lock, func() {
+			// the hash partitioner reuses its hasher, so it's not safe for concurrent use
+			partition, err = this.hash.Partition(record, partitions)
+		})
+		return partition, err
+	}
+
+	var partition int32
+	inLock(&this.lock, func() {
+		current, exists := this.sticky[record.Topic]
+		if !exists {
+			current = &stickyPartition{}
+			this.sticky[record.Topic] = current
+		}
+		if current.sent >= this.batchSize {
+			current.index++
+			current.sent = 0
+		}
+		current.index %= len(partitions)
+		current.sent++
+		partition = partitions[current.index]
+	})
+	return partition, nil
+}
+
+func isKeyless(key interface{}) bool {
+	switch raw := key.(type) {
+	case nil:
+		return true
+	case []byte:
+		return len(raw) == 0
+	case string:
+		return raw == ""
+	}
+	return false
+}
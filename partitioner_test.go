@@ -0,0 +1,50 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"testing"
+
+	"github.com/elodina/siesta-producer"
+)
+
+func TestStickyPartitioner(t *testing.T) {
+	partitioner := NewStickyPartitioner(3)
+	partitions := []int32{0, 1, 2}
+
+	partitionsFor := func(topic string, key interface{}, count int) []int32 {
+		result := make([]int32, count)
+		for i := 0; i < count; i++ {
+			partition, err := partitioner.Partition(&producer.ProducerRecord{Topic: topic, Key: key}, partitions)
+			assert(t, err, nil)
+			result[i] = partition
+		}
+		return result
+	}
+
+	//keyless records stick to a partition for a batch and rotate across batches
+	assert(t, partitionsFor("test", nil, 7), []int32{0, 0, 0, 1, 1, 1, 2})
+	assert(t, partitionsFor("test", []byte{}, 5), []int32{2, 2, 0, 0, 0})
+
+	//topics are tracked separately
+	assert(t, partitionsFor("other", nil, 4), []int32{0, 0, 0, 1})
+	assert(t, partitionsFor("test", nil, 1), []int32{1})
+
+	//the sticky partition of a topic is kept within the current number of partitions
+	partition, err := partitioner.Partition(&producer.ProducerRecord{Topic: "other"}, []int32{0})
+	assert(t, err, nil)
+	assert(t, partition, int32(0))
+}
@@ -0,0 +1,175 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elodina/siesta"
+	"github.com/elodina/siesta-producer"
+)
+
+// Defines what MetadataAwareProducer does with records for topics whose metadata is unavailable, e.g. because the topic
+// is being created or its partitions have no leader.
+type MetadataUnavailablePolicy int
+
+const (
+	// Holds records in memory until metadata becomes available and fails them if it does not within the wait timeout.
+	BufferWhenMetadataUnavailable MetadataUnavailablePolicy = iota
+
+	// Fails records right away so that callers, especially synchronous ones, can handle the error themselves.
+	FailWhenMetadataUnavailable
+)
+
+// MetadataAwareProducer decorates a producer.Producer so that records are sent only once metadata for their topic is available.
+// What happens to records sent before that is defined by ProduceWhenMetadataUnavailable. Once metadata for a topic
+// was available, records for it are passed to the underlying producer right away.
+type MetadataAwareProducer struct {
+	producer.Producer
+	connector siesta.Connector
+
+	// Policy for records of topics whose metadata is unavailable.
+	ProduceWhenMetadataUnavailable MetadataUnavailablePolicy
+
+	// Maximum time to buffer records with BufferWhenMetadataUnavailable policy.
+	MetadataWaitTimeout time.Duration
+
+	// Backoff between metadata requests while records are buffered.
+	MetadataBackoff time.Duration
+
+	available map[string]bool
+	buffered  map[string][]*bufferedRecord
+	lock      sync.Mutex
+}
+
+type bufferedRecord struct {
+	record   *producer.ProducerRecord
+	metadata chan *producer.RecordMetadata
+}
+
+// Creates a new MetadataAwareProducer that sends records with a given producer and requests topic metadata with a given connector.
+func NewMetadataAwareProducer(p producer.Producer, connector siesta.Connector, policy MetadataUnavailablePolicy, waitTimeout time.Duration) *MetadataAwareProducer {
+	return &MetadataAwareProducer{
+		Producer:                       p,
+		connector:                      connector,
+		ProduceWhenMetadataUnavailable: policy,
+		MetadataWaitTimeout:            waitTimeout,
+		MetadataBackoff:                100 * time.Millisecond,
+		available:                      make(map[string]bool),
+		buffered:                       make(map[string][]*bufferedRecord),
+	}
+}
+
+func (this *MetadataAwareProducer) String() string {
+	return "metadata-aware-producer"
+}
+
+// Sends a given record if metadata for its topic is available and buffers or fails it otherwise.
+func (this *MetadataAwareProducer) Send(record *producer.ProducerRecord) <-chan *producer.RecordMetadata {
+	available := false
+	var buffered chan *producer.RecordMetadata
+	inLock(&this.lock, func() {
+		available = this.available[record.Topic]
+		if available || this.ProduceWhenMetadataUnavailable != BufferWhenMetadataUnavailable {
+			return
+		}
+
+		buffered = make(chan *producer.RecordMetadata, 1)
+		queue, waiting := this.buffered[record.Topic]
+		this.buffered[record.Topic] = append(queue, &bufferedRecord{record, buffered})
+		if !waiting {
+			go this.awaitMetadata(record.Topic)
+		}
+	})
+	if available {
+		return this.Producer.Send(record)
+	}
+	if buffered != nil {
+		return buffered
+	}
+
+	if err := this.metadataAvailable(record.Topic); err != nil {
+		err = fmt.Errorf("Metadata for topic %s is unavailable: %s", record.Topic, err)
+		Warn(this, err)
+		failed := make(chan *producer.RecordMetadata, 1)
+		failed <- &producer.RecordMetadata{Record: record, Topic: record.Topic, Partition: record.Partition, Error: err}
+		return failed
+	}
+	inLock(&this.lock, func() {
+		this.available[record.Topic] = true
+	})
+	return this.Producer.Send(record)
+}
+
+// Requests metadata for a given topic until it is available or MetadataWaitTimeout elapses, then sends or fails all records buffered for it in order.
+func (this *MetadataAwareProducer) awaitMetadata(topic string) {
+	deadline := time.Now().Add(this.MetadataWaitTimeout)
+	err := this.metadataAvailable(topic)
+	for err != nil && time.Now().Before(deadline) {
+		Debugf(this, "Metadata for topic %s is unavailable, retrying in %s: %s", topic, this.MetadataBackoff, err)
+		time.Sleep(this.MetadataBackoff)
+		err = this.metadataAvailable(topic)
+	}
+	if err != nil {
+		err = fmt.Errorf("Metadata for topic %s is unavailable after %s: %s", topic, this.MetadataWaitTimeout, err)
+		Warn(this, err)
+	}
+
+	inLock(&this.lock, func() {
+		for _, buffered := range this.buffered[topic] {
+			if err != nil {
+				buffered.metadata <- &producer.RecordMetadata{Record: buffered.record, Topic: topic, Partition: buffered.record.Partition, Error: err}
+				continue
+			}
+			go forwardMetadata(this.Producer.Send(buffered.record), buffered.metadata)
+		}
+		delete(this.buffered, topic)
+		this.available[topic] = err == nil
+	})
+}
+
+// Returns nil if a given topic exists and has partitions, an error describing why its metadata is unavailable otherwise.
+func (this *MetadataAwareProducer) metadataAvailable(topic string) error {
+	response, err := this.connector.GetTopicMetadata([]string{topic})
+	if err != nil {
+		return err
+	}
+
+	for _, metadata := range response.TopicsMetadata {
+		if metadata.Topic != topic {
+			continue
+		}
+		if metadata.Error != siesta.ErrNoError {
+			return metadata.Error
+		}
+		if len(metadata.PartitionsMetadata) == 0 {
+			return fmt.Errorf("Topic %s has no partitions", topic)
+		}
+		return nil
+	}
+	return fmt.Errorf("No metadata returned for topic %s", topic)
+}
+
+func forwardMetadata(from <-chan *producer.RecordMetadata, to chan *producer.RecordMetadata) {
+	result, ok := <-from
+	if !ok {
+		close(to)
+		return
+	}
+	to <- result
+}
@@ -0,0 +1,100 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elodina/siesta"
+	"github.com/elodina/siesta-producer"
+)
+
+type delayedMetadataConnector struct {
+	siesta.Connector
+	availableAt time.Time
+	requests    int
+	lock        sync.Mutex
+}
+
+func (this *delayedMetadataConnector) GetTopicMetadata(topics []string) (*siesta.MetadataResponse, error) {
+	inLock(&this.lock, func() { this.requests++ })
+	metadata := &siesta.TopicMetadata{Topic: topics[0], Error: siesta.ErrLeaderNotAvailable}
+	if time.Now().After(this.availableAt) {
+		metadata.Error = siesta.ErrNoError
+		metadata.PartitionsMetadata = []*siesta.PartitionMetadata{&siesta.PartitionMetadata{Error: siesta.ErrNoError, PartitionID: 0}}
+	}
+	return &siesta.MetadataResponse{TopicsMetadata: []*siesta.TopicMetadata{metadata}}, nil
+}
+
+func TestMetadataAwareProducerFailFast(t *testing.T) {
+	mock := newMockProducer(true)
+	connector := &delayedMetadataConnector{availableAt: time.Now().Add(200 * time.Millisecond)}
+	metadataAware := NewMetadataAwareProducer(mock, connector, FailWhenMetadataUnavailable, time.Second)
+
+	result := <-metadataAware.Send(&producer.ProducerRecord{Topic: "test", Value: "1"})
+	assertNot(t, result.Error, nil)
+	if !strings.Contains(result.Error.Error(), "Metadata for topic test is unavailable") {
+		t.Errorf("Unexpected error %s", result.Error)
+	}
+	assert(t, len(mock.records), 0)
+
+	time.Sleep(300 * time.Millisecond)
+	assert(t, (<-metadataAware.Send(&producer.ProducerRecord{Topic: "test", Value: "2"})).Error, nil)
+	assert(t, (<-metadataAware.Send(&producer.ProducerRecord{Topic: "test", Value: "3"})).Error, nil)
+	assert(t, len(mock.records), 2)
+	assert(t, connector.requests, 2)
+}
+
+func TestMetadataAwareProducerBuffer(t *testing.T) {
+	mock := newMockProducer(true)
+	connector := &delayedMetadataConnector{availableAt: time.Now().Add(300 * time.Millisecond)}
+	metadataAware := NewMetadataAwareProducer(mock, connector, BufferWhenMetadataUnavailable, time.Second)
+	metadataAware.MetadataBackoff = 50 * time.Millisecond
+
+	acks := make([]<-chan *producer.RecordMetadata, 0)
+	for _, value := range []string{"1", "2", "3"} {
+		acks = append(acks, metadataAware.Send(&producer.ProducerRecord{Topic: "test", Value: value}))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	inLock(&mock.lock, func() {
+		assert(t, len(mock.records), 0)
+	})
+
+	for _, ack := range acks {
+		select {
+		case result := <-ack:
+			assert(t, result.Error, nil)
+		case <-time.After(time.Second):
+			t.Fatal("Buffered record was not sent once metadata became available")
+		}
+	}
+	assert(t, len(mock.records), 3)
+	for i, value := range []string{"1", "2", "3"} {
+		assert(t, mock.records[i].Value, value)
+	}
+
+	connector.availableAt = time.Now().Add(time.Hour)
+	assert(t, (<-metadataAware.Send(&producer.ProducerRecord{Topic: "test", Value: "4"})).Error, nil)
+
+	metadataAware.MetadataWaitTimeout = 200 * time.Millisecond
+	result := <-metadataAware.Send(&producer.ProducerRecord{Topic: "other", Value: "5"})
+	assertNot(t, result.Error, nil)
+	assert(t, len(mock.records), 4)
+}
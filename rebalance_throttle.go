@@ -0,0 +1,127 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"sync"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// rebalanceThrottle coalesces rebalance triggers to avoid rebalance storms on rapid group membership changes.
+// At most one rebalance is running and at most one more is scheduled at any time; triggers arriving meanwhile are folded
+// into the scheduled one as it observes the latest group state anyway.
+type rebalanceThrottle struct {
+	rebalance     func()
+	cooldown      time.Duration
+	maxRebalances int
+	interval      time.Duration
+	throttled     metrics.Counter
+
+	lock          sync.Mutex
+	running       bool
+	scheduled     bool
+	lastRebalance time.Time
+	starts        []time.Time
+}
+
+func newRebalanceThrottle(rebalance func(), config *ConsumerConfig, throttled metrics.Counter) *rebalanceThrottle {
+	return &rebalanceThrottle{
+		rebalance:     rebalance,
+		cooldown:      config.RebalanceCooldown,
+		maxRebalances: config.MaxRebalancesPerInterval,
+		interval:      config.RebalanceThrottleInterval,
+		throttled:     throttled,
+		starts:        make([]time.Time, 0),
+	}
+}
+
+// Counts throttled rebalances in a given counter from now on, e.g. once the consumer's metrics are recreated.
+func (this *rebalanceThrottle) countThrottledIn(throttled metrics.Counter) {
+	inLock(&this.lock, func() {
+		this.throttled = throttled
+	})
+}
+
+// Requests a rebalance. It starts immediately unless it is coalesced with another one or delayed by cooldown or rate limit.
+func (this *rebalanceThrottle) trigger() {
+	inLock(&this.lock, func() {
+		if this.scheduled || this.running {
+			this.scheduled = true
+			this.throttled.Inc(1)
+			return
+		}
+
+		this.scheduled = true
+		if !this.schedule(time.Now()) {
+			this.throttled.Inc(1)
+		}
+	})
+}
+
+// Schedules the next rebalance. Returns true if it starts immediately, false if it is delayed. Must be called with lock held.
+func (this *rebalanceThrottle) schedule(now time.Time) bool {
+	delay := this.delay(now)
+	if delay > 0 {
+		Infof("rebalance-throttle", "Delaying rebalance for %s", delay)
+		time.AfterFunc(delay, this.run)
+		return false
+	}
+
+	go this.run()
+	return true
+}
+
+// Must be called with lock held.
+func (this *rebalanceThrottle) delay(now time.Time) time.Duration {
+	delay := this.lastRebalance.Add(this.cooldown).Sub(now)
+
+	if this.maxRebalances > 0 {
+		recent := make([]time.Time, 0, len(this.starts))
+		for _, start := range this.starts {
+			if now.Sub(start) < this.interval {
+				recent = append(recent, start)
+			}
+		}
+		this.starts = recent
+		if len(recent) >= this.maxRebalances {
+			if backoff := recent[len(recent)-this.maxRebalances].Add(this.interval).Sub(now); backoff > delay {
+				delay = backoff
+			}
+		}
+	}
+
+	return delay
+}
+
+func (this *rebalanceThrottle) run() {
+	inLock(&this.lock, func() {
+		this.scheduled = false
+		this.running = true
+		this.starts = append(this.starts, time.Now())
+	})
+
+	this.rebalance()
+
+	inLock(&this.lock, func() {
+		this.running = false
+		this.lastRebalance = time.Now()
+		if this.scheduled {
+			this.schedule(this.lastRebalance)
+		}
+	})
+}
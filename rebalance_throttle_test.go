@@ -0,0 +1,99 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+func TestRebalanceThrottleCoalescesWithinCooldown(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.RebalanceCooldown = 500 * time.Millisecond
+	var rebalances int32
+	throttled := metrics.NewCounter()
+	throttle := newRebalanceThrottle(func() {
+		atomic.AddInt32(&rebalances, 1)
+		time.Sleep(50 * time.Millisecond)
+	}, config, throttled)
+
+	throttle.trigger()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 99; i++ {
+		throttle.trigger()
+	}
+	time.Sleep(100 * time.Millisecond)
+	// the first trigger rebalances immediately, the rest are coalesced into a single rebalance after the cooldown
+	assert(t, atomic.LoadInt32(&rebalances), int32(1))
+	assert(t, throttled.Count(), int64(99))
+
+	time.Sleep(600 * time.Millisecond)
+	assert(t, atomic.LoadInt32(&rebalances), int32(2))
+
+	for i := 0; i < 10; i++ {
+		throttle.trigger()
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert(t, atomic.LoadInt32(&rebalances), int32(2))
+	time.Sleep(600 * time.Millisecond)
+	assert(t, atomic.LoadInt32(&rebalances), int32(3))
+}
+
+func TestRebalanceThrottleMaxRebalancesPerInterval(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.MaxRebalancesPerInterval = 2
+	config.RebalanceThrottleInterval = 500 * time.Millisecond
+	var rebalances int32
+	throttled := metrics.NewCounter()
+	throttle := newRebalanceThrottle(func() {
+		atomic.AddInt32(&rebalances, 1)
+	}, config, throttled)
+
+	throttle.trigger()
+	time.Sleep(50 * time.Millisecond)
+	throttle.trigger()
+	time.Sleep(50 * time.Millisecond)
+	assert(t, atomic.LoadInt32(&rebalances), int32(2))
+	assert(t, throttled.Count(), int64(0))
+
+	// the third rebalance backs off until the first one falls out of the interval
+	throttle.trigger()
+	time.Sleep(100 * time.Millisecond)
+	assert(t, atomic.LoadInt32(&rebalances), int32(2))
+	assert(t, throttled.Count(), int64(1))
+
+	time.Sleep(400 * time.Millisecond)
+	assert(t, atomic.LoadInt32(&rebalances), int32(3))
+}
+
+func TestRebalanceThrottleCountThrottledIn(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.RebalanceCooldown = time.Hour
+	throttled := metrics.NewCounter()
+	throttle := newRebalanceThrottle(func() {}, config, throttled)
+	throttle.trigger()
+	time.Sleep(50 * time.Millisecond)
+
+	// a resumed consumer recreates its metrics, throttled rebalances are counted in the new ones
+	recreated := metrics.NewCounter()
+	throttle.countThrottledIn(recreated)
+	throttle.trigger()
+	assert(t, throttled.Count(), int64(0))
+	assert(t, recreated.Count(), int64(1))
+}
@@ -0,0 +1,230 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elodina/siesta-producer"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// ScheduledRecord is sent to a schedule topic (JSON encoded) by ScheduledProducer for each record that should be delivered later.
+type ScheduledRecord struct {
+	// Topic the record should be delivered to.
+	Topic string `json:"topic"`
+
+	// Partition the record should be delivered to.
+	Partition int32 `json:"partition"`
+
+	// Serialized record key.
+	Key []byte `json:"key"`
+
+	// Serialized record value.
+	Value []byte `json:"value"`
+
+	// Time the record is due, in milliseconds since epoch.
+	DeliverAt int64 `json:"deliverAt"`
+}
+
+// ScheduledProducer decorates a producer.Producer so that records can be delivered after a given delay. Delayed records are serialized
+// and sent to a schedule topic as JSON encoded ScheduledRecords, so they are persisted in Kafka until a Scheduler consuming that topic delivers them.
+type ScheduledProducer struct {
+	producer.Producer
+	keySerializer    producer.Serializer
+	valueSerializer  producer.Serializer
+	scheduleTopic    string
+	scheduleProducer producer.Producer
+
+	// Maximum delay a record can be scheduled with. Records scheduled with a longer delay fail to send, so this must be positive
+	// for records to be scheduled at all.
+	MaxDelay time.Duration
+}
+
+// Creates a new ScheduledProducer that sends records with a given producer. Delayed records are serialized with given key and value serializers
+// and sent to scheduleTopic with scheduleProducer, which should be configured with byte array key and value serializers.
+func NewScheduledProducer(p producer.Producer, keySerializer producer.Serializer, valueSerializer producer.Serializer,
+	scheduleTopic string, scheduleProducer producer.Producer, maxDelay time.Duration) *ScheduledProducer {
+	return &ScheduledProducer{
+		Producer:         p,
+		keySerializer:    keySerializer,
+		valueSerializer:  valueSerializer,
+		scheduleTopic:    scheduleTopic,
+		scheduleProducer: scheduleProducer,
+		MaxDelay:         maxDelay,
+	}
+}
+
+func (this *ScheduledProducer) String() string {
+	return "scheduled-producer"
+}
+
+// Sends a given record once deliverAfter elapses. Records without a delay are sent right away. For delayed records
+// the returned channel reports whether the record was scheduled, not whether it was delivered.
+func (this *ScheduledProducer) SendAfter(record *producer.ProducerRecord, deliverAfter time.Duration) <-chan *producer.RecordMetadata {
+	if deliverAfter <= 0 {
+		return this.Producer.Send(record)
+	}
+
+	scheduled, err := this.schedule(record, deliverAfter)
+	var encoded []byte
+	if err == nil {
+		encoded, err = json.Marshal(scheduled)
+	}
+	if err != nil {
+		Errorf(this, "Failed to schedule record for topic %s: %s", record.Topic, err)
+		failed := make(chan *producer.RecordMetadata, 1)
+		failed <- &producer.RecordMetadata{Record: record, Topic: record.Topic, Partition: record.Partition, Error: err}
+		return failed
+	}
+
+	return this.scheduleProducer.Send(&producer.ProducerRecord{
+		Topic: this.scheduleTopic,
+		Key:   scheduled.Key,
+		Value: encoded,
+	})
+}
+
+func (this *ScheduledProducer) schedule(record *producer.ProducerRecord, deliverAfter time.Duration) (*ScheduledRecord, error) {
+	if deliverAfter > this.MaxDelay {
+		return nil, fmt.Errorf("Delay %s exceeds maximum delay %s", deliverAfter, this.MaxDelay)
+	}
+
+	key, err := this.keySerializer(record.Key)
+	if err != nil {
+		return nil, err
+	}
+	value, err := this.valueSerializer(record.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScheduledRecord{
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Key:       key,
+		Value:     value,
+		DeliverAt: time.Now().Add(deliverAfter).UnixNano() / int64(time.Millisecond),
+	}, nil
+}
+
+// Scheduler delivers ScheduledRecords consumed from a schedule topic to their target topics once they are due.
+// Its offsets are committed only after a record is delivered or requeued, so scheduled records survive a restart of the scheduler consumer.
+type Scheduler struct {
+	producer  producer.Producer
+	maxDelay  time.Duration
+	stopped   chan struct{}
+	delivered metrics.Counter
+	requeued  metrics.Counter
+}
+
+// Creates a new Scheduler that delivers records with a given producer, which should be configured with byte array key and value serializers.
+// The Scheduler waits for records due within maxDelay and requeues records due later to the schedule topic. maxDelay must be positive and
+// below WorkerTaskTimeout of a given config of the schedule topic consumer so that waiting for a record never times out and redelivers it.
+func NewScheduler(p producer.Producer, maxDelay time.Duration, config *ConsumerConfig) (*Scheduler, error) {
+	if maxDelay <= 0 {
+		return nil, errors.New("MaxDelay must be positive")
+	}
+	if maxDelay >= config.WorkerTaskTimeout {
+		return nil, fmt.Errorf("MaxDelay %s must be below WorkerTaskTimeout %s", maxDelay, config.WorkerTaskTimeout)
+	}
+
+	return &Scheduler{
+		producer:  p,
+		maxDelay:  maxDelay,
+		stopped:   make(chan struct{}),
+		delivered: metrics.NewCounter(),
+		requeued:  metrics.NewCounter(),
+	}, nil
+}
+
+func (this *Scheduler) String() string {
+	return "scheduler"
+}
+
+// WorkerStrategy for the schedule topic consumer. Waits until a record is due and delivers it. Records that are not due within
+// MaxDelay, or that are waited for when the Scheduler is closed, are sent back to the schedule topic instead. Records that can't be decoded are skipped.
+func (this *Scheduler) Strategy(_ *Worker, msg *Message, id TaskId) WorkerResult {
+	record := &ScheduledRecord{}
+	if err := json.Unmarshal(msg.Value, record); err != nil {
+		Errorf(this, "Failed to decode scheduled record %s: %s", msg, err)
+		return NewSkippedResult(id)
+	}
+
+	deliverAt := time.Unix(0, record.DeliverAt*int64(time.Millisecond))
+	if delay := deliverAt.Sub(time.Now()); delay > 0 {
+		if delay > this.maxDelay {
+			return this.requeue(msg, id)
+		}
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-this.stopped:
+			return this.requeue(msg, id)
+		}
+	}
+
+	metadata, ok := <-this.producer.Send(&producer.ProducerRecord{
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Key:       record.Key,
+		Value:     record.Value,
+	})
+	if !ok || metadata.Error != nil {
+		Errorf(this, "Failed to deliver scheduled record %s to topic %s", msg, record.Topic)
+		return NewProcessingFailedResult(id)
+	}
+
+	this.delivered.Inc(1)
+	return NewSuccessfulResult(id)
+}
+
+// Sends a given scheduled record back to the schedule topic it was consumed from so that it is picked up again later.
+func (this *Scheduler) requeue(msg *Message, id TaskId) WorkerResult {
+	metadata, ok := <-this.producer.Send(&producer.ProducerRecord{
+		Topic: msg.Topic,
+		Key:   msg.Key,
+		Value: msg.Value,
+	})
+	if !ok || metadata.Error != nil {
+		Errorf(this, "Failed to requeue scheduled record %s", msg)
+		return NewProcessingFailedResult(id)
+	}
+
+	this.requeued.Inc(1)
+	return NewSuccessfulResult(id)
+}
+
+// Stops waiting for records so that the schedule topic consumer can be closed right away. Records that are waited for are requeued.
+// Should be called before closing the schedule topic consumer.
+func (this *Scheduler) Close() {
+	close(this.stopped)
+}
+
+// Returns the number of scheduled records delivered to their target topics.
+func (this *Scheduler) Delivered() metrics.Counter {
+	return this.delivered
+}
+
+// Returns the number of scheduled records sent back to the schedule topic.
+func (this *Scheduler) Requeued() metrics.Counter {
+	return this.requeued
+}
@@ -0,0 +1,129 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/elodina/siesta-producer"
+)
+
+func TestScheduledProducer(t *testing.T) {
+	mock := newMockProducer(true)
+	schedule := newMockProducer(true)
+	scheduled := NewScheduledProducer(mock, producer.ByteSerializer, producer.StringSerializer, "schedule", schedule, time.Minute)
+
+	assert(t, (<-scheduled.SendAfter(&producer.ProducerRecord{Topic: "test", Value: "now"}, 0)).Error, nil)
+	assert(t, len(mock.records), 1)
+	assert(t, len(schedule.records), 0)
+
+	assertNot(t, (<-scheduled.SendAfter(&producer.ProducerRecord{Topic: "test", Value: "too late"}, time.Hour)).Error, nil)
+	assert(t, len(schedule.records), 0)
+
+	assert(t, (<-scheduled.SendAfter(&producer.ProducerRecord{Topic: "test", Partition: 1, Key: []byte("k"), Value: "later"}, 300*time.Millisecond)).Error, nil)
+	assert(t, len(mock.records), 1)
+	assert(t, len(schedule.records), 1)
+	assert(t, schedule.records[0].Topic, "schedule")
+
+	value := schedule.records[0].Value.([]byte)
+	record := &ScheduledRecord{}
+	assert(t, json.Unmarshal(value, record), nil)
+	assert(t, record.Topic, "test")
+	assert(t, record.Partition, int32(1))
+	assert(t, record.Value, []byte("later"))
+
+	delivered := newMockProducer(true)
+	scheduler, err := NewScheduler(delivered, time.Second, DefaultConsumerConfig())
+	assert(t, err, nil)
+	results := make(chan WorkerResult, 1)
+	go func() {
+		msg := &Message{Topic: "schedule", Offset: 5, Value: value}
+		results <- scheduler.Strategy(nil, msg, TaskId{TopicAndPartition{"schedule", 0}, 5})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	inLock(&delivered.lock, func() {
+		assert(t, len(delivered.records), 0)
+	})
+
+	select {
+	case result := <-results:
+		assert(t, result.Success(), true)
+	case <-time.After(time.Second):
+		t.Fatal("Scheduled record was not delivered")
+	}
+	assert(t, len(delivered.records), 1)
+	assert(t, delivered.records[0].Topic, "test")
+	assert(t, delivered.records[0].Key, []byte("k"))
+	assert(t, delivered.records[0].Value, []byte("later"))
+	assert(t, scheduler.Delivered().Count(), int64(1))
+
+	result := scheduler.Strategy(nil, &Message{Value: []byte("garbage")}, TaskId{TopicAndPartition{"schedule", 0}, 6})
+	assert(t, result.Success(), true)
+	assert(t, scheduler.Delivered().Count(), int64(1))
+}
+
+func TestSchedulerRequeuesRecords(t *testing.T) {
+	config := DefaultConsumerConfig()
+	_, err := NewScheduler(newMockProducer(true), 0, config)
+	assertNot(t, err, nil)
+	_, err = NewScheduler(newMockProducer(true), config.WorkerTaskTimeout, config)
+	assertNot(t, err, nil)
+
+	scheduled := NewScheduledProducer(newMockProducer(true), producer.ByteSerializer, producer.ByteSerializer, "schedule", newMockProducer(true), 0)
+	assertNot(t, (<-scheduled.SendAfter(&producer.ProducerRecord{Topic: "test", Value: []byte("later")}, time.Second)).Error, nil)
+
+	p := newMockProducer(true)
+	scheduler, err := NewScheduler(p, time.Second, config)
+	assert(t, err, nil)
+	scheduledRecord := func(deliverAfter time.Duration) []byte {
+		value, err := json.Marshal(&ScheduledRecord{Topic: "test", Value: []byte("later"), DeliverAt: time.Now().Add(deliverAfter).UnixNano() / int64(time.Millisecond)})
+		assert(t, err, nil)
+		return value
+	}
+
+	//records due after MaxDelay are sent back to the schedule topic right away
+	msg := &Message{Topic: "schedule", Key: []byte("k"), Value: scheduledRecord(time.Hour), Offset: 0}
+	result := scheduler.Strategy(nil, msg, TaskId{TopicAndPartition{"schedule", 0}, 0})
+	assert(t, result.Success(), true)
+	assert(t, len(p.records), 1)
+	assert(t, p.records[0].Topic, "schedule")
+	assert(t, p.records[0].Key, []byte("k"))
+	assert(t, p.records[0].Value, msg.Value)
+	assert(t, scheduler.Requeued().Count(), int64(1))
+
+	//records that are waited for are requeued once the scheduler is closed
+	results := make(chan WorkerResult, 1)
+	go func() {
+		msg := &Message{Topic: "schedule", Value: scheduledRecord(500 * time.Millisecond), Offset: 1}
+		results <- scheduler.Strategy(nil, msg, TaskId{TopicAndPartition{"schedule", 0}, 1})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	scheduler.Close()
+
+	select {
+	case result := <-results:
+		assert(t, result.Success(), true)
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("Closing the scheduler should stop waiting for records")
+	}
+	assert(t, len(p.records), 2)
+	assert(t, p.records[1].Topic, "schedule")
+	assert(t, scheduler.Requeued().Count(), int64(2))
+	assert(t, scheduler.Delivered().Count(), int64(0))
+}
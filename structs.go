@@ -17,6 +17,7 @@ package go_kafka_client
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 )
 
@@ -47,12 +48,37 @@ type Message struct {
 
 	// HighwaterMarkOffset is an offset of the last message in this topic-partition.
 	HighwaterMarkOffset int64
+
+	// Set when this message failed to decode and was sent to a dead letter topic instead of being processed.
+	deadLettered bool
 }
 
 func (m *Message) String() string {
 	return fmt.Sprintf("Message{Topic: %s, Partition: %d, Offset: %d}", m.Topic, m.Partition, m.Offset)
 }
 
+// DeadLetter is sent to ConsumerConfig.DeadLetterTopic (JSON encoded) for each message that failed to decode
+// and by DeadLetteringProducer for each record that failed to serialize.
+type DeadLetter struct {
+	// Topic the message came from.
+	Topic string `json:"topic"`
+
+	// Partition the message came from.
+	Partition int32 `json:"partition"`
+
+	// Message offset. InvalidOffset for records that failed to serialize.
+	Offset int64 `json:"offset"`
+
+	// Raw message key.
+	Key []byte `json:"key"`
+
+	// Raw message value.
+	Value []byte `json:"value"`
+
+	// Decoder or serializer error.
+	Error string `json:"error"`
+}
+
 //General information about Kafka broker. Used to keep it in consumer coordinator.
 type BrokerInfo struct {
 	Version int16
@@ -132,16 +158,38 @@ func (tp *TopicAndPartition) String() string {
 	return fmt.Sprintf("{Topic: %s, Partition: %d}", tp.Topic, tp.Partition)
 }
 
+type byTopicAndPartition []TopicAndPartition
+
+func (a byTopicAndPartition) Len() int      { return len(a) }
+func (a byTopicAndPartition) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byTopicAndPartition) Less(i, j int) bool {
+	if a[i].Topic != a[j].Topic {
+		return a[i].Topic < a[j].Topic
+	}
+	return a[i].Partition < a[j].Partition
+}
+
 type partitionTopicInfo struct {
 	Topic         string
 	Partition     int32
 	Buffer        *messageBuffer
 	FetchedOffset int64
+	// Whether FetchedOffset is the offset to start fetching from rather than the last processed offset.
+	Seek bool
+}
+
+// Returns the offset of the next message to fetch. Safe to call while the partition is being fetched.
+func (p *partitionTopicInfo) fetchedOffset() int64 {
+	return atomic.LoadInt64(&p.FetchedOffset)
+}
+
+func (p *partitionTopicInfo) setFetchedOffset(offset int64) {
+	atomic.StoreInt64(&p.FetchedOffset, offset)
 }
 
 func (p *partitionTopicInfo) String() string {
 	return fmt.Sprintf("{Topic: %s, Partition: %d, FetchedOffset: %d, Buffer: %s}",
-		p.Topic, p.Partition, p.FetchedOffset, p.Buffer)
+		p.Topic, p.Partition, p.fetchedOffset(), p.Buffer)
 }
 
 type intArray []int32
@@ -245,6 +293,17 @@ type OffsetStorage interface {
 	CommitOffset(group string, topic string, partition int32, offset int64) error
 }
 
+// noopOffsetStorage is an OffsetStorage that does not store anything. Used when offsets should not be committed.
+type noopOffsetStorage struct{}
+
+func (this *noopOffsetStorage) GetOffset(group string, topic string, partition int32) (int64, error) {
+	return InvalidOffset, nil
+}
+
+func (this *noopOffsetStorage) CommitOffset(group string, topic string, partition int32, offset int64) error {
+	return nil
+}
+
 // Represents a consumer state snapshot.
 type StateSnapshot struct {
 	// Metrics are a map where keys are event names and values are maps holding event values grouped by meters (count, min, max, etc.).
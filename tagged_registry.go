@@ -0,0 +1,199 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+var invalidPrometheusChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+
+// TaggedRegistry decorates a metrics.Registry with tags (labels) for registered metrics, e.g. topic and partition.
+// Metrics are still registered in the underlying registry under their flat names, so reporters that don't know about tags keep working.
+// The underlying registry may be shared with other TaggedRegistries; each of them only unregisters the metrics registered through it.
+type TaggedRegistry struct {
+	metrics.Registry
+	names    map[string]bool
+	families map[string]string
+	tags     map[string]map[string]string
+	lock     sync.RWMutex
+}
+
+// Creates a new TaggedRegistry that registers metrics in a given registry.
+func NewTaggedRegistry(registry metrics.Registry) *TaggedRegistry {
+	return &TaggedRegistry{
+		Registry: registry,
+		names:    make(map[string]bool),
+		families: make(map[string]string),
+		tags:     make(map[string]map[string]string),
+	}
+}
+
+// Registers a given metric under a given flat name without tags.
+func (this *TaggedRegistry) Register(name string, metric interface{}) error {
+	if err := this.Registry.Register(name, metric); err != nil {
+		return err
+	}
+
+	inWriteLock(&this.lock, func() {
+		this.names[name] = true
+	})
+	return nil
+}
+
+// Registers a given metric under a given flat name. Tag aware reporters report it as a metric family with given tags instead,
+// so that e.g. lags of all partitions are reported as a single family tagged with topic and partition.
+func (this *TaggedRegistry) RegisterTagged(name string, family string, tags map[string]string, metric interface{}) error {
+	if err := this.Register(name, metric); err != nil {
+		return err
+	}
+
+	inWriteLock(&this.lock, func() {
+		this.families[name] = family
+		this.tags[name] = tags
+	})
+	return nil
+}
+
+// Unregisters a metric with a given name along with its tags.
+func (this *TaggedRegistry) Unregister(name string) {
+	this.Registry.Unregister(name)
+	inWriteLock(&this.lock, func() {
+		delete(this.names, name)
+		delete(this.families, name)
+		delete(this.tags, name)
+	})
+}
+
+// Unregisters all metrics registered through this TaggedRegistry along with their tags.
+// Other metrics of the underlying registry are left intact.
+func (this *TaggedRegistry) UnregisterAll() {
+	inWriteLock(&this.lock, func() {
+		for name := range this.names {
+			this.Registry.Unregister(name)
+		}
+		this.names = make(map[string]bool)
+		this.families = make(map[string]string)
+		this.tags = make(map[string]map[string]string)
+	})
+}
+
+// Returns the metric family and tags a metric with a given name was registered with. Metrics registered without tags
+// are their own family with no tags.
+func (this *TaggedRegistry) Tags(name string) (string, map[string]string) {
+	var family string
+	var tags map[string]string
+	var exists bool
+	inReadLock(&this.lock, func() {
+		family, exists = this.families[name]
+		tags = this.tags[name]
+	})
+	if !exists {
+		family = name
+	}
+	return family, tags
+}
+
+// Writes all metrics registered through this TaggedRegistry once in Prometheus text exposition format, with the type of each
+// metric family. Metrics registered in the underlying registry otherwise, e.g. by other consumers sharing it, are left out.
+func (this *TaggedRegistry) WritePrometheus(writer io.Writer) error {
+	names := make([]string, 0)
+	inReadLock(&this.lock, func() {
+		for name := range this.names {
+			names = append(names, name)
+		}
+	})
+
+	types := make(map[string]string)
+	samples := make(map[string][]string)
+	for _, name := range names {
+		family, tags := this.Tags(name)
+		family = invalidPrometheusChars.ReplaceAllString(family, "_")
+		sample := func(family string, metricType string, suffix string, extraTags map[string]string, value interface{}) {
+			types[family] = metricType
+			samples[family] = append(samples[family], fmt.Sprintf("%s%s%s %v", family, suffix, prometheusLabels(tags, extraTags), value))
+		}
+
+		switch entry := this.Get(name).(type) {
+		case metrics.Counter:
+			sample(family, "counter", "", nil, entry.Count())
+		case metrics.Gauge:
+			sample(family, "gauge", "", nil, entry.Value())
+		case metrics.GaugeFloat64:
+			sample(family, "gauge", "", nil, entry.Value())
+		case metrics.Histogram:
+			snapshot := entry.Snapshot()
+			for _, quantile := range []float64{0.5, 0.75, 0.95, 0.99} {
+				sample(family, "summary", "", map[string]string{"quantile": fmt.Sprint(quantile)}, snapshot.Percentile(quantile))
+			}
+			sample(family, "summary", "_sum", nil, snapshot.Sum())
+			sample(family, "summary", "_count", nil, snapshot.Count())
+		case metrics.Meter:
+			snapshot := entry.Snapshot()
+			sample(family+"_count", "counter", "", nil, snapshot.Count())
+			sample(family+"_rate1", "gauge", "", nil, snapshot.Rate1())
+		case metrics.Timer:
+			snapshot := entry.Snapshot()
+			for _, quantile := range []float64{0.5, 0.75, 0.95, 0.99} {
+				sample(family, "summary", "", map[string]string{"quantile": fmt.Sprint(quantile)}, snapshot.Percentile(quantile))
+			}
+			sample(family, "summary", "_sum", nil, snapshot.Sum())
+			sample(family, "summary", "_count", nil, snapshot.Count())
+		}
+	}
+
+	families := make([]string, 0, len(types))
+	for family := range types {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+
+	for _, family := range families {
+		if _, err := fmt.Fprintf(writer, "# TYPE %s %s\n", family, types[family]); err != nil {
+			return err
+		}
+		sort.Strings(samples[family])
+		for _, line := range samples[family] {
+			if _, err := fmt.Fprintln(writer, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func prometheusLabels(tags map[string]string, extraTags map[string]string) string {
+	labels := make([]string, 0, len(tags)+len(extraTags))
+	for _, all := range []map[string]string{tags, extraTags} {
+		for key, value := range all {
+			value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+			labels = append(labels, fmt.Sprintf(`%s="%s"`, invalidPrometheusChars.ReplaceAllString(key, "_"), value))
+		}
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+
+	sort.Strings(labels)
+	return fmt.Sprintf("{%s}", strings.Join(labels, ","))
+}
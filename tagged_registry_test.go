@@ -0,0 +1,111 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+func TestTaggedRegistry(t *testing.T) {
+	registry := NewTaggedRegistry(metrics.NewRegistry())
+
+	lag := metrics.NewGauge()
+	lag.Update(42)
+	assert(t, registry.RegisterTagged("Lag-consumer-test-0", "Lag", map[string]string{"topic": "test", "partition": "0"}, lag), nil)
+	assertNot(t, registry.RegisterTagged("Lag-consumer-test-0", "Lag", nil, metrics.NewGauge()), nil)
+	assert(t, registry.Get("Lag-consumer-test-0"), lag)
+
+	counter := metrics.NewRegisteredCounter("plain.counter", registry)
+	counter.Inc(3)
+
+	family, tags := registry.Tags("Lag-consumer-test-0")
+	assert(t, family, "Lag")
+	assert(t, tags["topic"], "test")
+	family, tags = registry.Tags("plain.counter")
+	assert(t, family, "plain.counter")
+	assert(t, len(tags), 0)
+
+	var output bytes.Buffer
+	assert(t, registry.WritePrometheus(&output), nil)
+	assert(t, output.String(), "# TYPE Lag gauge\nLag{partition=\"0\",topic=\"test\"} 42\n# TYPE plain_counter counter\nplain_counter 3\n")
+
+	registry.Unregister("Lag-consumer-test-0")
+	family, _ = registry.Tags("Lag-consumer-test-0")
+	assert(t, family, "Lag-consumer-test-0")
+}
+
+func TestConsumerMetricsPrometheus(t *testing.T) {
+	consumerMetrics := newConsumerMetrics("prometheus-consumer", "", nil)
+	defer consumerMetrics.close()
+
+	consumerMetrics.topicAndPartitionLag("test", 1).Update(5)
+	consumerMetrics.numFetchedMessages().Inc(10)
+
+	var output bytes.Buffer
+	assert(t, consumerMetrics.WritePrometheus(&output), nil)
+	exposition := output.String()
+	assert(t, strings.Contains(exposition, "Lag{consumer=\"prometheus-consumer\",partition=\"1\",topic=\"test\"} 5\n"), true)
+	assert(t, strings.Contains(exposition, "FetchedMessages{consumer=\"prometheus-consumer\"} 10\n"), true)
+}
+
+func TestTaggedRegistrySharedPrometheus(t *testing.T) {
+	shared := metrics.NewRegistry()
+	first := NewTaggedRegistry(shared)
+	second := NewTaggedRegistry(shared)
+	metrics.NewRegisteredCounter("untracked", shared).Inc(1)
+	assert(t, first.RegisterTagged("Acks-first", "Acks", map[string]string{"consumer": "first"}, metrics.NewCounter()), nil)
+	assert(t, second.RegisterTagged("Acks-second", "Acks", map[string]string{"consumer": "second"}, metrics.NewCounter()), nil)
+
+	//each registry only writes the metrics registered through it
+	var output bytes.Buffer
+	assert(t, first.WritePrometheus(&output), nil)
+	assert(t, output.String(), "# TYPE Acks counter\nAcks{consumer=\"first\"} 0\n")
+	output.Reset()
+	assert(t, second.WritePrometheus(&output), nil)
+	assert(t, output.String(), "# TYPE Acks counter\nAcks{consumer=\"second\"} 0\n")
+}
+
+func TestConsumerMetricsRegistry(t *testing.T) {
+	shared := metrics.NewRegistry()
+	first := newConsumerMetrics("first-consumer", "", shared)
+	second := newConsumerMetrics("second-consumer", "", shared)
+	assert(t, first.Registry(), shared)
+
+	first.numFetchedMessages().Inc(1)
+	second.numFetchedMessages().Inc(2)
+	assert(t, shared.Get("FetchedMessages-first-consumer").(metrics.Counter).Count(), int64(1))
+	assert(t, shared.Get("FetchedMessages-second-consumer").(metrics.Counter).Count(), int64(2))
+
+	//closing a consumer only unregisters its own metrics
+	first.close()
+	assert(t, shared.Get("FetchedMessages-first-consumer"), nil)
+	assert(t, shared.Get("FetchedMessages-second-consumer").(metrics.Counter).Count(), int64(2))
+	second.close()
+
+	//consumers without a configured registry don't collide even with the same name
+	isolated := newConsumerMetrics("same-consumer", "", nil)
+	other := newConsumerMetrics("same-consumer", "", nil)
+	isolated.numFetchedMessages().Inc(3)
+	assertNot(t, isolated.Registry(), other.Registry())
+	assert(t, other.numFetchedMessages().Count(), int64(0))
+	assert(t, metrics.DefaultRegistry.Get("FetchedMessages-same-consumer"), nil)
+	isolated.close()
+	other.close()
+}
@@ -21,6 +21,8 @@ import (
 	"github.com/elodina/siesta"
 	"github.com/elodina/siesta-producer"
 	"github.com/samuel/go-zookeeper/zk"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"reflect"
@@ -151,7 +153,7 @@ func produceN(t *testing.T, n int, topic string, brokerAddr string) {
 	producerConfig := producer.NewProducerConfig()
 
 	p := producer.NewKafkaProducer(producerConfig, producer.ByteSerializer, producer.StringSerializer, connector)
-	defer p.Close()
+	defer p.Close(5 * time.Second)
 
 	metadatas := make([]<-chan *producer.RecordMetadata, n)
 
@@ -175,7 +177,7 @@ func produceNToTopicPartition(t *testing.T, n int, topic string, partition int,
 	producerConfig.Partitioner = producer.NewManualPartitioner()
 
 	p := producer.NewKafkaProducer(producerConfig, producer.ByteSerializer, producer.StringSerializer, connector)
-	defer p.Close()
+	defer p.Close(5 * time.Second)
 
 	metadatas := make([]<-chan *producer.RecordMetadata, n)
 
@@ -300,3 +302,69 @@ func EnsureHasLeader(zkConnect string, topic string) {
 	}
 	time.Sleep(time.Duration(numPartitions) * time.Second)
 }
+
+//Sends a given record directly to a broker with a given id bypassing partition leader lookup and returns the offset it was written at.
+//THIS IS FOR TESTING ONLY: it is meant to validate leader election and failover handling in integration tests, opens a new connection
+//on every call and does no retries. Record key and value must be []byte. Returns siesta.ErrNotLeaderForPartition if the broker is not
+//the leader for the record's partition.
+func SendToBroker(connector siesta.Connector, brokerId int32, record *producer.ProducerRecord) (int64, error) {
+	key, _ := record.Key.([]byte)
+	value, ok := record.Value.([]byte)
+	if !ok && record.Value != nil {
+		return InvalidOffset, fmt.Errorf("Record value must be []byte, got %T", record.Value)
+	}
+
+	metadata, err := connector.GetTopicMetadata([]string{record.Topic})
+	if err != nil {
+		return InvalidOffset, err
+	}
+	var broker *siesta.Broker
+	for _, candidate := range metadata.Brokers {
+		if candidate.ID == brokerId {
+			broker = candidate
+		}
+	}
+	if broker == nil {
+		return InvalidOffset, fmt.Errorf("Broker %d is not in the cluster", brokerId)
+	}
+
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", broker.Host, broker.Port), 5*time.Second)
+	if err != nil {
+		return InvalidOffset, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	request := &siesta.ProduceRequest{RequiredAcks: 1, AckTimeoutMs: 10000}
+	request.AddMessage(record.Topic, record.Partition, &siesta.Message{Key: key, Value: value})
+	header := siesta.NewRequestHeader(0, "send-to-broker", request)
+	bytes := make([]byte, header.Size())
+	header.Write(siesta.NewBinaryEncoder(bytes))
+	if _, err := conn.Write(bytes); err != nil {
+		return InvalidOffset, err
+	}
+
+	//response size and correlation id
+	sizeAndCorrelationId := make([]byte, 8)
+	if _, err := io.ReadFull(conn, sizeAndCorrelationId); err != nil {
+		return InvalidOffset, err
+	}
+	size, _ := siesta.NewBinaryDecoder(sizeAndCorrelationId).GetInt32()
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return InvalidOffset, err
+	}
+
+	response := new(siesta.ProduceResponse)
+	if decodingErr := response.Read(siesta.NewBinaryDecoder(body)); decodingErr != nil {
+		return InvalidOffset, decodingErr.Error()
+	}
+	status, exists := response.Status[record.Topic][record.Partition]
+	if !exists {
+		return InvalidOffset, fmt.Errorf("Broker %d did not respond for %s, partition %d", brokerId, record.Topic, record.Partition)
+	}
+	if status.Error != siesta.ErrNoError {
+		return InvalidOffset, status.Error
+	}
+	return status.Offset, nil
+}
@@ -0,0 +1,164 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// timestampMerger interleaves messages of multiple partitions by timestamp before passing them to a WorkerStrategy.
+// Each message is held for up to a reordering window waiting for earlier messages from other partitions. Once any message
+// has waited for the whole window the oldest queued message is handed to the strategy, so messages are processed in
+// timestamp order, one at a time. Messages that arrive after a later message has already
+// been processed are still processed, just out of order, so this only approximates global ordering.
+type timestampMerger struct {
+	strategy  WorkerStrategy
+	window    time.Duration
+	timestamp func(*Message) time.Time
+	pending   pendingMessages
+	lock      sync.Mutex
+	wakeup    chan struct{}
+	stopped   chan struct{}
+}
+
+func newTimestampMerger(strategy WorkerStrategy, window time.Duration, timestamp func(*Message) time.Time) *timestampMerger {
+	merger := &timestampMerger{
+		strategy:  strategy,
+		window:    window,
+		timestamp: timestamp,
+		wakeup:    make(chan struct{}, 1),
+		stopped:   make(chan struct{}),
+	}
+	go merger.run()
+
+	return merger
+}
+
+// Queues a given message and blocks until it is processed by the wrapped strategy. Returns a failed result if the merger
+// is stopped before the message is processed.
+func (m *timestampMerger) merge(worker *Worker, msg *Message, id TaskId) WorkerResult {
+	pending := &pendingMessage{
+		worker:    worker,
+		msg:       msg,
+		id:        id,
+		timestamp: m.timestamp(msg),
+		releaseAt: time.Now().Add(m.window),
+		result:    make(chan WorkerResult, 1),
+	}
+	stopped := false
+	inLock(&m.lock, func() {
+		select {
+		case <-m.stopped:
+			stopped = true
+		default:
+			heap.Push(&m.pending, pending)
+		}
+	})
+	if stopped {
+		return NewProcessingFailedResult(id)
+	}
+	select {
+	case m.wakeup <- struct{}{}:
+	default:
+	}
+
+	return <-pending.result
+}
+
+func (m *timestampMerger) run() {
+	timer := time.NewTimer(m.window)
+	defer timer.Stop()
+	for {
+		var next *pendingMessage
+		wait := m.window
+		inLock(&m.lock, func() {
+			if m.pending.Len() == 0 {
+				return
+			}
+			if wait = m.pending.earliestRelease().Sub(time.Now()); wait <= 0 {
+				next = heap.Pop(&m.pending).(*pendingMessage)
+			}
+		})
+		if next != nil {
+			next.result <- m.strategy(next.worker, next.msg, next.id)
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-m.stopped:
+			return
+		case <-m.wakeup:
+		case <-timer.C:
+		}
+	}
+}
+
+// Stops the merger. Messages still waiting to be processed are completed with a failed result.
+func (m *timestampMerger) stop() {
+	close(m.stopped)
+	inLock(&m.lock, func() {
+		for m.pending.Len() > 0 {
+			pending := heap.Pop(&m.pending).(*pendingMessage)
+			pending.result <- NewProcessingFailedResult(pending.id)
+		}
+	})
+}
+
+type pendingMessage struct {
+	worker    *Worker
+	msg       *Message
+	id        TaskId
+	timestamp time.Time
+	releaseAt time.Time
+	result    chan WorkerResult
+}
+
+// pendingMessages is a min-heap of messages ordered by timestamp, oldest first.
+type pendingMessages []*pendingMessage
+
+func (p pendingMessages) Len() int           { return len(p) }
+func (p pendingMessages) Less(i, j int) bool { return p[i].timestamp.Before(p[j].timestamp) }
+func (p pendingMessages) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (p pendingMessages) earliestRelease() time.Time {
+	earliest := p[0].releaseAt
+	for _, pending := range p[1:] {
+		if pending.releaseAt.Before(earliest) {
+			earliest = pending.releaseAt
+		}
+	}
+	return earliest
+}
+
+func (p *pendingMessages) Push(x interface{}) {
+	*p = append(*p, x.(*pendingMessage))
+}
+
+func (p *pendingMessages) Pop() interface{} {
+	old := *p
+	last := old[len(old)-1]
+	*p = old[:len(old)-1]
+	return last
+}
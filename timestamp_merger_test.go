@@ -0,0 +1,110 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimestampMerger(t *testing.T) {
+	base := time.Now()
+	var processed []int64
+	var lock sync.Mutex
+	strategy := func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		inLock(&lock, func() {
+			processed = append(processed, msg.Offset)
+		})
+		return NewSuccessfulResult(id)
+	}
+	timestamp := func(msg *Message) time.Time {
+		return base.Add(time.Duration(msg.Offset) * time.Second)
+	}
+	merger := newTimestampMerger(strategy, 200*time.Millisecond, timestamp)
+	defer merger.stop()
+
+	//partition 0 gets messages with timestamps 0, 3, 6..., partition 1 with 1, 4, 7... and so on, delivered partition after partition
+	var wg sync.WaitGroup
+	for partition := int32(2); partition >= 0; partition-- {
+		for i := int64(0); i < 5; i++ {
+			offset := i*3 + int64(partition)
+			msg := &Message{Topic: "test", Partition: partition, Offset: offset}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := merger.merge(nil, msg, TaskId{TopicAndPartition{"test", msg.Partition}, msg.Offset})
+				assert(t, result.Success(), true)
+			}()
+		}
+	}
+	wg.Wait()
+
+	assert(t, len(processed), 15)
+	for i, offset := range processed {
+		assert(t, offset, int64(i))
+	}
+
+	//a message older than everything processed so far is still processed once its window passes
+	start := time.Now()
+	merger.merge(nil, &Message{Topic: "test", Partition: 0, Offset: -1}, TaskId{TopicAndPartition{"test", 0}, -1})
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Late message should be held for the merge window, held for %s", elapsed)
+	}
+	assert(t, processed[15], int64(-1))
+}
+
+func TestTimestampMergerStop(t *testing.T) {
+	strategy := func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		return NewSuccessfulResult(id)
+	}
+	merger := newTimestampMerger(strategy, time.Hour, func(msg *Message) time.Time { return time.Now() })
+
+	results := make(chan WorkerResult)
+	go func() {
+		results <- merger.merge(nil, &Message{Topic: "test", Offset: 0}, TaskId{TopicAndPartition{"test", 0}, 0})
+	}()
+	//let the message get queued, it is held for an hour otherwise
+	time.Sleep(100 * time.Millisecond)
+	merger.stop()
+
+	select {
+	case result := <-results:
+		assert(t, result.Success(), false)
+	case <-time.After(time.Second):
+		t.Fatal("Pending message was not completed on stop")
+	}
+	assert(t, merger.merge(nil, &Message{Topic: "test", Offset: 1}, TaskId{TopicAndPartition{"test", 0}, 1}).Success(), false)
+}
+
+func TestConsumerRestartsMerger(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.MergeWindow = 10 * time.Millisecond
+	config.MessageTimestamp = func(msg *Message) time.Time { return time.Now() }
+	config.Strategy = goodStrategy
+	c := &Consumer{config: config}
+	id := TaskId{TopicAndPartition{"test", 0}, 0}
+
+	c.startMerger()
+	assert(t, c.workerStrategy()(nil, &Message{}, id).Success(), true)
+	c.merger.stop()
+
+	//the configured strategy is left intact, so resuming the consumer merges with a new merger
+	c.startMerger()
+	defer c.merger.stop()
+	assert(t, c.workerStrategy()(nil, &Message{}, id).Success(), true)
+	assert(t, c.config.Strategy(nil, &Message{}, id).Success(), true)
+}
@@ -0,0 +1,36 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import "github.com/elodina/siesta-producer"
+
+// Creates a record with a given key and a nil value, i.e. a tombstone for a given key.
+// The value serializer of the producer must pass nil values through, e.g. producer.ByteSerializer or a serializer wrapped with TombstoneSerializer,
+// so that the value is sent as null and log compaction deletes the key.
+func NewTombstone(topic string, key interface{}) *producer.ProducerRecord {
+	return &producer.ProducerRecord{Topic: topic, Key: key, Value: nil}
+}
+
+// Wraps a given value serializer so that nil values are passed through as nil instead of being serialized,
+// e.g. encoded as an empty payload or rejected, so tombstones can be sent with any serializer.
+func TombstoneSerializer(serializer producer.Serializer) producer.Serializer {
+	return func(value interface{}) ([]byte, error) {
+		if value == nil {
+			return nil, nil
+		}
+		return serializer(value)
+	}
+}
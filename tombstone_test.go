@@ -0,0 +1,90 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/elodina/siesta"
+	"github.com/elodina/siesta-producer"
+)
+
+func TestTombstoneSerializer(t *testing.T) {
+	_, err := producer.StringSerializer(nil)
+	assertNot(t, err, nil)
+
+	serializer := TombstoneSerializer(producer.StringSerializer)
+	value, err := serializer(nil)
+	assert(t, err, nil)
+	assert(t, value == nil, true)
+	value, err = serializer("value")
+	assert(t, err, nil)
+	assert(t, value, []byte("value"))
+	_, err = serializer(42)
+	assertNot(t, err, nil)
+}
+
+func TestProduceTombstone(t *testing.T) {
+	mock := newMockProducer(true)
+	deadLetters := newMockProducer(true)
+	deadLettering := NewDeadLetteringProducer(mock, producer.StringSerializer, TombstoneSerializer(producer.StringSerializer), "dlq", deadLetters)
+
+	result := <-deadLettering.Send(NewTombstone("compacted", "deleted-key"))
+	assert(t, result.Error, nil)
+	assert(t, len(deadLetters.records), 0)
+	assert(t, len(mock.records), 1)
+	assert(t, mock.records[0].Topic, "compacted")
+	assert(t, mock.records[0].Key, []byte("deleted-key"))
+	assert(t, mock.records[0].Value, []byte(nil))
+
+	//without passing nil through the tombstone can't be serialized
+	deadLettering = NewDeadLetteringProducer(mock, producer.StringSerializer, producer.StringSerializer, "dlq", deadLetters)
+	result = <-deadLettering.Send(NewTombstone("compacted", "deleted-key"))
+	assertNot(t, result.Error, nil)
+	assert(t, len(deadLetters.records), 1)
+}
+
+func TestTombstoneEncoding(t *testing.T) {
+	tombstone := NewTombstone("compacted", "deleted-key")
+	value, err := TombstoneSerializer(producer.StringSerializer)(tombstone.Value)
+	assert(t, err, nil)
+
+	//the value length is the last field of a message, -1 marks a null value
+	bytes := encodeMessage(&siesta.Message{Key: []byte("deleted-key"), Value: value})
+	assert(t, int32(binary.BigEndian.Uint32(bytes[len(bytes)-4:])), int32(-1))
+
+	decoded := &siesta.Message{}
+	assert(t, decoded.Read(siesta.NewBinaryDecoder(bytes)), (*siesta.DecodingError)(nil))
+	assert(t, decoded.Key, []byte("deleted-key"))
+	assert(t, decoded.Value, []byte(nil))
+
+	//an empty value is not a tombstone
+	bytes = encodeMessage(&siesta.Message{Key: []byte("deleted-key"), Value: []byte{}})
+	assert(t, int32(binary.BigEndian.Uint32(bytes[len(bytes)-4:])), int32(0))
+
+	//a nil key is null as well
+	bytes = encodeMessage(&siesta.Message{Value: []byte("value")})
+	assert(t, int32(binary.BigEndian.Uint32(bytes[6:10])), int32(-1))
+}
+
+func encodeMessage(message *siesta.Message) []byte {
+	sizing := siesta.NewSizingEncoder()
+	message.Write(sizing)
+	bytes := make([]byte, sizing.Size())
+	message.Write(siesta.NewBinaryEncoder(bytes))
+	return bytes
+}
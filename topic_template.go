@@ -0,0 +1,82 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"text/template"
+)
+
+// Kafka topic names may only contain ASCII alphanumerics, '.', '_' and '-' and be at most 249 characters long.
+const maxTopicLength = 249
+
+var invalidTopicChars = regexp.MustCompile("[^a-zA-Z0-9._-]")
+
+// TopicTemplate derives a destination topic from message content, e.g. "events.{{.eventType}}.{{.appId}}" is evaluated
+// against the fields of a JSON encoded message value. Characters that are not allowed in topic names are replaced with '_'.
+type TopicTemplate struct {
+	text     string
+	template *template.Template
+}
+
+// Creates a new TopicTemplate for a given template text. Returns an error if the text is not a valid template.
+func NewTopicTemplate(text string) (*TopicTemplate, error) {
+	compiled, err := template.New("topic").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TopicTemplate{
+		text:     text,
+		template: compiled,
+	}, nil
+}
+
+func (this *TopicTemplate) String() string {
+	return this.text
+}
+
+// Returns the topic for a given JSON encoded message value. Returns a given fallback topic if the value is not a JSON object,
+// references fields it doesn't have or the resulting topic is empty.
+func (this *TopicTemplate) Resolve(value []byte, fallback string) string {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(value, &fields); err != nil {
+		Debugf(this, "Failed to parse message value, falling back to topic %s: %s", fallback, err)
+		return fallback
+	}
+
+	var topic bytes.Buffer
+	if err := this.template.Execute(&topic, fields); err != nil {
+		Debugf(this, "Failed to evaluate template, falling back to topic %s: %s", fallback, err)
+		return fallback
+	}
+
+	return sanitizeTopic(topic.String(), fallback)
+}
+
+func sanitizeTopic(topic string, fallback string) string {
+	topic = invalidTopicChars.ReplaceAllString(topic, "_")
+	if len(topic) > maxTopicLength {
+		topic = topic[:maxTopicLength]
+	}
+	if topic == "" || topic == "." || topic == ".." {
+		return fallback
+	}
+
+	return topic
+}
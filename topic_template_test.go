@@ -0,0 +1,60 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package go_kafka_client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopicTemplate(t *testing.T) {
+	topicTemplate, err := NewTopicTemplate("events.{{.eventType}}.{{.appId}}")
+	assert(t, err, nil)
+
+	// substitution
+	assert(t, topicTemplate.Resolve([]byte(`{"eventType": "click", "appId": 42}`), "fallback"), "events.click.42")
+
+	// sanitization
+	assert(t, topicTemplate.Resolve([]byte(`{"eventType": "page view", "appId": "a/b:c"}`), "fallback"), "events.page_view.a_b_c")
+	long := topicTemplate.Resolve([]byte(`{"eventType": "`+strings.Repeat("x", 300)+`", "appId": 1}`), "fallback")
+	assert(t, len(long), maxTopicLength)
+
+	// fallback
+	assert(t, topicTemplate.Resolve([]byte("not json"), "fallback"), "fallback")
+	assert(t, topicTemplate.Resolve([]byte(`["click"]`), "fallback"), "fallback")
+	assert(t, topicTemplate.Resolve([]byte(`{"eventType": "click"}`), "fallback"), "fallback")
+
+	emptyTemplate, err := NewTopicTemplate("{{.eventType}}")
+	assert(t, err, nil)
+	assert(t, emptyTemplate.Resolve([]byte(`{"eventType": ""}`), "fallback"), "fallback")
+
+	_, err = NewTopicTemplate("events.{{.eventType")
+	assertNot(t, err, nil)
+}
+
+func TestMirrorMakerTopicTemplate(t *testing.T) {
+	config := NewMirrorMakerConfig()
+	config.TopicPrefix = "dc1_"
+	config.TopicTemplate = "events.{{.eventType}}"
+	mirrorMaker := newTestMirrorMaker(t, config)
+
+	assert(t, mirrorMaker.destinationTopic(&Message{Topic: "source", Value: []byte(`{"eventType": "click"}`)}), "events.click")
+	assert(t, mirrorMaker.destinationTopic(&Message{Topic: "source", Value: []byte("binary")}), "dc1_source")
+
+	config.TopicTemplate = "events.{{.eventType"
+	_, err := NewMirrorMaker(config)
+	assertNot(t, err, nil)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/yanzay/cfg"
 	"hash/fnv"
 	"math/rand"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -224,6 +225,15 @@ func uuid() string {
 	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 }
 
+// Returns a client id that identifies this host, e.g. "go-client-web01". Falls back to "go-client" if the hostname is unknown.
+func defaultClientId() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "go-client"
+	}
+	return fmt.Sprintf("go-client-%s", hostname)
+}
+
 type barrier struct {
 	size               int32
 	watchers           int32
@@ -275,6 +285,38 @@ func (b *barrier) reset(size int32) {
 	})
 }
 
+// rateLimiter spaces out calls to wait so that they don't happen more often than a given rate per second.
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+	lock     sync.Mutex
+}
+
+func newRateLimiter(ratePerSecond float64) *rateLimiter {
+	if ratePerSecond <= 0 {
+		panic("Rate must be positive")
+	}
+
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / ratePerSecond),
+	}
+}
+
+// Reserves the next slot under the lock and sleeps until it outside of it so that concurrent callers don't queue up on the lock.
+func (r *rateLimiter) wait() {
+	var delay time.Duration
+	inLock(&r.lock, func() {
+		now := time.Now()
+		if r.next.After(now) {
+			delay = r.next.Sub(now)
+			r.next = r.next.Add(r.interval)
+		} else {
+			r.next = now.Add(r.interval)
+		}
+	})
+	time.Sleep(delay)
+}
+
 type hashArray []*TopicAndPartition
 
 func (s hashArray) Len() int      { return len(s) }
@@ -18,6 +18,7 @@ package go_kafka_client
 import (
 	"fmt"
 	"math"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -28,37 +29,49 @@ import (
 type WorkerManager struct {
 	id                  string
 	config              *ConsumerConfig
+	strategy            WorkerStrategy
 	workers             []*Worker
 	availableWorkers    chan *Worker
 	currentBatch        *taskBatch
+	batchLock           sync.RWMutex
 	batchOrder          []TaskId
 	inputChannel        chan []*Message
 	topicPartition      TopicAndPartition
 	largestOffset       int64
 	lastCommittedOffset int64
+	commitLock          sync.Mutex
 	failCounter         *FailureCounter
 	batchProcessed      chan bool
 	stopLock            sync.Mutex
 	managerStop         chan bool
 	processingStop      chan bool
 	commitStop          chan bool
+	commitFinished      chan bool
 	closeConsumer       chan bool
 	shutdownDecision    *FailedDecision
+	lastProgress        int64
+	quarantined         int32
 
 	metrics *ConsumerMetrics
 }
 
 // Creates a new WorkerManager with given id using a given ConsumerConfig and responsible for managing given TopicAndPartition.
 func NewWorkerManager(id string, config *ConsumerConfig, topicPartition TopicAndPartition, metrics *ConsumerMetrics, closeConsumer chan bool) *WorkerManager {
-	workers := make([]*Worker, config.NumWorkers)
-	availableWorkers := make(chan *Worker, config.NumWorkers)
-	for i := 0; i < config.NumWorkers; i++ {
+	numWorkers := config.NumWorkers
+	if config.OrderedWithinPartition {
+		// a single worker per partition processes its messages strictly one after another
+		numWorkers = 1
+	}
+	workers := make([]*Worker, numWorkers)
+	availableWorkers := make(chan *Worker, numWorkers)
+	for i := 0; i < numWorkers; i++ {
 		workers[i] = &Worker{
 			InputChannel:         make(chan *TaskAndStrategy),
 			OutputChannel:        make(chan WorkerResult),
 			HandlerInputChannel:  make(chan *TaskAndStrategy),
 			HandlerOutputChannel: make(chan WorkerResult),
 			TaskTimeout:          config.WorkerTaskTimeout,
+			Observer:             config.ProcessingObserver,
 		}
 		workers[i].Start()
 		availableWorkers <- workers[i]
@@ -67,6 +80,7 @@ func NewWorkerManager(id string, config *ConsumerConfig, topicPartition TopicAnd
 	return &WorkerManager{
 		id:                  id,
 		config:              config,
+		strategy:            config.Strategy,
 		availableWorkers:    availableWorkers,
 		workers:             workers,
 		inputChannel:        make(chan []*Message),
@@ -75,11 +89,13 @@ func NewWorkerManager(id string, config *ConsumerConfig, topicPartition TopicAnd
 		topicPartition:      topicPartition,
 		largestOffset:       InvalidOffset,
 		lastCommittedOffset: InvalidOffset,
+		lastProgress:        time.Now().UnixNano(),
 		failCounter:         NewFailureCounter(config.WorkerRetryThreshold, config.WorkerThresholdTimeWindow),
 		batchProcessed:      make(chan bool),
 		managerStop:         make(chan bool),
 		processingStop:      make(chan bool),
 		commitStop:          make(chan bool),
+		commitFinished:      make(chan bool),
 		metrics:             metrics,
 		closeConsumer:       closeConsumer,
 	}
@@ -126,6 +142,11 @@ func (wm *WorkerManager) Start() {
 // Tells this WorkerManager to finish processing current batch, stop accepting new work and shut down.
 // This method returns immediately and returns a channel which will get the value once the shut down is finished.
 func (wm *WorkerManager) Stop() chan bool {
+	return wm.stop(true)
+}
+
+// Stops this WorkerManager, committing the highest processed offset before returning only if commit is true.
+func (wm *WorkerManager) stop(commit bool) chan bool {
 	finished := make(chan bool)
 	go func() {
 		Debugf(wm, "Trying to stop workerManager")
@@ -136,7 +157,8 @@ func (wm *WorkerManager) Stop() chan bool {
 			wm.processingStop <- true
 			Debug(wm, "Successful manager stop")
 			Debug(wm, "Stopping committer")
-			wm.commitStop <- true
+			wm.commitStop <- commit
+			<-wm.commitFinished
 			Debug(wm, "Successful committer stop")
 			wm.failCounter.Close()
 			Debug(wm, "Stopped failure counter")
@@ -161,14 +183,36 @@ func (wm *WorkerManager) startBatch(batch []*Message) {
 		lag := wm.metrics.topicAndPartitionLag(last.Topic, last.Partition)
 		lag.Update((last.HighwaterMarkOffset - last.Offset) - 1)
 
-		wm.currentBatch = newTaskBatch()
+		wm.progressed()
+		inWriteLock(&wm.batchLock, func() {
+			wm.currentBatch = newTaskBatch()
+		})
 		wm.batchOrder = make([]TaskId, 0)
-		for _, message := range batch {
+		messages, filteredOffset := wm.intercept(batch)
+		superseded := wm.superseded(messages)
+		for _, message := range messages {
+			stale := wm.isStale(message)
+			if stale {
+				wm.metrics.staleMessages().Inc(1)
+			}
+			if superseded[message.Offset] {
+				wm.metrics.compactedMessages().Inc(1)
+			}
+			if stale || superseded[message.Offset] || message.deadLettered || (wm.config.MessageFilter != nil && !wm.config.MessageFilter(message)) {
+				if message.Offset > filteredOffset {
+					filteredOffset = message.Offset
+				}
+				continue
+			}
 			topicPartition := TopicAndPartition{message.Topic, message.Partition}
 			id := TaskId{topicPartition, message.Offset}
 			wm.batchOrder = append(wm.batchOrder, id)
 			wm.currentBatch.add(id, &Task{Msg: message})
 		}
+		if len(wm.batchOrder) == 0 {
+			wm.UpdateLargestOffset(filteredOffset)
+			return
+		}
 		wm.metrics.pendingWMsTasks().Inc(int64(wm.currentBatch.numOutstanding()))
 		for _, id := range wm.batchOrder {
 			task := wm.currentBatch.get(id)
@@ -178,72 +222,203 @@ func (wm *WorkerManager) startBatch(batch []*Message) {
 				wm.metrics.activeWorkers().Inc(1)
 				wm.metrics.pendingWMsTasks().Dec(1)
 				wm.metrics.numConsumedMessages().Inc(1)
-				worker.InputChannel <- &TaskAndStrategy{task, wm.config.Strategy}
+				if wm.config.MessageTimestamp != nil {
+					wm.metrics.messageConsumed(task.Msg.Topic, wm.config.MessageTimestamp(task.Msg))
+				}
+				worker.InputChannel <- &TaskAndStrategy{task, wm.strategy}
 			} else {
 				return
 			}
 		}
 
 		<-wm.batchProcessed
+		// filtered and dropped messages are considered processed once the rest of the batch is done
+		wm.UpdateLargestOffset(filteredOffset)
 	})
 }
 
+// Returns true if a given message is older than ConsumerConfig.MaxMessageAge and should be skipped.
+func (wm *WorkerManager) isStale(message *Message) bool {
+	if wm.config.MaxMessageAge <= 0 || wm.config.MessageTimestamp == nil {
+		return false
+	}
+	return time.Since(wm.config.MessageTimestamp(message)) > wm.config.MaxMessageAge
+}
+
+// Returns offsets of messages in a given batch that are followed by a message with the same key if ConsumerConfig.CompactByKey is set.
+// Messages without a key are never superseded.
+func (wm *WorkerManager) superseded(batch []*Message) map[int64]bool {
+	superseded := make(map[int64]bool)
+	if !wm.config.CompactByKey {
+		return superseded
+	}
+
+	latest := make(map[string]int64)
+	for _, message := range batch {
+		if len(message.Key) == 0 {
+			continue
+		}
+		if offset, exists := latest[string(message.Key)]; exists {
+			superseded[offset] = true
+		}
+		latest[string(message.Key)] = message.Offset
+	}
+	return superseded
+}
+
+// Passes a given batch through configured ConsumerInterceptors. Returns the resulting messages and the largest offset of dropped messages.
+func (wm *WorkerManager) intercept(batch []*Message) ([]*Message, int64) {
+	droppedOffset := InvalidOffset
+	if len(wm.config.ConsumerInterceptors) == 0 {
+		return batch, droppedOffset
+	}
+
+	intercepted := batch
+	for _, interceptor := range wm.config.ConsumerInterceptors {
+		intercepted = interceptor.OnConsume(intercepted)
+	}
+
+	remaining := make(map[int64]bool)
+	for _, message := range intercepted {
+		remaining[message.Offset] = true
+	}
+	for _, message := range batch {
+		if !remaining[message.Offset] && message.Offset > droppedOffset {
+			droppedOffset = message.Offset
+		}
+	}
+	return intercepted, droppedOffset
+}
+
 func (wm *WorkerManager) commitBatch() {
 	for {
 		timeout := time.NewTimer(wm.config.OffsetCommitInterval)
 		select {
-		case <-wm.commitStop:
+		case commit := <-wm.commitStop:
 			{
 				timeout.Stop()
-				wm.commitOffset()
+				if commit {
+					wm.commitOffset()
+				}
+				wm.commitFinished <- true
 				return
 			}
 		case <-timeout.C:
 			{
+				wm.checkStalled()
 				wm.commitOffset()
 			}
 		}
 	}
 }
 
-func (wm *WorkerManager) commitOffset() {
-	largestOffset := wm.GetLargestOffset()
+// Quarantines this WorkerManager if its current batch has not made progress within PartitionStallTimeout.
+func (wm *WorkerManager) checkStalled() {
+	if wm.config.PartitionStallTimeout <= 0 || wm.IsBatchProcessed() || wm.Quarantined() {
+		return
+	}
+
+	stalledFor := time.Since(time.Unix(0, atomic.LoadInt64(&wm.lastProgress)))
+	if stalledFor > wm.config.PartitionStallTimeout && atomic.CompareAndSwapInt32(&wm.quarantined, 0, 1) {
+		Warnf(wm, "%s made no progress for %s, quarantining it", &wm.topicPartition, stalledFor)
+		wm.metrics.quarantinedPartitions().Inc(1)
+	}
+}
+
+// Records that this WorkerManager made progress, releasing it from quarantine if necessary.
+func (wm *WorkerManager) progressed() {
+	atomic.StoreInt64(&wm.lastProgress, time.Now().UnixNano())
+	if atomic.CompareAndSwapInt32(&wm.quarantined, 1, 0) {
+		Infof(wm, "%s made progress again, releasing it from quarantine", &wm.topicPartition)
+		wm.metrics.quarantinedPartitions().Dec(1)
+	}
+}
+
+// Returns true if this WorkerManager is stalled for longer than PartitionStallTimeout, false otherwise.
+func (wm *WorkerManager) Quarantined() bool {
+	return atomic.LoadInt32(&wm.quarantined) == 1
+}
+
+// Commits the highest processed offset if it was not committed yet. Returns false if the commit failed, true otherwise.
+func (wm *WorkerManager) commitOffset() bool {
+	wm.commitLock.Lock()
+	defer wm.commitLock.Unlock()
+
+	return wm.commit(wm.GetLargestOffset()) == nil
+}
+
+// Commits a given offset if it is higher than the last committed one, e.g. the offset of a message the client has handled itself.
+// Returns the last error if the commit failed after all retries.
+func (wm *WorkerManager) commitGivenOffset(offset int64) error {
+	wm.commitLock.Lock()
+	defer wm.commitLock.Unlock()
+
+	return wm.commit(offset)
+}
+
+// Commits the highest processed offset and returns the last committed offset. Must be called with stopLock held so that no batch is in progress.
+func (wm *WorkerManager) checkpoint() (int64, error) {
+	wm.commitLock.Lock()
+	defer wm.commitLock.Unlock()
+
+	err := wm.commit(wm.GetLargestOffset())
+	return wm.lastCommittedOffset, err
+}
+
+// Must be called with commitLock held.
+func (wm *WorkerManager) commit(offset int64) error {
 	if Logger.IsAllowed(TraceLevel) {
-		Tracef(wm, "Inside commit offset with largest %d and last %d", largestOffset, wm.lastCommittedOffset)
+		Tracef(wm, "Inside commit offset with largest %d and last %d", offset, wm.lastCommittedOffset)
 	}
-	if largestOffset <= wm.lastCommittedOffset || isOffsetInvalid(largestOffset) {
-		return
+	if offset <= wm.lastCommittedOffset || isOffsetInvalid(offset) {
+		return nil
 	}
 
 	success := false
+	var err error
 	for i := 0; i <= wm.config.OffsetsCommitMaxRetries; i++ {
-		err := wm.config.OffsetStorage.CommitOffset(wm.config.Groupid, wm.topicPartition.Topic, wm.topicPartition.Partition, largestOffset)
+		wm.metrics.offsetCommitRequests().Inc(1)
+		err = wm.config.OffsetStorage.CommitOffset(wm.config.Groupid, wm.topicPartition.Topic, wm.topicPartition.Partition, offset)
 		if err == nil {
 			success = true
 			if Logger.IsAllowed(TraceLevel) {
-				Tracef(wm, "Successfully committed offset %d for %s", largestOffset, wm.topicPartition)
+				Tracef(wm, "Successfully committed offset %d for %s", offset, wm.topicPartition)
 			}
 			break
 		} else {
-			Debugf(wm, "Failed to commit offset %d for %s; error: %s. Retrying...", largestOffset, &wm.topicPartition, err)
+			Debugf(wm, "Failed to commit offset %d for %s; error: %s. Retrying...", offset, &wm.topicPartition, err)
 		}
 	}
 
 	if !success {
-		Errorf(wm, "Failed to commit offset %d for %s after %d retries", largestOffset, &wm.topicPartition, wm.config.OffsetsCommitMaxRetries)
+		Errorf(wm, "Failed to commit offset %d for %s after %d retries", offset, &wm.topicPartition, wm.config.OffsetsCommitMaxRetries)
+		wm.metrics.offsetCommitFailures().Inc(1)
 		//TODO: what to do next?
 	} else {
-		wm.lastCommittedOffset = largestOffset
+		wm.lastCommittedOffset = offset
+		for _, interceptor := range wm.config.ConsumerInterceptors {
+			interceptor.OnCommit(map[TopicAndPartition]int64{wm.topicPartition: offset})
+		}
 	}
+
+	if wm.config.OnCommit != nil {
+		wm.config.OnCommit(map[TopicAndPartition]int64{wm.topicPartition: offset}, err)
+	}
+
+	return err
 }
 
 // Asks this WorkerManager whether the current batch is fully processed. Returns true if so, false otherwise.
 func (wm *WorkerManager) IsBatchProcessed() bool {
-	return wm.currentBatch.done()
+	processed := false
+	inReadLock(&wm.batchLock, func() {
+		processed = wm.currentBatch.done()
+	})
+	return processed
 }
 
 func (wm *WorkerManager) processBatch() {
-	outputChannels := make([]*chan WorkerResult, wm.config.NumWorkers)
+	outputChannels := make([]*chan WorkerResult, len(wm.workers))
 	for i, worker := range wm.workers {
 		outputChannels[i] = &worker.OutputChannel
 	}
@@ -263,7 +438,10 @@ func (wm *WorkerManager) processBatch() {
 					continue
 				}
 
-				if result.Success() {
+				if _, ok := result.(*SkippedResult); ok {
+					wm.metrics.numSkips().Inc(1)
+					wm.taskSucceeded(result)
+				} else if result.Success() {
 					wm.metrics.numAcks().Inc(1)
 					wm.taskSucceeded(result)
 				} else {
@@ -272,6 +450,9 @@ func (wm *WorkerManager) processBatch() {
 						wm.metrics.taskTimeouts().Inc(1)
 						task.Callee.OutputChannel = make(chan WorkerResult)
 					}
+					if _, ok := result.(*StrategyPanickedResult); ok {
+						wm.metrics.strategyPanics().Inc(1)
+					}
 
 					Debugf(wm, "Worker task %s has failed", result.Id())
 					task.Retries++
@@ -310,7 +491,7 @@ func (wm *WorkerManager) processBatch() {
 						Debugf(wm, "Retrying worker task %s %dth time", result.Id(), task.Retries)
 						time.Sleep(wm.config.WorkerBackoff)
 						go func() {
-							task.Callee.InputChannel <- &TaskAndStrategy{task, wm.config.Strategy}
+							task.Callee.InputChannel <- &TaskAndStrategy{task, wm.strategy}
 						}()
 					}
 				}
@@ -356,6 +537,7 @@ func (wm *WorkerManager) taskSucceeded(result WorkerResult) {
 }
 
 func (wm *WorkerManager) taskIsDone(result WorkerResult) {
+	wm.progressed()
 	wm.availableWorkers <- wm.currentBatch.get(result.Id()).Callee
 	wm.currentBatch.markDone(result.Id())
 }
@@ -387,6 +569,9 @@ type Worker struct {
 	// Timeout for a single worker task.
 	TaskTimeout time.Duration
 
+	// Observer notified after each strategy invocation. Optional.
+	Observer ProcessingObserver
+
 	// Indicates whether this worker is closed and cannot accept new work.
 	Closed bool
 }
@@ -401,7 +586,7 @@ func (w *Worker) Start() {
 	handlerInterrupted := false
 	go func() {
 		for taskAndStrategy := range w.HandlerInputChannel {
-			result := taskAndStrategy.Strategy(w, taskAndStrategy.WorkerTask.Msg, taskAndStrategy.WorkerTask.Id())
+			result := w.runStrategy(taskAndStrategy)
 		Loop:
 			for !handlerInterrupted {
 				timeout := time.NewTimer(5 * time.Second)
@@ -437,6 +622,23 @@ func (w *Worker) Start() {
 	}()
 }
 
+// Runs a given strategy on a given task. A panic in the strategy is logged and turned into a StrategyPanickedResult so that the worker survives it.
+func (w *Worker) runStrategy(taskAndStrategy *TaskAndStrategy) (result WorkerResult) {
+	id := taskAndStrategy.WorkerTask.Id()
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			Errorf(w, "Strategy panicked while processing task %s: %v\n%s", id, r, debug.Stack())
+			result = &StrategyPanickedResult{id: id, Reason: r}
+		}
+		if w.Observer != nil {
+			w.Observer.OnProcessed(taskAndStrategy.WorkerTask.Msg, time.Since(start), resultError(result))
+		}
+	}()
+
+	return taskAndStrategy.Strategy(w, taskAndStrategy.WorkerTask.Msg, id)
+}
+
 func (w *Worker) Stop() {
 	close(w.InputChannel)
 	close(w.HandlerInputChannel)
@@ -451,6 +653,18 @@ type FailedCallback func(*WorkerManager) FailedDecision
 // A callback that is triggered when a worker fails to process a single message.
 type FailedAttemptCallback func(*Task, WorkerResult) FailedDecision
 
+// A callback that is triggered after each offset commit attempt. Error is nil if the offsets were committed successfully.
+type CommitCallback func(offsets map[TopicAndPartition]int64, err error)
+
+// A callback that is triggered by Consumer.Checkpoint once offsets of all owned partitions are committed and before processing resumes.
+type CheckpointCallback func(id string, offsets map[TopicAndPartition]int64) error
+
+// A function that commits offsets to a storage owned by the application, e.g. in the same transaction as the processing results.
+type ExternalOffsetCommitter func(offsets map[TopicAndPartition]int64) error
+
+// A function that returns the offset committed with an ExternalOffsetCommitter for a given partition, or InvalidOffset if there is none.
+type ExternalOffsetFetcher func(topicPartition TopicAndPartition) (int64, error)
+
 // A counter used to track whether we reached the configurable threshold of failed messages within a given time window.
 type FailureCounter struct {
 	count           int32
@@ -552,6 +766,31 @@ func (wr *SuccessfulResult) Success() bool {
 	return true
 }
 
+// An implementation of WorkerResult interface representing an incoming message that was intentionally not processed, e.g. because it expired.
+// Its offset is committed like for a successfully processed message, but it is counted as skipped rather than acknowledged.
+type SkippedResult struct {
+	id TaskId
+}
+
+// Creates a new SkippedResult for given TaskId.
+func NewSkippedResult(id TaskId) *SkippedResult {
+	return &SkippedResult{id}
+}
+
+func (sr *SkippedResult) String() string {
+	return fmt.Sprintf("{Skipped: %s}", sr.Id())
+}
+
+// Returns an id of task that was skipped.
+func (wr *SkippedResult) Id() TaskId {
+	return wr.id
+}
+
+// Always returns true for SkippedResult.
+func (wr *SkippedResult) Success() bool {
+	return true
+}
+
 // An implementation of WorkerResult interface representing a failure to process incoming message.
 type ProcessingFailedResult struct {
 	id TaskId
@@ -595,6 +834,42 @@ func (wr *TimedOutResult) Success() bool {
 	return false
 }
 
+// An implementation of WorkerResult interface representing a panic in a strategy while processing incoming message.
+type StrategyPanickedResult struct {
+	id TaskId
+
+	// Value the strategy panicked with.
+	Reason interface{}
+}
+
+func (sr *StrategyPanickedResult) String() string {
+	return fmt.Sprintf("{Panicked: %s, Reason: %v}", sr.Id(), sr.Reason)
+}
+
+// Returns an id of task that was processed.
+func (wr *StrategyPanickedResult) Id() TaskId {
+	return wr.id
+}
+
+// Always returns false for StrategyPanickedResult.
+func (wr *StrategyPanickedResult) Success() bool {
+	return false
+}
+
+// Returns an error describing a given unsuccessful WorkerResult, nil for a successful one.
+func resultError(result WorkerResult) error {
+	if result == nil {
+		return fmt.Errorf("Strategy returned no result")
+	}
+	if result.Success() {
+		return nil
+	}
+	if panicked, ok := result.(*StrategyPanickedResult); ok {
+		return fmt.Errorf("Strategy panicked while processing task %s: %v", panicked.Id(), panicked.Reason)
+	}
+	return fmt.Errorf("Failed to process task %s", result.Id())
+}
+
 // Type representing a task id. Consists from topic, partition and offset of a message being processed.
 type TaskId struct {
 	// Message's topic and partition
@@ -16,6 +16,10 @@ limitations under the License. */
 package go_kafka_client
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -125,6 +129,59 @@ func TestWorker(t *testing.T) {
 	}
 }
 
+type observedProcessing struct {
+	msg      *Message
+	duration time.Duration
+	err      error
+}
+
+type recordingObserver struct {
+	processed chan observedProcessing
+}
+
+func (this *recordingObserver) OnProcessed(msg *Message, duration time.Duration, err error) {
+	this.processed <- observedProcessing{msg, duration, err}
+}
+
+func TestWorkerProcessingObserver(t *testing.T) {
+	observer := &recordingObserver{processed: make(chan observedProcessing, 3)}
+	worker := &Worker{
+		InputChannel:         make(chan *TaskAndStrategy),
+		OutputChannel:        make(chan WorkerResult),
+		HandlerInputChannel:  make(chan *TaskAndStrategy),
+		HandlerOutputChannel: make(chan WorkerResult),
+		TaskTimeout:          time.Second,
+		Observer:             observer,
+	}
+	worker.Start()
+
+	task := &Task{Msg: &Message{Topic: "test", Offset: 1}}
+	worker.InputChannel <- &TaskAndStrategy{task, sleepStrategy(50 * time.Millisecond)}
+	assert(t, (<-worker.OutputChannel).Success(), true)
+	processed := <-observer.processed
+	assert(t, processed.msg, task.Msg)
+	assert(t, processed.err, nil)
+	if processed.duration < 50*time.Millisecond || processed.duration > time.Second {
+		t.Errorf("Unexpected processing duration %s", processed.duration)
+	}
+
+	worker.InputChannel <- &TaskAndStrategy{task, failStrategy}
+	assert(t, (<-worker.OutputChannel).Success(), false)
+	processed = <-observer.processed
+	assertNot(t, processed.err, nil)
+	if processed.duration >= 50*time.Millisecond {
+		t.Errorf("Unexpected processing duration %s", processed.duration)
+	}
+
+	worker.InputChannel <- &TaskAndStrategy{task, func(_ *Worker, _ *Message, _ TaskId) WorkerResult { panic("boom") }}
+	assert(t, (<-worker.OutputChannel).Success(), false)
+	processed = <-observer.processed
+	assertNot(t, processed.err, nil)
+	if !strings.Contains(processed.err.Error(), "boom") {
+		t.Errorf("Expected panic reason in error, got %s", processed.err)
+	}
+}
+
 func TestWorkerManager(t *testing.T) {
 	wmid := "test-WM"
 	config := DefaultConsumerConfig()
@@ -135,7 +192,7 @@ func TestWorkerManager(t *testing.T) {
 	config.OffsetStorage = mockZk
 	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
 
-	metrics := newConsumerMetrics(wmid, "")
+	metrics := newConsumerMetrics(wmid, "", nil)
 	closeConsumer := make(chan bool)
 	manager := NewWorkerManager(wmid, config, topicPartition, metrics, closeConsumer)
 
@@ -164,14 +221,458 @@ func TestWorkerManager(t *testing.T) {
 	<-manager.Stop()
 
 	//make sure we don't lose our offsets
-	if len(mockZk.commitHistory) != 1 {
+	if len(mockZk.commits()) != 1 {
 		t.Errorf("Worker manager should commit offset only once")
 	}
-	if mockZk.commitHistory[topicPartition] != 5 {
+	if mockZk.commits()[topicPartition] != 5 {
 		t.Errorf("Worker manager should commit offset 5")
 	}
 }
 
+func TestWorkerManagerCommitCallback(t *testing.T) {
+	wmid := "test-WM-commit-callback"
+	config := DefaultConsumerConfig()
+	config.NumWorkers = 3
+	config.Strategy = goodStrategy
+	config.OffsetsCommitMaxRetries = 1
+	config.OffsetStorage = &failingOffsetStorage{}
+	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
+
+	commits := make(chan error, 10)
+	var committedOffsets map[TopicAndPartition]int64
+	config.OnCommit = func(offsets map[TopicAndPartition]int64, err error) {
+		committedOffsets = offsets
+		commits <- err
+	}
+
+	metrics := newConsumerMetrics(wmid, "", nil)
+	manager := NewWorkerManager(wmid, config, topicPartition, metrics, make(chan bool))
+	go manager.Start()
+
+	manager.inputChannel <- []*Message{&Message{Offset: 0}, &Message{Offset: 1}, &Message{Offset: 2}}
+	time.Sleep(1 * time.Second)
+	<-manager.Stop()
+
+	select {
+	case err := <-commits:
+		if err == nil {
+			t.Error("Commit callback should receive an error from failing offset storage")
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Commit callback was not called")
+	}
+	assert(t, committedOffsets[topicPartition], int64(2))
+	assert(t, metrics.offsetCommitFailures().Count(), int64(1))
+}
+
+func TestWorkerManagerMessageFilter(t *testing.T) {
+	wmid := "test-WM-filter"
+	config := DefaultConsumerConfig()
+	config.NumWorkers = 3
+	processed := make(chan int64, 10)
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		processed <- msg.Offset
+		return NewSuccessfulResult(id)
+	}
+	config.MessageFilter = func(msg *Message) bool {
+		return msg.Offset%2 == 0
+	}
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
+
+	metrics := newConsumerMetrics(wmid, "", nil)
+	manager := NewWorkerManager(wmid, config, topicPartition, metrics, make(chan bool))
+	go manager.Start()
+
+	manager.inputChannel <- []*Message{&Message{Offset: 0}, &Message{Offset: 1}, &Message{Offset: 2}, &Message{Offset: 3}}
+	time.Sleep(1 * time.Second)
+	assert(t, manager.GetLargestOffset(), int64(3))
+
+	//all messages in this batch are filtered out
+	manager.inputChannel <- []*Message{&Message{Offset: 5}, &Message{Offset: 7}}
+	time.Sleep(1 * time.Second)
+	assert(t, manager.GetLargestOffset(), int64(7))
+
+	//dead lettered messages are skipped as well
+	manager.inputChannel <- []*Message{&Message{Offset: 8, deadLettered: true}}
+	time.Sleep(1 * time.Second)
+	assert(t, manager.GetLargestOffset(), int64(8))
+
+	<-manager.Stop()
+	close(processed)
+	for offset := range processed {
+		if offset%2 != 0 || offset == 8 {
+			t.Errorf("Skipped message with offset %d should not be processed", offset)
+		}
+	}
+	assert(t, mockZk.commits()[topicPartition], int64(8))
+}
+
+func TestWorkerManagerMaxMessageAge(t *testing.T) {
+	wmid := "test-WM-max-age"
+	config := DefaultConsumerConfig()
+	config.NumWorkers = 3
+	processed := make(chan int64, 10)
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		processed <- msg.Offset
+		return NewSuccessfulResult(id)
+	}
+	now := time.Now()
+	config.MessageTimestamp = func(msg *Message) time.Time {
+		return now.Add(-time.Duration(msg.Offset) * time.Minute)
+	}
+	config.MaxMessageAge = 2*time.Minute + 30*time.Second
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
+
+	metrics := newConsumerMetrics(wmid, "", nil)
+	manager := NewWorkerManager(wmid, config, topicPartition, metrics, make(chan bool))
+	go manager.Start()
+
+	//offsets are minutes old, so everything after offset 2 is stale
+	manager.inputChannel <- []*Message{&Message{Offset: 1}, &Message{Offset: 2}, &Message{Offset: 4}, &Message{Offset: 5}}
+	time.Sleep(1 * time.Second)
+	assert(t, manager.GetLargestOffset(), int64(5))
+	assert(t, metrics.staleMessages().Count(), int64(2))
+
+	//a batch of stale messages only still advances offsets
+	manager.inputChannel <- []*Message{&Message{Offset: 6}, &Message{Offset: 7}}
+	time.Sleep(1 * time.Second)
+	assert(t, manager.GetLargestOffset(), int64(7))
+	assert(t, metrics.staleMessages().Count(), int64(4))
+
+	<-manager.Stop()
+	close(processed)
+	processedOffsets := make([]int64, 0)
+	for offset := range processed {
+		processedOffsets = append(processedOffsets, offset)
+	}
+	assert(t, len(processedOffsets), 2)
+	for _, offset := range processedOffsets {
+		if offset > 2 {
+			t.Errorf("Stale message with offset %d should not be processed", offset)
+		}
+	}
+	assert(t, mockZk.commits()[topicPartition], int64(7))
+}
+
+func TestWorkerManagerCompactByKey(t *testing.T) {
+	wmid := "test-WM-compact"
+	config := DefaultConsumerConfig()
+	config.NumWorkers = 3
+	config.CompactByKey = true
+	processed := make(chan *Message, 10)
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		processed <- msg
+		return NewSuccessfulResult(id)
+	}
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
+
+	metrics := newConsumerMetrics(wmid, "", nil)
+	manager := NewWorkerManager(wmid, config, topicPartition, metrics, make(chan bool))
+	go manager.Start()
+
+	manager.inputChannel <- []*Message{
+		&Message{Offset: 0, Key: []byte("a"), Value: []byte("a1")},
+		&Message{Offset: 1, Key: []byte("b"), Value: []byte("b1")},
+		&Message{Offset: 2, Key: []byte("a"), Value: []byte("a2")},
+		&Message{Offset: 3, Value: []byte("no key")},
+		&Message{Offset: 4, Value: []byte("no key")},
+		&Message{Offset: 5, Key: []byte("a"), Value: []byte("a3")},
+	}
+	time.Sleep(1 * time.Second)
+	assert(t, manager.GetLargestOffset(), int64(5))
+	assert(t, metrics.compactedMessages().Count(), int64(2))
+
+	//the latest value per key is the last one of the batch, even if it was superseded before
+	manager.inputChannel <- []*Message{
+		&Message{Offset: 6, Key: []byte("b"), Value: []byte("b2")},
+		&Message{Offset: 7, Key: []byte("b"), Value: []byte("b3")},
+	}
+	time.Sleep(1 * time.Second)
+	assert(t, manager.GetLargestOffset(), int64(7))
+
+	<-manager.Stop()
+	close(processed)
+	values := make(map[string]bool)
+	numProcessed := 0
+	for msg := range processed {
+		values[string(msg.Value)] = true
+		numProcessed++
+	}
+	assert(t, numProcessed, 5)
+	for _, value := range []string{"b1", "no key", "a3", "b3"} {
+		if !values[value] {
+			t.Errorf("Latest value %s should be processed", value)
+		}
+	}
+	assert(t, mockZk.commits()[topicPartition], int64(7))
+}
+
+func TestWorkerManagerStrategyPanic(t *testing.T) {
+	wmid := "test-WM-panic"
+	config := DefaultConsumerConfig()
+	config.NumWorkers = 3
+	config.MaxWorkerRetries = 1
+	config.WorkerBackoff = 10 * time.Millisecond
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		if msg.Offset == 1 {
+			panic("boom")
+		}
+		return NewSuccessfulResult(id)
+	}
+	failed := make(chan WorkerResult, 10)
+	config.WorkerFailedAttemptCallback = func(_ *Task, result WorkerResult) FailedDecision {
+		failed <- result
+		return CommitOffsetAndContinue
+	}
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
+
+	metrics := newConsumerMetrics(wmid, "", nil)
+	manager := NewWorkerManager(wmid, config, topicPartition, metrics, make(chan bool))
+	go manager.Start()
+
+	manager.inputChannel <- []*Message{&Message{Offset: 0}, &Message{Offset: 1}, &Message{Offset: 2}}
+	time.Sleep(1 * time.Second)
+
+	select {
+	case result := <-failed:
+		if _, ok := result.(*StrategyPanickedResult); !ok {
+			t.Errorf("Panicking strategy should produce a StrategyPanickedResult, got %v", result)
+		}
+		assert(t, result.Id().Offset, int64(1))
+	default:
+		t.Fatal("Panicking strategy should be treated as a failed message")
+	}
+	assert(t, metrics.strategyPanics().Count(), int64(2))
+
+	//workers survive the panic and keep processing
+	manager.inputChannel <- []*Message{&Message{Offset: 3}, &Message{Offset: 4}}
+	time.Sleep(1 * time.Second)
+	checkAllWorkersAvailable(t, manager)
+	<-manager.Stop()
+	assert(t, mockZk.commits()[topicPartition], int64(4))
+}
+
+func TestWorkerManagerSkippedResult(t *testing.T) {
+	wmid := "test-WM-skip"
+	config := DefaultConsumerConfig()
+	config.NumWorkers = 2
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		if msg.Offset%2 == 1 {
+			return NewSkippedResult(id)
+		}
+		return NewSuccessfulResult(id)
+	}
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
+
+	metrics := newConsumerMetrics(wmid, "", nil)
+	manager := NewWorkerManager(wmid, config, topicPartition, metrics, make(chan bool))
+	go manager.Start()
+
+	manager.inputChannel <- []*Message{&Message{Offset: 0}, &Message{Offset: 1}, &Message{Offset: 2}, &Message{Offset: 3}}
+	time.Sleep(500 * time.Millisecond)
+	<-manager.Stop()
+
+	assert(t, mockZk.commits()[topicPartition], int64(3))
+	assert(t, metrics.numAcks().Count(), int64(2))
+	assert(t, metrics.numSkips().Count(), int64(2))
+	assert(t, metrics.activeWorkers().Count(), int64(0))
+}
+
+func TestWorkerManagerEndToEndLatency(t *testing.T) {
+	wmid := "test-WM-latency"
+	config := DefaultConsumerConfig()
+	config.Strategy = goodStrategy
+	now := time.Now()
+	produced := map[int64]time.Time{
+		0: now.Add(-1 * time.Second),
+		1: now.Add(-2 * time.Second),
+		2: now.Add(time.Hour),
+	}
+	config.MessageTimestamp = func(msg *Message) time.Time {
+		return produced[msg.Offset]
+	}
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+
+	metrics := newConsumerMetrics(wmid, "", nil)
+	manager := NewWorkerManager(wmid, config, TopicAndPartition{"fakeTopic", int32(0)}, metrics, make(chan bool))
+	go manager.Start()
+
+	manager.inputChannel <- []*Message{&Message{Topic: "fakeTopic", Offset: 0}, &Message{Topic: "fakeTopic", Offset: 1}, &Message{Topic: "fakeTopic", Offset: 2}}
+	time.Sleep(1 * time.Second)
+	<-manager.Stop()
+
+	latency := metrics.topicEndToEndLatency("fakeTopic")
+	assert(t, latency.Count(), int64(3))
+	//the message from the future is clamped to zero latency
+	assert(t, latency.Min(), int64(0))
+	assert(t, metrics.clockSkews().Count(), int64(1))
+	if latency.Max() < int64(2*time.Second) || latency.Max() > int64(3*time.Second) {
+		t.Errorf("Max end-to-end latency should be about 2s, actual %s", time.Duration(latency.Max()))
+	}
+}
+
+func TestWorkerManagerOrderedWithinPartition(t *testing.T) {
+	config := DefaultConsumerConfig()
+	config.NumWorkers = 5
+	config.OrderedWithinPartition = true
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+
+	var lock sync.Mutex
+	processed := make(map[int32][]int64)
+	inFlight := make(map[int32]int)
+	maxInFlight := make(map[int32]int)
+	total, maxTotal := 0, 0
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		inLock(&lock, func() {
+			inFlight[msg.Partition]++
+			total++
+			if inFlight[msg.Partition] > maxInFlight[msg.Partition] {
+				maxInFlight[msg.Partition] = inFlight[msg.Partition]
+			}
+			if total > maxTotal {
+				maxTotal = total
+			}
+		})
+		time.Sleep(50 * time.Millisecond)
+		inLock(&lock, func() {
+			processed[msg.Partition] = append(processed[msg.Partition], msg.Offset)
+			inFlight[msg.Partition]--
+			total--
+		})
+		return NewSuccessfulResult(id)
+	}
+
+	managers := make([]*WorkerManager, 0)
+	for partition := int32(0); partition < 2; partition++ {
+		wmid := fmt.Sprintf("test-WM-ordered-%d", partition)
+		manager := NewWorkerManager(wmid, config, TopicAndPartition{"fakeTopic", partition}, newConsumerMetrics(wmid, "", nil), make(chan bool))
+		go manager.Start()
+		managers = append(managers, manager)
+	}
+
+	expected := make([]int64, 0)
+	for i, manager := range managers {
+		batch := make([]*Message, 0)
+		for offset := int64(0); offset < 10; offset++ {
+			if i == 0 {
+				expected = append(expected, offset)
+			}
+			batch = append(batch, &Message{Topic: "fakeTopic", Partition: int32(i), Offset: offset})
+		}
+		go func(manager *WorkerManager, batch []*Message) {
+			manager.inputChannel <- batch
+		}(manager, batch)
+	}
+
+	time.Sleep(2 * time.Second)
+	for _, manager := range managers {
+		<-manager.Stop()
+	}
+
+	for partition := int32(0); partition < 2; partition++ {
+		assert(t, processed[partition], expected)
+		assert(t, maxInFlight[partition], 1)
+	}
+	assert(t, maxTotal, 2)
+}
+
+func TestWorkerManagerCoalescesCommits(t *testing.T) {
+	wmid := "test-WM-coalesce"
+	config := DefaultConsumerConfig()
+	config.NumWorkers = 3
+	config.Strategy = goodStrategy
+	config.OffsetCommitInterval = 1 * time.Minute
+	mockZk := newMockZookeeperCoordinator()
+	config.Coordinator = mockZk
+	config.OffsetStorage = mockZk
+	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
+
+	metrics := newConsumerMetrics(wmid, "", nil)
+	manager := NewWorkerManager(wmid, config, topicPartition, metrics, make(chan bool))
+	go manager.Start()
+
+	for i := 0; i < 5; i++ {
+		manager.inputChannel <- []*Message{&Message{Offset: int64(i * 2)}, &Message{Offset: int64(i*2 + 1)}}
+	}
+	time.Sleep(1 * time.Second)
+	<-manager.Stop()
+
+	assert(t, metrics.offsetCommitRequests().Count(), int64(1))
+	assert(t, mockZk.commits()[topicPartition], int64(9))
+}
+
+func TestWorkerManagerQuarantinesStalledPartition(t *testing.T) {
+	wmid := "test-WM-quarantine"
+	config := DefaultConsumerConfig()
+	config.NumWorkers = 1
+	config.MaxWorkerRetries = 1000
+	config.WorkerBackoff = 10 * time.Millisecond
+	config.OffsetCommitInterval = 50 * time.Millisecond
+	config.PartitionStallTimeout = 200 * time.Millisecond
+	config.WorkerManagersStopTimeout = 1 * time.Second
+	config.Strategy = func(_ *Worker, msg *Message, id TaskId) WorkerResult {
+		if msg.Partition == 0 {
+			return NewProcessingFailedResult(id)
+		}
+		return NewSuccessfulResult(id)
+	}
+	metrics := newConsumerMetrics(wmid, "", nil)
+
+	stalled := TopicAndPartition{"fakeTopic", int32(0)}
+	stalledZk := newMockZookeeperCoordinator()
+	stalledConfig := *config
+	stalledConfig.OffsetStorage = stalledZk
+	stalledManager := NewWorkerManager(wmid+"-0", &stalledConfig, stalled, metrics, make(chan bool))
+	go stalledManager.Start()
+
+	healthy := TopicAndPartition{"fakeTopic", int32(1)}
+	healthyZk := newMockZookeeperCoordinator()
+	healthyConfig := *config
+	healthyConfig.OffsetStorage = healthyZk
+	healthyManager := NewWorkerManager(wmid+"-1", &healthyConfig, healthy, metrics, make(chan bool))
+	go healthyManager.Start()
+
+	go func() {
+		stalledManager.inputChannel <- []*Message{&Message{Topic: stalled.Topic, Partition: stalled.Partition, Offset: 0}}
+	}()
+	for offset := int64(0); offset < 5; offset++ {
+		healthyManager.inputChannel <- []*Message{&Message{Topic: healthy.Topic, Partition: healthy.Partition, Offset: offset}}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	assert(t, stalledManager.Quarantined(), true)
+	assert(t, healthyManager.Quarantined(), false)
+	assert(t, metrics.quarantinedPartitions().Count(), int64(1))
+	assert(t, healthyZk.commits()[healthy], int64(4))
+
+	consumer := &Consumer{
+		config:         config,
+		workerManagers: map[TopicAndPartition]*WorkerManager{stalled: stalledManager, healthy: healthyManager},
+	}
+	assert(t, consumer.stopWorkerManagers(), true)
+	_, committed := stalledZk.commits()[stalled]
+	assert(t, committed, false)
+}
+
 func checkAllWorkersAvailable(t *testing.T, wm *WorkerManager) {
 	Trace("test", "Checking all workers availability")
 	//if all workers are available we shouldn't be able to insert one more available worker
@@ -192,7 +693,7 @@ func benchmarkWorkerManager(b *testing.B, numWorkers int, msgsPerBatch int, slee
 	config.OffsetStorage = mockZk
 	topicPartition := TopicAndPartition{"fakeTopic", int32(0)}
 
-	metrics := newConsumerMetrics(wmid, "")
+	metrics := newConsumerMetrics(wmid, "", nil)
 	closeConsumer := make(chan bool)
 	manager := NewWorkerManager(wmid, config, topicPartition, metrics, closeConsumer)
 
@@ -316,3 +817,13 @@ func BenchmarkWorkerManager_25worker_100msg_100us(b *testing.B) {
 func BenchmarkWorkerManager_25worker_100msg_1000us(b *testing.B) {
 	benchmarkWorkerManager(b, 25, 10, 1000*time.Microsecond)
 }
+
+type failingOffsetStorage struct{}
+
+func (f *failingOffsetStorage) GetOffset(group string, topic string, partition int32) (int64, error) {
+	return InvalidOffset, errors.New("Failed to get offset")
+}
+
+func (f *failingOffsetStorage) CommitOffset(group string, topic string, partition int32, offset int64) error {
+	return errors.New("Failed to commit offset")
+}
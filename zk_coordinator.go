@@ -23,8 +23,11 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	metrics "github.com/rcrowley/go-metrics"
 	"github.com/samuel/go-zookeeper/zk"
 )
 
@@ -48,6 +51,8 @@ type ZookeeperCoordinator struct {
 	unsubscribe chan bool
 	closed      bool
 	watches     map[string]*GroupWatch
+	state       int32
+	stateGauge  metrics.Gauge
 }
 
 func (this *ZookeeperCoordinator) String() string {
@@ -61,6 +66,8 @@ func NewZookeeperCoordinator(Config *ZookeeperConfig) *ZookeeperCoordinator {
 		config:      Config,
 		unsubscribe: make(chan bool),
 		watches:     make(map[string]*GroupWatch),
+		state:       int32(zk.StateDisconnected),
+		stateGauge:  metrics.NewGauge(),
 	}
 }
 
@@ -90,11 +97,25 @@ func (this *ZookeeperCoordinator) Disconnect() {
 	Infof(this, "Closing connection to ZK at %s\n", this.config.ZookeeperConnect)
 	this.closed = true
 	this.zkConn.Close()
+	this.setState(zk.StateDisconnected)
+}
+
+// Returns the last known state of connection to Zookeeper.
+func (this *ZookeeperCoordinator) ZKState() zk.State {
+	return zk.State(atomic.LoadInt32(&this.state))
+}
+
+func (this *ZookeeperCoordinator) setState(state zk.State) {
+	atomic.StoreInt32(&this.state, int32(state))
+	this.stateGauge.Update(int64(state))
 }
 
 func (this *ZookeeperCoordinator) listenConnectionEvents(connectionEvents <-chan zk.Event) {
 	for event := range connectionEvents { // will be closed by zk.Conn when it's stopped
 		Infof(this, "Received zkConnectionEvent Type: %s Server: %s State: %s", event.Type.String(), event.Server, event.State.String())
+		if event.Type == zk.EventSession {
+			this.setState(event.State)
+		}
 		switch event.State {
 		case zk.StateConnecting:
 			// (Re)connecting to a ZK server
@@ -116,6 +137,7 @@ func (this *ZookeeperCoordinator) listenConnectionEvents(connectionEvents <-chan
 			// Nothing to do
 		}
 	}
+	this.setState(zk.StateDisconnected)
 	Infof(this, "Stopping listening connection events")
 }
 
@@ -853,19 +875,36 @@ func (this *ZookeeperCoordinator) Unsubscribe() {
 // Returns true if claim is successful, false and error explaining failure otherwise.
 func (this *ZookeeperCoordinator) ClaimPartitionOwnership(Groupid string, Topic string, Partition int32, consumerThreadId ConsumerThreadId) (bool, error) {
 	var err error
+	maxRetries, backoff := this.claimRetries()
 	backoffMultiplier := 1
-	for i := 0; i <= this.config.MaxRequestRetries; i++ {
+	for i := 0; i <= maxRetries; i++ {
 		ok, err := this.tryClaimPartitionOwnership(Groupid, Topic, Partition, consumerThreadId)
 		if ok {
 			return ok, err
 		}
 		Tracef(this, "Claim failed for topic %s, partition %d after %d-th retry", Topic, Partition, i)
-		time.Sleep(this.config.RequestBackoff * time.Duration(backoffMultiplier))
-		backoffMultiplier++
+		if i < maxRetries {
+			time.Sleep(backoff * time.Duration(backoffMultiplier))
+			backoffMultiplier++
+		}
 	}
 	return false, err
 }
 
+// Returns MaxClaimRetries and ClaimBackoff, falling back to MaxRequestRetries and RequestBackoff if they are not set,
+// e.g. for configs created before claim retries were configurable.
+func (this *ZookeeperCoordinator) claimRetries() (int, time.Duration) {
+	maxRetries := this.config.MaxClaimRetries
+	if maxRetries == 0 {
+		maxRetries = this.config.MaxRequestRetries
+	}
+	backoff := this.config.ClaimBackoff
+	if backoff == 0 {
+		backoff = this.config.RequestBackoff
+	}
+	return maxRetries, backoff
+}
+
 func (this *ZookeeperCoordinator) tryClaimPartitionOwnership(group string, topic string, partition int32, consumerThreadId ConsumerThreadId) (bool, error) {
 	dirs := newZKGroupTopicDirs(this.config.Root, group, topic)
 	this.createOrUpdatePathParentMayNotExistFailFast(dirs.ConsumerOwnerDir, make([]byte, 0))
@@ -1097,7 +1136,8 @@ type ZookeeperConfig struct {
 	/* Zookeeper hosts */
 	ZookeeperConnect []string
 
-	/* Zookeeper session timeout */
+	/* Zookeeper session timeout. A consumer whose session expires is removed from its group and its partitions are rebalanced.
+	The Zookeeper client sends heartbeats every third of this timeout. */
 	ZookeeperSessionTimeout time.Duration
 
 	/* Max retries for any request except CommitOffset. CommitOffset is controlled by ConsumerConfig.OffsetsCommitMaxRetries. */
@@ -1106,6 +1146,12 @@ type ZookeeperConfig struct {
 	/* Backoff to retry any request */
 	RequestBackoff time.Duration
 
+	/* Max retries to claim a partition that is still owned by another consumer during rebalance. MaxRequestRetries is used if not set. */
+	MaxClaimRetries int
+
+	/* Backoff to retry claiming a partition, multiplied by the number of the retry. RequestBackoff is used if not set. */
+	ClaimBackoff time.Duration
+
 	/* kafka Root */
 	Root string
 
@@ -1120,6 +1166,8 @@ func NewZookeeperConfig() *ZookeeperConfig {
 	config.ZookeeperSessionTimeout = 5 * time.Second
 	config.MaxRequestRetries = 3
 	config.RequestBackoff = 150 * time.Millisecond
+	config.MaxClaimRetries = 3
+	config.ClaimBackoff = 150 * time.Millisecond
 	config.Root = ""
 	config.PanicHandler = func(e error) {
 		panic(e)
@@ -1132,9 +1180,11 @@ func NewZookeeperConfig() *ZookeeperConfig {
 // The file accepts the following fields:
 //  zookeeper.connect
 //  zookeeper.kafka.root
-//  zookeeper.connection.timeout
+//  zookeeper.connection.session.timeout
 //  zookeeper.max.request.retries
 //  zookeeper.request.backoff
+//  zookeeper.max.claim.retries
+//  zookeeper.claim.backoff
 // The configuration file entries should be constructed in key=value syntax. A # symbol at the beginning
 // of a line indicates a comment. Blank lines are ignored. The file should end with a newline character.
 func ZookeeperConfigFromFile(filename string) (*ZookeeperConfig, error) {
@@ -1156,6 +1206,12 @@ func ZookeeperConfigFromFile(filename string) (*ZookeeperConfig, error) {
 	if err := setDurationConfig(&config.RequestBackoff, z["zookeeper.request.backoff"]); err != nil {
 		return nil, err
 	}
+	if err := setIntConfig(&config.MaxClaimRetries, z["zookeeper.max.claim.retries"]); err != nil {
+		return nil, err
+	}
+	if err := setDurationConfig(&config.ClaimBackoff, z["zookeeper.claim.backoff"]); err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
@@ -1205,11 +1261,14 @@ func newZKGroupTopicDirs(root string, group string, topic string) *zkGroupTopicD
 //used for tests only
 type mockZookeeperCoordinator struct {
 	commitHistory map[TopicAndPartition]int64
+	partitions    map[string][]int32
+	commitLock    sync.Mutex
 }
 
 func newMockZookeeperCoordinator() *mockZookeeperCoordinator {
 	return &mockZookeeperCoordinator{
 		commitHistory: make(map[TopicAndPartition]int64),
+		partitions:    make(map[string][]int32),
 	}
 }
 
@@ -1230,9 +1289,19 @@ func (mzk *mockZookeeperCoordinator) GetConsumersPerTopic(group string, excludeI
 func (mzk *mockZookeeperCoordinator) GetConsumersInGroup(group string) ([]string, error) {
 	panic("Not implemented")
 }
-func (mzk *mockZookeeperCoordinator) GetAllTopics() ([]string, error) { panic("Not implemented") }
+func (mzk *mockZookeeperCoordinator) GetAllTopics() ([]string, error) {
+	topics := make([]string, 0)
+	for topic := range mzk.partitions {
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
 func (mzk *mockZookeeperCoordinator) GetPartitionsForTopics(topics []string) (map[string][]int32, error) {
-	panic("Not implemented")
+	partitions := make(map[string][]int32)
+	for _, topic := range topics {
+		partitions[topic] = mzk.partitions[topic]
+	}
+	return partitions, nil
 }
 func (mzk *mockZookeeperCoordinator) GetAllBrokers() ([]*BrokerInfo, error) { panic("Not implemented") }
 func (mzk *mockZookeeperCoordinator) GetOffset(group string, topic string, partition int32) (int64, error) {
@@ -1255,12 +1324,25 @@ func (mzk *mockZookeeperCoordinator) ClaimPartitionOwnership(group string, topic
 	panic("Not implemented")
 }
 func (mzk *mockZookeeperCoordinator) ReleasePartitionOwnership(group string, topic string, partition int32) error {
-	panic("Not implemented")
+	return nil
 }
 func (mzk *mockZookeeperCoordinator) CommitOffset(group string, topic string, partition int32, offset int64) error {
-	mzk.commitHistory[TopicAndPartition{topic, partition}] = offset
+	inLock(&mzk.commitLock, func() {
+		mzk.commitHistory[TopicAndPartition{topic, partition}] = offset
+	})
 	return nil
 }
+
+// Returns a copy of the offsets committed so far, safe to read while worker managers are still committing.
+func (mzk *mockZookeeperCoordinator) commits() map[TopicAndPartition]int64 {
+	commits := make(map[TopicAndPartition]int64)
+	inLock(&mzk.commitLock, func() {
+		for topicPartition, offset := range mzk.commitHistory {
+			commits[topicPartition] = offset
+		}
+	})
+	return commits
+}
 func (this *mockZookeeperCoordinator) RemoveOldApiRequests(group string) error {
 	panic("Not implemented")
 }
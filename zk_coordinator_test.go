@@ -133,3 +133,42 @@ func testDeregisterConsumer(t *testing.T) {
 	}
 	assert(t, exists, false)
 }
+
+func TestZkConnectionState(t *testing.T) {
+	zookeeper := NewZookeeperCoordinator(NewZookeeperConfig())
+	assert(t, zookeeper.ZKState(), zk.StateDisconnected)
+
+	events := make(chan zk.Event)
+	go zookeeper.listenConnectionEvents(events)
+
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateConnecting}
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+	// the event above has been processed once the next one is accepted
+	events <- zk.Event{Type: zk.EventNodeCreated, State: zk.StateUnknown}
+	assert(t, zookeeper.ZKState(), zk.StateHasSession)
+	assert(t, zookeeper.stateGauge.Value(), int64(zk.StateHasSession))
+
+	events <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+	events <- zk.Event{Type: zk.EventNodeCreated, State: zk.StateUnknown}
+	assert(t, zookeeper.ZKState(), zk.StateExpired)
+	assert(t, zookeeper.stateGauge.Value(), int64(zk.StateExpired))
+
+	close(events)
+	time.Sleep(100 * time.Millisecond)
+	assert(t, zookeeper.ZKState(), zk.StateDisconnected)
+}
+
+func TestZkClaimRetries(t *testing.T) {
+	config := NewZookeeperConfig()
+	config.MaxClaimRetries = 5
+	config.ClaimBackoff = time.Second
+	maxRetries, backoff := NewZookeeperCoordinator(config).claimRetries()
+	assert(t, maxRetries, 5)
+	assert(t, backoff, time.Second)
+
+	//configs that don't set claim retries use request retries
+	config = &ZookeeperConfig{MaxRequestRetries: 2, RequestBackoff: 100 * time.Millisecond}
+	maxRetries, backoff = NewZookeeperCoordinator(config).claimRetries()
+	assert(t, maxRetries, 2)
+	assert(t, backoff, 100*time.Millisecond)
+}